@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"git.fiblab.net/sim/backend/config"
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/samber/lo"
+)
+
+// gin上下文中调用方的key Key of the caller in the gin context
+const principalKey = "auth.principal"
+
+// 已认证的调用方 An authenticated caller
+type Principal struct {
+	Subject string   // 用户名 User name
+	Groups  []string // 所属组 Groups
+	Admin   bool     // 可访问所有模拟 Access to all simulations
+}
+
+// 能否访问指定owner与group的模拟，owner与group均为空的模拟是公开的，nil表示匿名调用方
+// Whether the caller can access a simulation with the owner and group, simulations without owner and group are public,
+// nil means an anonymous caller
+func (p *Principal) CanAccess(owner, group string) bool {
+	if owner == "" && group == "" {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	return p.Admin || owner != "" && owner == p.Subject || group != "" && lo.Contains(p.Groups, group)
+}
+
+// 调用方，匿名时为nil The caller, nil if anonymous
+func FromContext(c *gin.Context) *Principal {
+	if v, ok := c.Get(principalKey); ok {
+		return v.(*Principal)
+	}
+	return nil
+}
+
+type Authenticator struct {
+	required   bool
+	apiKeys    []config.APIKey
+	adminToken string       // 为空时不启用 disabled if empty
+	jwt        *jwtVerifier // 未配置JWT时为nil nil if JWT is not configured
+}
+
+// adminToken为以Bearer令牌传递的管理员令牌 adminToken is the admin token given as a bearer token
+func New(c config.Auth, adminToken string) (*Authenticator, error) {
+	a := &Authenticator{required: c.Required, apiKeys: c.APIKeys, adminToken: adminToken}
+	switch {
+	case c.JWTSecret != "":
+		a.jwt = &jwtVerifier{alg: "HS256", key: []byte(c.JWTSecret)}
+	case c.JWTPublicKeyFile != "":
+		data, err := os.ReadFile(c.JWTPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read JWT public key: %w", err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse JWT public key %s: %w", c.JWTPublicKeyFile, err)
+		}
+		a.jwt = &jwtVerifier{alg: "RS256", key: key}
+	}
+	return a, nil
+}
+
+// 按API密钥查找调用方，逐个做常数时间比较 Find the caller by the API key with constant-time comparisons
+func (a *Authenticator) apiKey(key string) *Principal {
+	var found *config.APIKey
+	for i := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(a.apiKeys[i].Key)) == 1 {
+			found = &a.apiKeys[i]
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return &Principal{Subject: found.Subject, Groups: found.Groups, Admin: found.Admin}
+}
+
+// 认证请求：X-API-Key请求头、"Authorization: Bearer <JWT>"请求头，queryToken为true时还接受access_token查询参数
+// Authenticate the request with the X-API-Key header or the "Authorization: Bearer <JWT>" header, also with the
+// access_token query parameter if queryToken is true
+func (a *Authenticator) authenticate(c *gin.Context, queryToken bool) (*Principal, error) {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		if p := a.apiKey(key); p != nil {
+			return p, nil
+		}
+		return nil, errors.New("invalid API key")
+	}
+	var token string
+	if queryToken {
+		token = c.Query("access_token")
+	}
+	if header := c.GetHeader("Authorization"); header != "" {
+		if !strings.HasPrefix(header, "Bearer ") {
+			return nil, errors.New("unsupported authorization scheme")
+		}
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	if token == "" {
+		return nil, nil
+	}
+	if a.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1 {
+		return &Principal{Subject: "admin", Admin: true}, nil
+	}
+	if a.jwt == nil {
+		return nil, errors.New("JWT authentication is not configured")
+	}
+	claims, err := a.jwt.verify(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Subject: claims.Subject, Groups: claims.Groups, Admin: claims.Admin}, nil
+}
+
+// 认证中间件，凭据无效时返回401，未携带凭据时为匿名调用方（Required时返回401）
+// Authentication middleware, 401 for invalid credentials, requests without credentials are anonymous (401 if Required)
+func Authenticate(a *Authenticator) gin.HandlerFunc {
+	return authenticate(a, false)
+}
+
+// WebSocket的认证中间件，浏览器无法设置请求头，因此还接受access_token查询参数
+// Authentication middleware for WebSockets, which also accepts the access_token query parameter
+// because browsers cannot set headers on them
+func AuthenticateWebSocket(a *Authenticator) gin.HandlerFunc {
+	return authenticate(a, true)
+}
+
+func authenticate(a *Authenticator, queryToken bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := a.authenticate(c, queryToken)
+		if err == nil && p == nil && a.required {
+			err = errors.New("authentication required")
+		}
+		if err != nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			util.AbortWithError(c, util.WrapError(util.CodeUnauthorized, err))
+			return
+		}
+		if p != nil {
+			c.Set(principalKey, p)
+		}
+	}
+}
+
+// 只允许管理员，匿名调用方返回401，其他调用方返回403
+// Only admins are allowed, 401 for anonymous callers and 403 for the others
+func RequireAdmin(c *gin.Context) {
+	p := FromContext(c)
+	if p == nil {
+		c.Header("WWW-Authenticate", "Bearer")
+		util.AbortWithError(c, util.NewError(util.CodeUnauthorized, "authentication required"))
+		return
+	}
+	if !p.Admin {
+		util.AbortWithError(c, util.NewError(util.CodeForbidden, "admin required"))
+		return
+	}
+}
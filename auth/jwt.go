@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var errBadToken = errors.New("invalid token")
+
+// 校验exp与nbf时容许的时钟偏差 Clock skew allowed when checking exp and nbf
+const jwtLeeway = 30 * time.Second
+
+// JWT签名校验，只接受本地配置的算法与密钥
+// JWT signature verifier, only the locally configured algorithm and key are accepted
+type jwtVerifier struct {
+	alg string // "HS256"或"RS256" "HS256" or "RS256"
+	key any    // HS256为[]byte，RS256为*rsa.PublicKey []byte for HS256, *rsa.PublicKey for RS256
+}
+
+// 支持的JWT声明 Supported JWT claims
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Groups []string `json:"groups"`
+	Admin  bool     `json:"admin"`
+}
+
+// 校验JWT的签名与有效期并返回其声明，没有exp的令牌无效
+// Verify the signature and the validity period of a JWT and return its claims, tokens without exp are invalid
+func (v *jwtVerifier) verify(token string, now time.Time) (*jwtClaims, error) {
+	claims := &jwtClaims{}
+	_, err := jwt.ParseWithClaims(
+		token, claims,
+		func(*jwt.Token) (any, error) { return v.key, nil },
+		// 不信任令牌声明的算法，防止算法混淆 the algorithm in the token is not trusted to prevent algorithm confusion
+		jwt.WithValidMethods([]string{v.alg}),
+		jwt.WithTimeFunc(func() time.Time { return now }),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBadToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", errBadToken)
+	}
+	return claims, nil
+}
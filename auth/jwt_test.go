@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, header, claims string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	unsigned := enc([]byte(header)) + "." + enc([]byte(claims))
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsigned + "." + enc(sig)
+}
+
+func TestRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := jwt.ParseRSAPublicKeyFromPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &jwtVerifier{alg: "RS256", key: public}
+	now := time.Unix(1000, 0)
+
+	claims, err := v.verify(signRS256(t, key, `{"alg":"RS256"}`, `{"sub":"alice","groups":["lab"],"exp":2000}`), now)
+	if err != nil || claims.Subject != "alice" || len(claims.Groups) != 1 {
+		t.Fatalf("unexpected claims %+v: %v", claims, err)
+	}
+	// 容许时钟偏差 the clock skew is allowed
+	if _, err := v.verify(signRS256(t, key, `{"alg":"RS256"}`, `{"sub":"alice","exp":990,"nbf":1010}`), now); err != nil {
+		t.Fatalf("want token within the leeway valid: %v", err)
+	}
+	for name, token := range map[string]string{
+		"expired":       signRS256(t, key, `{"alg":"RS256"}`, `{"sub":"alice","exp":900}`),
+		"not yet valid": signRS256(t, key, `{"alg":"RS256"}`, `{"sub":"alice","exp":2000,"nbf":1100}`),
+		"no exp":        signRS256(t, key, `{"alg":"RS256"}`, `{"sub":"alice"}`),
+		"no subject":    signRS256(t, key, `{"alg":"RS256"}`, `{"exp":2000}`),
+		"wrong alg":     signRS256(t, key, `{"alg":"HS256"}`, `{"sub":"alice","exp":2000}`),
+		"none alg":      signRS256(t, key, `{"alg":"none"}`, `{"sub":"alice","exp":2000}`),
+		"malformed":     "a.b",
+	} {
+		if _, err := v.verify(token, now); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+	// 篡改声明后签名无效 the signature is invalid after the claims are modified
+	token := strings.Split(signRS256(t, key, `{"alg":"RS256"}`, `{"sub":"alice","exp":2000}`), ".")
+	other := strings.Split(signRS256(t, key, `{"alg":"RS256"}`, `{"sub":"admin","admin":true,"exp":2000}`), ".")
+	if _, err := v.verify(token[0]+"."+other[1]+"."+token[2], now); err == nil {
+		t.Error("forged claims: want error")
+	}
+}
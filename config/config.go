@@ -0,0 +1,418 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// 环境变量key Environment variable keys
+const (
+	EnvConfigFile       = "CONFIG_FILE"
+	EnvMongoURI         = "MONGO_URI"
+	EnvMongoDB          = "MONGO_DB"
+	EnvPgURI            = "PG_URI"
+	EnvPort             = "PORT"
+	EnvAllowList        = "ALLOWLIST"
+	EnvDenyList         = "DENYLIST"
+	EnvTrustedProxies   = "TRUSTED_PROXIES"
+	EnvBlackList        = "BLACKLIST" // DENYLIST的旧名 Former name of DENYLIST
+	EnvRateLimit        = "RATE_LIMIT"
+	EnvRateLimitBurst   = "RATE_LIMIT_BURST"
+	EnvExpensiveLimit   = "RATE_LIMIT_EXPENSIVE"
+	EnvExpensiveBurst   = "RATE_LIMIT_EXPENSIVE_BURST"
+	EnvRequestTimeout   = "REQUEST_TIMEOUT"
+	EnvIntervalCacheTTL = "INTERVAL_CACHE_TTL"
+	EnvStorageBackend   = "STORAGE_BACKEND"
+	EnvSQLitePath       = "SQLITE_PATH"
+	EnvMapDir           = "MAP_DIR"
+	EnvMapCacheTTL      = "MAP_CACHE_TTL"
+	EnvMapCacheMaxNodes = "MAP_CACHE_MAX_NODES"
+	EnvAdminToken       = "ADMIN_TOKEN"
+	EnvAuthRequired     = "AUTH_REQUIRED"
+	EnvJWTSecret        = "JWT_SECRET"
+	EnvJWTPublicKeyFile = "JWT_PUBLIC_KEY_FILE"
+	EnvLogLevel         = "LOG_LEVEL"
+	EnvLogFormat        = "LOG_FORMAT"
+	EnvTraceExporter    = "TRACE_EXPORTER"
+	EnvTraceFile        = "TRACE_FILE"
+	EnvOTLPEndpoint     = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	EnvServiceName      = "OTEL_SERVICE_NAME"
+)
+
+// 支持"20s"、"1m30s"等写法的时间长度 Duration written as "20s", "1m30s", etc.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+type Cache struct {
+	IntervalTTL Duration `yaml:"interval_ttl" toml:"interval_ttl"` // 路况记录间隔缓存有效期 TTL of the cached road status interval
+	MapTTL      Duration `yaml:"map_ttl" toml:"map_ttl"`           // 地图几何缓存有效期 TTL of the cached map geometry
+	// 地图几何缓存的节点总数上限，超出时淘汰最久未使用的地图
+	// Max total nodes of the cached map geometry, the least recently used maps are evicted beyond it
+	MapMaxNodes int `yaml:"map_max_nodes" toml:"map_max_nodes"`
+}
+
+type Storage struct {
+	Backend    string `yaml:"backend" toml:"backend"`         // 存储后端：pg或file Storage backend: pg or file
+	SQLitePath string `yaml:"sqlite_path" toml:"sqlite_path"` // file后端的SQLite数据库路径 SQLite database path of the file backend
+	MapDir     string `yaml:"map_dir" toml:"map_dir"`         // file后端的地图文件目录 Map file directory of the file backend
+}
+
+type APIKey struct {
+	Key     string   `yaml:"key" toml:"key"`
+	Subject string   `yaml:"subject" toml:"subject"` // 用户名，与模拟的owner对应 User name, matched with the owner of simulations
+	Groups  []string `yaml:"groups" toml:"groups"`   // 所属组，与模拟的group对应 Groups, matched with the group of simulations
+	Admin   bool     `yaml:"admin" toml:"admin"`     // 可访问所有模拟 Access to all simulations
+}
+
+// API密钥与JWT认证，JWT只能配置HS256密钥与RS256公钥之一
+// API key and JWT authentication, only one of the HS256 secret and the RS256 public key can be configured for JWT
+type Auth struct {
+	Required         bool     `yaml:"required" toml:"required"`                       // 拒绝匿名请求 Reject anonymous requests
+	APIKeys          []APIKey `yaml:"api_keys" toml:"api_keys"`                       // 通过X-API-Key请求头传递 Given by the X-API-Key header
+	JWTSecret        string   `yaml:"jwt_secret" toml:"jwt_secret"`                   // HS256密钥 HS256 secret
+	JWTPublicKeyFile string   `yaml:"jwt_public_key_file" toml:"jwt_public_key_file"` // RS256公钥PEM文件 PEM file of the RS256 public key
+}
+
+// 令牌桶限流预算，Rate为0时不限流 Token bucket budget, unlimited if Rate is 0
+type RateBudget struct {
+	Rate  float64 `yaml:"rate" toml:"rate"`   // 每秒补充的令牌数 Tokens refilled per second
+	Burst int     `yaml:"burst" toml:"burst"` // 桶容量 Bucket capacity
+}
+
+// 按IP与调用方限流，IP访问名单可在运行时通过SIGHUP重新加载
+// Rate limits per IP and per caller, the IP access lists can be reloaded at runtime by SIGHUP
+type RateLimit struct {
+	Default   RateBudget `yaml:"default" toml:"default"`     // 一般接口 General routes
+	Expensive RateBudget `yaml:"expensive" toml:"expensive"` // 车辆、行人与地图几何接口 Cars, people and map geometry routes
+	AllowList []string   `yaml:"allowlist" toml:"allowlist"` // 不限流的IP或CIDR IPs or CIDRs exempt from the limits
+	DenyList  []string   `yaml:"denylist" toml:"denylist"`   // 拒绝访问的IP或CIDR Banned IPs or CIDRs
+}
+
+type Log struct {
+	Level  string `yaml:"level" toml:"level"`   // debug、info、warn或error debug, info, warn or error
+	Format string `yaml:"format" toml:"format"` // json或text json or text
+}
+
+// 请求与存储调用的span导出，基于OpenTelemetry SDK Export of the spans of requests and storage calls by the OpenTelemetry SDK
+type Tracing struct {
+	Exporter     string `yaml:"exporter" toml:"exporter"`           // none、file或otlp none, file or otlp
+	File         string `yaml:"file" toml:"file"`                   // file导出的文件，每行一个JSON编码的span File of the file exporter, a JSON encoded span per line
+	OTLPEndpoint string `yaml:"otlp_endpoint" toml:"otlp_endpoint"` // OTLP/HTTP收集器地址 URL of the OTLP/HTTP collector
+	ServiceName  string `yaml:"service_name" toml:"service_name"`
+}
+
+type Config struct {
+	MongoURI       string   `yaml:"mongo_uri" toml:"mongo_uri"`             // MongoDB URI，存储地图数据 MongoDB URI for map data
+	MongoDB        string   `yaml:"mongo_db" toml:"mongo_db"`               // MongoDB数据库名 MongoDB database name
+	PgURI          string   `yaml:"pg_uri" toml:"pg_uri"`                   // PostgreSQL URI，存储DBRecorder输出 PostgreSQL URI for DBRecorder output
+	Port           string   `yaml:"port" toml:"port"`                       // 服务端口 Server port
+	RequestTimeout Duration `yaml:"request_timeout" toml:"request_timeout"` // 单个请求的超时时间 Timeout of a single request
+	AdminToken     string   `yaml:"admin_token" toml:"admin_token"`         // 管理员的Bearer令牌，为空时不启用 Bearer token of the admin, disabled if empty
+	// 信任其X-Forwarded-For的反向代理IP或CIDR，为空时客户端IP为连接的对端地址
+	// IPs or CIDRs of the reverse proxies whose X-Forwarded-For is trusted, the client IP is the peer address if empty
+	TrustedProxies []string  `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	Cache          Cache     `yaml:"cache" toml:"cache"`
+	Storage        Storage   `yaml:"storage" toml:"storage"`
+	Auth           Auth      `yaml:"auth" toml:"auth"`
+	RateLimit      RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Log            Log       `yaml:"log" toml:"log"`
+	Tracing        Tracing   `yaml:"tracing" toml:"tracing"`
+}
+
+func Default() *Config {
+	return &Config{
+		Port:           "8080",
+		RequestTimeout: Duration{20 * time.Second},
+		TrustedProxies: []string{},
+		Cache: Cache{
+			IntervalTTL: Duration{1 * time.Minute},
+			MapTTL:      Duration{30 * time.Minute},
+			MapMaxNodes: 20000000,
+		},
+		Storage: Storage{
+			Backend: "pg",
+		},
+		Auth: Auth{
+			APIKeys: []APIKey{},
+		},
+		RateLimit: RateLimit{
+			Default:   RateBudget{Rate: 50, Burst: 100},
+			Expensive: RateBudget{Rate: 5, Burst: 10},
+			AllowList: []string{},
+			DenyList:  []string{},
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "moss-webui-backend",
+		},
+	}
+}
+
+// 加载配置，优先级：环境变量 > 配置文件(CONFIG_FILE，支持YAML/TOML) > 默认值
+// Load the config, priority: environment variables > config file (CONFIG_FILE, YAML/TOML) > defaults
+func Load() (*Config, error) {
+	c := Default()
+	if path := os.Getenv(EnvConfigFile); path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("unsupported config file format %s, should be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	setString := func(key string, dst *string) {
+		if v := os.Getenv(key); v != "" {
+			*dst = v
+		}
+	}
+	setDuration := func(key string, dst *Duration) error {
+		if v := os.Getenv(key); v != "" {
+			if err := dst.UnmarshalText([]byte(v)); err != nil {
+				return fmt.Errorf("bad %s: %w", key, err)
+			}
+		}
+		return nil
+	}
+	setString(EnvMongoURI, &c.MongoURI)
+	setString(EnvMongoDB, &c.MongoDB)
+	setString(EnvPgURI, &c.PgURI)
+	setString(EnvPort, &c.Port)
+	setString(EnvStorageBackend, &c.Storage.Backend)
+	setString(EnvSQLitePath, &c.Storage.SQLitePath)
+	setString(EnvMapDir, &c.Storage.MapDir)
+	setString(EnvAdminToken, &c.AdminToken)
+	setString(EnvJWTSecret, &c.Auth.JWTSecret)
+	setString(EnvJWTPublicKeyFile, &c.Auth.JWTPublicKeyFile)
+	setString(EnvLogLevel, &c.Log.Level)
+	setString(EnvLogFormat, &c.Log.Format)
+	setString(EnvTraceExporter, &c.Tracing.Exporter)
+	setString(EnvTraceFile, &c.Tracing.File)
+	setString(EnvOTLPEndpoint, &c.Tracing.OTLPEndpoint)
+	setString(EnvServiceName, &c.Tracing.ServiceName)
+	if v := os.Getenv(EnvAuthRequired); v != "" {
+		required, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("bad %s: %w", EnvAuthRequired, err)
+		}
+		c.Auth.Required = required
+	}
+	setList := func(key string, dst *[]string) {
+		if v := os.Getenv(key); v != "" {
+			*dst = make([]string, 0)
+			for _, ip := range strings.Split(v, ",") {
+				if ip = strings.TrimSpace(ip); ip != "" {
+					*dst = append(*dst, ip)
+				}
+			}
+		}
+	}
+	setFloat := func(key string, dst *float64) error {
+		if v := os.Getenv(key); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("bad %s: %w", key, err)
+			}
+			*dst = f
+		}
+		return nil
+	}
+	setInt := func(key string, dst *int) error {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("bad %s: %w", key, err)
+			}
+			*dst = n
+		}
+		return nil
+	}
+	setList(EnvAllowList, &c.RateLimit.AllowList)
+	setList(EnvBlackList, &c.RateLimit.DenyList)
+	setList(EnvDenyList, &c.RateLimit.DenyList)
+	setList(EnvTrustedProxies, &c.TrustedProxies)
+	if err := setFloat(EnvRateLimit, &c.RateLimit.Default.Rate); err != nil {
+		return err
+	}
+	if err := setInt(EnvRateLimitBurst, &c.RateLimit.Default.Burst); err != nil {
+		return err
+	}
+	if err := setFloat(EnvExpensiveLimit, &c.RateLimit.Expensive.Rate); err != nil {
+		return err
+	}
+	if err := setInt(EnvExpensiveBurst, &c.RateLimit.Expensive.Burst); err != nil {
+		return err
+	}
+	if err := setDuration(EnvRequestTimeout, &c.RequestTimeout); err != nil {
+		return err
+	}
+	if err := setDuration(EnvIntervalCacheTTL, &c.Cache.IntervalTTL); err != nil {
+		return err
+	}
+	if err := setDuration(EnvMapCacheTTL, &c.Cache.MapTTL); err != nil {
+		return err
+	}
+	return setInt(EnvMapCacheMaxNodes, &c.Cache.MapMaxNodes)
+}
+
+// 检查配置是否完整有效 Check whether the config is complete and valid
+func (c *Config) Validate() error {
+	errs := make([]string, 0)
+	switch c.Storage.Backend {
+	case "pg":
+		if c.MongoURI == "" {
+			errs = append(errs, fmt.Sprintf("%s (mongo_uri) is required", EnvMongoURI))
+		}
+		if c.MongoDB == "" {
+			errs = append(errs, fmt.Sprintf("%s (mongo_db) is required", EnvMongoDB))
+		}
+		if c.PgURI == "" {
+			errs = append(errs, fmt.Sprintf("%s (pg_uri) is required", EnvPgURI))
+		}
+	case "file":
+		if c.Storage.SQLitePath == "" {
+			errs = append(errs, fmt.Sprintf("%s (storage.sqlite_path) is required by the file backend", EnvSQLitePath))
+		}
+		if c.Storage.MapDir == "" {
+			errs = append(errs, fmt.Sprintf("%s (storage.map_dir) is required by the file backend", EnvMapDir))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("%s (storage.backend) should be pg or file but got %q", EnvStorageBackend, c.Storage.Backend))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port <= 0 || port > 65535 {
+		errs = append(errs, fmt.Sprintf("%s (port) should be a number in [1, 65535] but got %q", EnvPort, c.Port))
+	}
+	if c.RequestTimeout.Duration <= 0 {
+		errs = append(errs, fmt.Sprintf("%s (request_timeout) should be positive", EnvRequestTimeout))
+	}
+	if c.Cache.IntervalTTL.Duration <= 0 {
+		errs = append(errs, fmt.Sprintf("%s (cache.interval_ttl) should be positive", EnvIntervalCacheTTL))
+	}
+	if c.Cache.MapTTL.Duration <= 0 {
+		errs = append(errs, fmt.Sprintf("%s (cache.map_ttl) should be positive", EnvMapCacheTTL))
+	}
+	if c.Cache.MapMaxNodes <= 0 {
+		errs = append(errs, fmt.Sprintf("%s (cache.map_max_nodes) should be positive", EnvMapCacheMaxNodes))
+	}
+	if c.Auth.JWTSecret != "" && c.Auth.JWTPublicKeyFile != "" {
+		errs = append(errs, fmt.Sprintf("only one of %s (auth.jwt_secret) and %s (auth.jwt_public_key_file) can be set", EnvJWTSecret, EnvJWTPublicKeyFile))
+	}
+	keys := make(map[string]bool)
+	for i, k := range c.Auth.APIKeys {
+		if k.Key == "" || k.Subject == "" {
+			errs = append(errs, fmt.Sprintf("auth.api_keys[%d] should have key and subject", i))
+		} else if keys[k.Key] {
+			errs = append(errs, fmt.Sprintf("auth.api_keys[%d] duplicates an earlier key", i))
+		}
+		keys[k.Key] = true
+	}
+	for _, b := range []struct {
+		budget     RateBudget
+		rate, name string
+	}{
+		{c.RateLimit.Default, EnvRateLimit, "default"},
+		{c.RateLimit.Expensive, EnvExpensiveLimit, "expensive"},
+	} {
+		if b.budget.Rate < 0 {
+			errs = append(errs, fmt.Sprintf("%s (rate_limit.%s.rate) should not be negative", b.rate, b.name))
+		} else if b.budget.Rate > 0 && b.budget.Burst < 1 {
+			errs = append(errs, fmt.Sprintf("rate_limit.%s.burst should be positive when the rate is set", b.name))
+		}
+	}
+	for _, ip := range append(append([]string{}, c.RateLimit.AllowList...), c.RateLimit.DenyList...) {
+		if !validIPOrCIDR(ip) {
+			errs = append(errs, fmt.Sprintf("%q in rate_limit.allowlist/denylist is not an IP or CIDR", ip))
+		}
+	}
+	for _, ip := range c.TrustedProxies {
+		if !validIPOrCIDR(ip) {
+			errs = append(errs, fmt.Sprintf("%q in %s (trusted_proxies) is not an IP or CIDR", ip, EnvTrustedProxies))
+		}
+	}
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Sprintf("%s (log.level) should be debug, info, warn or error but got %q", EnvLogLevel, c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Sprintf("%s (log.format) should be json or text but got %q", EnvLogFormat, c.Log.Format))
+	}
+	switch c.Tracing.Exporter {
+	case "none":
+	case "file":
+		if c.Tracing.File == "" {
+			errs = append(errs, fmt.Sprintf("%s (tracing.file) is required by the file exporter", EnvTraceFile))
+		}
+	case "otlp":
+		if c.Tracing.OTLPEndpoint == "" {
+			errs = append(errs, fmt.Sprintf("%s (tracing.otlp_endpoint) is required by the otlp exporter", EnvOTLPEndpoint))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("%s (tracing.exporter) should be none, file or otlp but got %q", EnvTraceExporter, c.Tracing.Exporter))
+	}
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// 是否为IP或CIDR Whether the string is an IP or a CIDR
+func validIPOrCIDR(s string) bool {
+	if strings.Contains(s, "/") {
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	}
+	return net.ParseIP(s) != nil
+}
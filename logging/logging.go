@@ -0,0 +1,161 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"git.fiblab.net/sim/backend/config"
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 请求ID的请求头与响应头 Request and response header of the request ID
+const RequestIDHeader = "X-Request-ID"
+
+// 客户端传入的请求ID只接受安全字符 Only safe characters are accepted in the request IDs from clients
+var requestIDChecker = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// 按配置设置默认的slog日志 Set the default slog logger by the config
+func Init(c config.Log) {
+	var level slog.Level
+	// 配置已检查过级别 the level has been validated with the config
+	_ = level.UnmarshalText([]byte(c.Level))
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if c.Format == "text" {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// 一个请求的存储调用统计，超时后处理函数可能仍在并发写入
+// Storage call statistics of a request, the handler may still write concurrently after the timeout
+type requestStats struct {
+	mu      sync.Mutex
+	queries int
+	dbTime  time.Duration
+	rows    int
+}
+
+type requestInfo struct {
+	id    string
+	stats *requestStats
+}
+
+type requestKey struct{}
+
+func fromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestKey{}).(*requestInfo)
+	return info
+}
+
+// 上下文中的请求ID，不在请求中时为空 Request ID in the context, empty outside requests
+func RequestID(ctx context.Context) string {
+	if info := fromContext(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// 带请求ID的日志 Logger with the request ID
+func FromContext(ctx context.Context) *slog.Logger {
+	if id := RequestID(ctx); id != "" {
+		return slog.Default().With("request_id", id)
+	}
+	return slog.Default()
+}
+
+// 记录一次存储调用，rows<0表示不返回行 Record a storage call, rows<0 means no rows are returned
+func RecordQuery(ctx context.Context, d time.Duration, rows int) {
+	info := fromContext(ctx)
+	if info == nil {
+		return
+	}
+	s := info.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries++
+	s.dbTime += d
+	if rows > 0 {
+		s.rows += rows
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 为请求分配ID（沿用客户端的X-Request-ID）并在结束时输出一行请求日志，包括模拟名、step范围、bbox、返回行数与数据库耗时
+// Assign an ID to the request (reusing X-Request-ID of the client) and write a request log line at the end,
+// including the simulation name, step range, bbox, returned rows and database time
+func Middleware(c *gin.Context) {
+	start := time.Now()
+	id := c.GetHeader(RequestIDHeader)
+	if !requestIDChecker.MatchString(id) {
+		id = newRequestID()
+	}
+	c.Header(RequestIDHeader, id)
+	ctx, sink := util.WithErrorSink(c.Request.Context())
+	info := &requestInfo{id: id, stats: &requestStats{}}
+	c.Request = c.Request.WithContext(context.WithValue(ctx, requestKey{}, info))
+
+	c.Next()
+
+	status := c.Writer.Status()
+	attrs := []slog.Attr{
+		slog.String("request_id", id),
+		slog.String("method", c.Request.Method),
+		slog.String("route", c.FullPath()),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int("bytes", c.Writer.Size()),
+		slog.String("client_ip", c.ClientIP()),
+	}
+	if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+	}
+	if name := c.Param("name"); name != "" {
+		attrs = append(attrs, slog.String("sim", name))
+	}
+	for _, key := range []string{"begin", "end", "interval"} {
+		if v, ok := c.GetQuery(key); ok {
+			attrs = append(attrs, slog.String(key, v))
+		}
+	}
+	if bbox := []string{c.Query("lng1"), c.Query("lat1"), c.Query("lng2"), c.Query("lat2")}; bbox[0] != "" {
+		attrs = append(attrs, slog.String("bbox", strings.Join(bbox, ",")))
+	}
+	s := info.stats
+	s.mu.Lock()
+	if s.queries > 0 {
+		attrs = append(attrs,
+			slog.Int("db_queries", s.queries),
+			slog.Float64("db_ms", float64(s.dbTime.Microseconds())/1000),
+			slog.Int("rows", s.rows),
+		)
+	}
+	s.mu.Unlock()
+	if err := sink.Last(); err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()), slog.String("code", string(util.AsError(err).Code)))
+	}
+	level := slog.LevelInfo
+	if status >= 500 {
+		level = slog.LevelError
+	} else if status >= 400 {
+		level = slog.LevelWarn
+	}
+	slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
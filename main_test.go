@@ -0,0 +1,1289 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.fiblab.net/sim/backend/config"
+	"git.fiblab.net/sim/backend/ratelimit"
+	"git.fiblab.net/sim/backend/simple"
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-contrib/gzip"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+// 基于file存储后端与testdata中的夹具数据的端到端测试
+// End-to-end tests on the file storage backend with the fixtures in testdata
+
+var router *gin.Engine
+
+// 夹具SQLite数据库路径 Path of the fixture SQLite database
+var sqlitePath string
+
+var limiter *ratelimit.Limiter
+
+// 信任其X-Forwarded-For的反向代理 The reverse proxy whose X-Forwarded-For is trusted
+const trustedProxy = "192.0.2.10"
+
+// httptest的默认客户端IP与WebSocket测试的回环地址不限流
+// The default client IP of httptest and the loopback address of the WebSocket tests are exempt from the limits
+var rateLimit = config.RateLimit{
+	Default:   config.RateBudget{Rate: 1, Burst: 3},
+	Expensive: config.RateBudget{Rate: 1, Burst: 2},
+	AllowList: []string{"192.0.2.1", "127.0.0.1"},
+	DenyList:  []string{"203.0.113.0/24"},
+}
+
+const (
+	adminToken = "test-admin-token"
+	jwtSecret  = "test-jwt-secret"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	dir, err := os.MkdirTemp("", "moss-backend-test")
+	if err != nil {
+		panic(err)
+	}
+	code := func() int {
+		defer os.RemoveAll(dir)
+		if err := setupFixtures(dir); err != nil {
+			panic(err)
+		}
+		return m.Run()
+	}()
+	os.Exit(code)
+}
+
+func setupFixtures(dir string) error {
+	cfg := config.Default()
+	cfg.Storage.Backend = simple.StorageFile
+	cfg.Storage.SQLitePath = filepath.Join(dir, "simple.db")
+	cfg.Storage.MapDir = filepath.Join("testdata", "maps")
+	cfg.AdminToken = adminToken
+	cfg.Auth.JWTSecret = jwtSecret
+	cfg.Auth.APIKeys = []config.APIKey{
+		{Key: "alice-key", Subject: "alice"},
+		{Key: "bob-key", Subject: "bob", Groups: []string{"other"}},
+	}
+	cfg.RateLimit = rateLimit
+	cfg.TrustedProxies = []string{trustedProxy}
+	sqlitePath = cfg.Storage.SQLitePath
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	fixture, err := os.ReadFile(filepath.Join("testdata", "simple.sql"))
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", cfg.Storage.SQLitePath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(fixture)); err != nil {
+		return err
+	}
+	if err := simple.Init(cfg); err != nil {
+		return err
+	}
+	if err := simple.Migrate(context.Background()); err != nil {
+		return err
+	}
+	if limiter, err = ratelimit.New(cfg.RateLimit); err != nil {
+		return err
+	}
+	// 与lens.InitEngine相同，按Accept-Encoding压缩响应 compress the responses by Accept-Encoding like lens.InitEngine
+	router = gin.New()
+	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	return setupRouter(router, cfg, limiter)
+}
+
+type testResponse struct {
+	Error   string          `json:"error"`
+	Code    string          `json:"code"`
+	Details map[string]any  `json:"details"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// 发送GET请求并检查状态码，返回解析后的响应
+// Send a GET request, check the status code and return the decoded response
+func get(t *testing.T, url string, wantCode int) *testResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	router.ServeHTTP(w, req)
+	if w.Code != wantCode {
+		t.Fatalf("GET %s: want status %d but got %d, body: %s", url, wantCode, w.Code, w.Body.String())
+	}
+	res := &testResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatalf("GET %s: bad response body %s: %v", url, w.Body.String(), err)
+	}
+	return res
+}
+
+// 发送带JSON请求体与Bearer令牌的请求并检查状态码
+// Send a request with a JSON body and a bearer token, then check the status code
+func send(t *testing.T, method, url, token, body string, wantCode int) *testResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(w, req)
+	if w.Code != wantCode {
+		t.Fatalf("%s %s: want status %d but got %d, body: %s", method, url, wantCode, w.Code, w.Body.String())
+	}
+	res := &testResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil && w.Body.Len() > 0 {
+		t.Fatalf("%s %s: bad response body %s: %v", method, url, w.Body.String(), err)
+	}
+	return res
+}
+
+// 以API密钥发送GET请求并检查状态码 Send a GET request with an API key and check the status code
+func getWithAPIKey(t *testing.T, url, key string, wantCode int) *testResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-API-Key", key)
+	router.ServeHTTP(w, req)
+	if w.Code != wantCode {
+		t.Fatalf("GET %s: want status %d but got %d, body: %s", url, wantCode, w.Code, w.Body.String())
+	}
+	res := &testResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatalf("GET %s: bad response body %s: %v", url, w.Body.String(), err)
+	}
+	return res
+}
+
+// 生成HS256签名的JWT Create a JWT signed with HS256
+func signJWT(claims string, secret string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	unsigned := enc([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc(mac.Sum(nil))
+}
+
+func getData[T any](t *testing.T, url string) T {
+	t.Helper()
+	res := get(t, url, 200)
+	var data T
+	if err := json.Unmarshal(res.Data, &data); err != nil {
+		t.Fatalf("GET %s: bad data %s: %v", url, string(res.Data), err)
+	}
+	return data
+}
+
+type testFeature struct {
+	ID       float64 `json:"id"`
+	Geometry struct {
+		Type string `json:"type"`
+	} `json:"geometry"`
+}
+
+func featureIDs(features []testFeature) []int {
+	ids := make([]int, len(features))
+	for i, f := range features {
+		ids[i] = int(f.ID)
+	}
+	return ids
+}
+
+func assertIDs(t *testing.T, url string, got []int, want ...int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("GET %s: want ids %v but got %v", url, want, got)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("GET %s: want ids %v but got %v", url, want, got)
+		}
+	}
+}
+
+const bboxQuery = "lat1=39.9&lat2=40.0&lng1=116.0&lng2=116.1"
+
+func TestSims(t *testing.T) {
+	all := getData[[]simple.Metadata](t, "/simple/sims")
+	if len(all) != 5 {
+		t.Fatalf("want 5 simulations but got %d", len(all))
+	}
+	one := getData[[]simple.Metadata](t, "/simple/sims/test")
+	if len(one) != 1 || one[0].Name != "test" || one[0].Steps != 10 {
+		t.Fatalf("unexpected metadata %+v", one)
+	}
+	if res := get(t, "/simple/sims/unknown", 404); res.Error == "" {
+		t.Fatal("want error message for unknown simulation")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	const body = `{"name":"added","start":0,"steps":5,"time":1,"total_agents":1,"map":"moss.test_map",` +
+		`"min_lng":116.0,"min_lat":39.9,"max_lng":116.1,"max_lat":40.0,"version":2}`
+	send(t, http.MethodPost, "/simple/sims", "", body, 401)
+	send(t, http.MethodPost, "/simple/sims", "wrong", body, 401)
+	send(t, http.MethodPost, "/simple/sims", adminToken, `{"name":"added"}`, 400)
+	send(t, http.MethodPost, "/simple/sims", adminToken, strings.Replace(body, `"max_lng":116.1`, `"max_lng":115.0`, 1), 400)
+	send(t, http.MethodPost, "/simple/sims", adminToken, strings.Replace(body, "moss.test_map", "moss.unknown", 1), 400)
+	send(t, http.MethodPost, "/simple/sims", adminToken, strings.Replace(body, `"version":2`, `"version":3`, 1), 400)
+	send(t, http.MethodPost, "/simple/sims", adminToken, strings.Replace(body, `"added"`, `"test"`, 1), 409)
+	// 跳过存在性检查的并发注册由唯一索引拒绝 a concurrent registration past the existence check is rejected by the unique index
+	dup := &simple.Metadata{Name: "test", Map: "moss.test_map", Version: 2}
+	if err := simple.DefaultStorage().Meta.CreateMetadata(context.Background(), dup); !util.CheckIsUniqueViolation(err) {
+		t.Fatalf("want unique violation but got %v", err)
+	}
+	send(t, http.MethodPost, "/simple/sims", adminToken, body, 201)
+	one := getData[[]simple.Metadata](t, "/simple/sims/added")
+	if len(one) != 1 || one[0].Steps != 5 {
+		t.Fatalf("unexpected metadata %+v", one)
+	}
+
+	send(t, http.MethodPatch, "/simple/sims/added", adminToken, `{"steps":8,"road_status_v_min":5}`, 400)
+	send(t, http.MethodPatch, "/simple/sims/added", adminToken, `{"name":"renamed"}`, 400)
+	send(t, http.MethodPatch, "/simple/sims/unknown", adminToken, `{"steps":8}`, 404)
+	send(t, http.MethodPatch, "/simple/sims/added", adminToken, `{"steps":8,"road_status_v_min":5,"road_status_interval":5}`, 200)
+	one = getData[[]simple.Metadata](t, "/simple/sims/added")
+	if len(one) != 1 || one[0].Steps != 8 || one[0].TotalAgents != 1 {
+		t.Fatalf("unexpected metadata %+v", one)
+	}
+	get(t, "/simple/road-status-stat/added?begin=0&end=8", 200)
+
+	db, err := sql.Open("sqlite3", sqlitePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// 路况表缺少列时返回500与错误信息 a road status table without the level column fails with 500 and an error message
+	if _, err := db.Exec("CREATE TABLE added_s_road (step INT NOT NULL, id INT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if res := get(t, "/simple/road-status-stat/added?begin=0&end=8", 500); res.Error == "" {
+		t.Fatal("want error message for the broken road status table")
+	}
+	if _, err := db.Exec("CREATE TABLE added_s_cars (step INT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	send(t, http.MethodDelete, "/simple/sims/added?drop_tables=true", adminToken, "", 200)
+	get(t, "/simple/sims/added", 404)
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'added_s_cars'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("want added_s_cars dropped")
+	}
+	send(t, http.MethodDelete, "/simple/sims/added", adminToken, "", 404)
+	if all := getData[[]simple.Metadata](t, "/simple/sims"); len(all) != 5 {
+		t.Fatalf("want 5 simulations but got %d", len(all))
+	}
+}
+
+func TestAuth(t *testing.T) {
+	count := func(res *testResponse) int {
+		var all []simple.Metadata
+		if err := json.Unmarshal(res.Data, &all); err != nil {
+			t.Fatal(err)
+		}
+		return len(all)
+	}
+	// 匿名调用方只能看到公开的模拟 anonymous callers only see the public simulations
+	if n := count(get(t, "/simple/sims", 200)); n != 5 {
+		t.Fatalf("want 5 public simulations but got %d", n)
+	}
+	if n := count(getWithAPIKey(t, "/simple/sims", "alice-key", 200)); n != 6 {
+		t.Fatalf("want 6 simulations for alice but got %d", n)
+	}
+	if n := count(getWithAPIKey(t, "/simple/sims", "bob-key", 200)); n != 5 {
+		t.Fatalf("want 5 simulations for bob but got %d", n)
+	}
+	if n := count(send(t, http.MethodGet, "/simple/sims", adminToken, "", 200)); n != 6 {
+		t.Fatalf("want 6 simulations for the admin but got %d", n)
+	}
+	getWithAPIKey(t, "/simple/sims", "unknown-key", 401)
+
+	get(t, "/simple/sims/private", 401)
+	get(t, "/simple/cars/private?begin=0&end=3&"+bboxQuery, 401)
+	get(t, "/simple/compare?a=test&b=private", 401)
+	getWithAPIKey(t, "/simple/sims/private", "bob-key", 403)
+	getWithAPIKey(t, "/simple/roadlane/private", "bob-key", 403)
+	one := getData[[]simple.Metadata](t, "/simple/sims/test")
+	if one[0].Owner != nil || one[0].GroupName != nil {
+		t.Fatalf("want a public simulation but got %+v", one[0])
+	}
+	res := getWithAPIKey(t, "/simple/sims/private", "alice-key", 200)
+	if !strings.Contains(string(res.Data), `"owner":"alice"`) {
+		t.Fatalf("unexpected metadata %s", string(res.Data))
+	}
+	getWithAPIKey(t, "/simple/cars/private?begin=0&end=3&"+bboxQuery, "alice-key", 200)
+
+	// JWT的组与owner对应 the groups of JWTs are matched with the group of simulations
+	exp := time.Now().Add(time.Hour).Unix()
+	token := signJWT(fmt.Sprintf(`{"sub":"carol","groups":["lab"],"exp":%d}`, exp), jwtSecret)
+	send(t, http.MethodGet, "/simple/sims/private", token, "", 200)
+	// access_token查询参数只用于WebSocket the access_token query parameter is only for WebSockets
+	get(t, "/simple/sims/private?access_token="+token, 401)
+	send(t, http.MethodGet, "/simple/sims/private", signJWT(fmt.Sprintf(`{"sub":"dave","exp":%d}`, exp), jwtSecret), "", 403)
+	send(t, http.MethodGet, "/simple/sims/private", signJWT(fmt.Sprintf(`{"sub":"carol","groups":["lab"],"exp":%d}`, exp), "wrong"), "", 401)
+	send(t, http.MethodGet, "/simple/sims/private", signJWT(`{"sub":"carol","groups":["lab"],"exp":1}`, jwtSecret), "", 401)
+	// 非管理员不能修改模拟 non-admins cannot modify simulations
+	send(t, http.MethodPost, "/simple/sims", token, "{}", 403)
+}
+
+func TestRateLimit(t *testing.T) {
+	// 从指定IP发送GET请求 Send a GET request from the IP
+	getFrom := func(ip, key, url string, wantCode int) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.RemoteAddr = ip + ":1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		router.ServeHTTP(w, req)
+		if w.Code != wantCode {
+			t.Fatalf("GET %s from %s: want status %d but got %d, body: %s", url, ip, wantCode, w.Code, w.Body.String())
+		}
+		return w
+	}
+	const ip = "198.51.100.7"
+	cars := "/simple/cars/test?begin=0&end=3&" + bboxQuery
+	getFrom(ip, "", cars, 200)
+	getFrom(ip, "", "/simple/aoi/test?"+bboxQuery, 200)
+	if w := getFrom(ip, "", cars, 429); w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("want Retry-After 1 but got %q", w.Header().Get("Retry-After"))
+	}
+	// 一般接口有单独的预算 general routes have their own budget
+	getFrom(ip, "", "/simple/sims", 200)
+	// 已认证的调用方同时按IP与API密钥限流 authenticated callers are limited both by the IP and by the API key
+	getFrom(ip, "alice-key", cars, 429)
+	const other = "198.51.100.8"
+	getFrom(other, "alice-key", cars, 200)
+	getFrom(other, "alice-key", cars, 200)
+	getFrom("198.51.100.9", "alice-key", cars, 429)
+	getFrom("198.51.100.9", "", cars, 200)
+	// 不限流的IP the IP exempt from the limits
+	for i := 0; i < 5; i++ {
+		get(t, cars, 200)
+	}
+
+	// 只信任配置的代理转发的客户端IP only the client IP forwarded by the configured proxy is trusted
+	forwarded := func(peer string, wantCode int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/simple/sims", nil)
+		req.RemoteAddr = peer + ":1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.9")
+		router.ServeHTTP(w, req)
+		if w.Code != wantCode {
+			t.Fatalf("GET /simple/sims via %s: want status %d but got %d", peer, wantCode, w.Code)
+		}
+	}
+	forwarded("192.0.2.1", 200)
+	forwarded(trustedProxy, 403)
+
+	// 重新加载IP名单 reload the IP lists
+	getFrom("203.0.113.9", "", "/simple/sims", 403)
+	reloaded := rateLimit
+	reloaded.DenyList = []string{"198.51.100.0/24"}
+	if err := limiter.Reload(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	defer limiter.Reload(rateLimit)
+	getFrom("203.0.113.9", "", "/simple/sims", 200)
+	getFrom(ip, "", "/simple/sims", 403)
+	reloaded.DenyList = []string{"bad"}
+	if err := limiter.Reload(reloaded); err == nil {
+		t.Fatal("want error for a bad denylist")
+	}
+	getFrom(ip, "", "/simple/sims", 403)
+}
+
+func TestMetrics(t *testing.T) {
+	get(t, "/simple/road-status/test?begin=0&end=10", 200)
+	get(t, "/simple/road-status/test?begin=0&end=10", 200)
+	get(t, "/simple/cars/test?begin=0&end=3&"+bboxQuery, 200)
+	// 指标仅管理员可读 the metrics are readable by admins only
+	send(t, http.MethodGet, "/metrics", "", "", 401)
+	getWithAPIKey(t, "/metrics", "alice-key", 403)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("GET /metrics: want status 200 but got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`moss_http_requests_total{code="200",method="GET",route="/simple/road-status/:name"}`,
+		`moss_http_request_duration_seconds_bucket{method="GET",route="/simple/cars/:name",le="+Inf"}`,
+		`moss_http_response_size_bytes_count{method="GET",route="/simple/cars/:name"}`,
+		`moss_http_request_timeouts_total 0`,
+		`moss_storage_query_duration_seconds_count{op="Cars",store="sqlite"}`,
+		`moss_storage_query_duration_seconds_count{op="Lanes",store="file"}`,
+		`moss_trajectory_query_rows_count{op="Cars"}`,
+		`moss_interval_cache_requests_total{result="hit"}`,
+		"# TYPE moss_interval_cache_hit_ratio gauge",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %s in the metrics", want)
+		}
+	}
+}
+
+func TestCars(t *testing.T) {
+	url := "/simple/cars/test?begin=0&end=3&" + bboxQuery
+	cars := getData[[]simple.CarV2](t, url)
+	if len(cars) != 3 {
+		t.Fatalf("want 3 records but got %d", len(cars))
+	}
+	for i, car := range cars {
+		if car.Id != 1 || car.Step != i {
+			t.Fatalf("unexpected record %+v", car)
+		}
+	}
+	if cars[0].Direction != 0.12 {
+		t.Fatalf("direction should be rounded to 2 digits but got %v", cars[0].Direction)
+	}
+
+	url = "/simple/cars/test?begin=0&end=5&interval=2&" + bboxQuery
+	cars = getData[[]simple.CarV2](t, url)
+	if len(cars) != 3 || cars[1].Step != 2 || cars[2].Step != 4 {
+		t.Fatalf("unexpected records with interval %+v", cars)
+	}
+
+	// 第1版的列映射 column mapping of version 1
+	url = "/simple/cars/old?begin=0&end=3&" + bboxQuery
+	cars = getData[[]simple.CarV2](t, url)
+	if len(cars) != 3 || cars[2].LaneId != 2 || cars[2].V != 12 || cars[2].Model != "" || cars[2].Z != 0 {
+		t.Fatalf("unexpected version 1 records %+v", cars)
+	}
+	res := get(t, "/simple/cars/future?begin=0&end=3&"+bboxQuery, 422)
+	if res.Code != "unsupported_version" || res.Details["version"] != float64(3) ||
+		fmt.Sprint(res.Details["supportedVersions"]) != "[1 2]" || res.Error == "" {
+		t.Fatalf("unexpected unsupported version response %+v", res)
+	}
+	res = get(t, "/simple/cars/unknown?begin=0&end=3&"+bboxQuery, 404)
+	if res.Code != "simulation_not_found" || res.Details["simulation"] != "unknown" {
+		t.Fatalf("unexpected not found response %+v", res)
+	}
+	// 缺少必需参数 missing required parameters
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/simple/cars/test?begin=0", nil))
+	if w.Code != 400 {
+		t.Fatalf("want status 400 but got %d", w.Code)
+	}
+}
+
+func TestCarsColumnar(t *testing.T) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/simple/cars/test?begin=0&end=3&format=columnar&"+bboxQuery, nil))
+	if w.Code != 200 {
+		t.Fatalf("want status 200 but got %d", w.Code)
+	}
+	body := w.Body.Bytes()
+	if ct := w.Header().Get("Content-Type"); ct != "application/vnd.moss.columnar" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	if len(body) < 16 || string(body[:4]) != "MOSC" || body[8] != 3 {
+		t.Fatalf("bad columnar header % x", body[:16])
+	}
+}
+
+func TestPeople(t *testing.T) {
+	url := "/simple/people/test?begin=0&end=10&" + bboxQuery
+	people := getData[[]simple.Person](t, url)
+	if len(people) != 3 || people[2].ParentId != 1 {
+		t.Fatalf("unexpected records %+v", people)
+	}
+	people = getData[[]simple.Person](t, "/simple/people/old?begin=0&end=10&"+bboxQuery)
+	if len(people) != 1 || people[0].ParentId != 500000001 || people[0].Model != "" {
+		t.Fatalf("unexpected version 1 records %+v", people)
+	}
+	get(t, "/simple/people/future?begin=0&end=10&"+bboxQuery, 422)
+}
+
+func TestTrajectory(t *testing.T) {
+	url := "/simple/cars/test/1/trajectory?begin=1&end=10"
+	cars := getData[[]simple.CarV2](t, url)
+	if len(cars) != 4 || cars[0].Step != 1 || cars[3].Step != 4 || cars[3].LaneId != 2 {
+		t.Fatalf("unexpected trajectory %+v", cars)
+	}
+	url = "/simple/cars/test/1/trajectory?begin=0&end=10&interval=2&format=geojson"
+	feature := getData[simple.TrajectoryFeature](t, url)
+	if feature.Geometry.Type != "LineString" || len(feature.Geometry.Coordinates) != 3 {
+		t.Fatalf("unexpected geometry %+v", feature.Geometry)
+	}
+	if c := feature.Geometry.Coordinates[2]; c[0] != 116.05 || c[1] != 39.95 || c[3] != 4 {
+		t.Fatalf("unexpected coordinate %v", c)
+	}
+	people := getData[[]simple.Person](t, "/simple/people/test/10/trajectory?begin=0&end=10")
+	if len(people) != 3 || people[2].ParentId != 1 {
+		t.Fatalf("unexpected trajectory %+v", people)
+	}
+	get(t, "/simple/cars/test/100/trajectory?begin=0&end=10&format=geojson", 404)
+	get(t, "/simple/cars/test/x/trajectory?begin=0&end=10", 400)
+	if cars := getData[[]simple.CarV2](t, "/simple/cars/old/1/trajectory?begin=0&end=10"); len(cars) != 3 {
+		t.Fatalf("unexpected version 1 trajectory %+v", cars)
+	}
+	get(t, "/simple/cars/future/1/trajectory?begin=0&end=10", 422)
+	if data := getData[[]any](t, "/simple/people/empty/1/trajectory?begin=0&end=10"); len(data) != 0 {
+		t.Fatalf("want empty data but got %v", data)
+	}
+}
+
+func TestLaneStat(t *testing.T) {
+	url := "/simple/lane-stat/test?begin=0&end=5"
+	stats := getData[[]simple.LaneCarStat](t, url)
+	if len(stats) != 3 {
+		t.Fatalf("want 3 lanes but got %+v", stats)
+	}
+	lane1 := stats[0]
+	if lane1.LaneId != 1 || lane1.Records != 2 || lane1.Vehicles != 1 || lane1.MeanCount != 0.4 ||
+		lane1.MeanV != 10.5 || lane1.MinV != 10 || lane1.MaxV != 11 || lane1.Density == nil {
+		t.Fatalf("unexpected stat %+v", lane1)
+	}
+	// 按2步分段，最后一段只有1步 buckets of 2 steps, the last one has only 1 step
+	stats = getData[[]simple.LaneCarStat](t, "/simple/lane-stat/test?begin=0&end=5&bucket=2")
+	last := stats[len(stats)-1]
+	if len(stats) != 4 || last.Step != 4 || last.LaneId != 2 || last.MeanCount != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	// 时间段在模拟结束（step 10）处截断 the buckets are cut at the end of the simulation (step 10)
+	stats = getData[[]simple.LaneCarStat](t, "/simple/lane-stat/test?begin=0&end=100")
+	if len(stats) != 3 || stats[0].LaneId != 1 || stats[0].MeanCount != 0.2 {
+		t.Fatalf("unexpected stats beyond the end %+v", stats)
+	}
+	// 车道2在step 3与4各有一条记录，时间段[3, 10)有7步 lane 2 has a record at steps 3 and 4, the bucket [3, 10) has 7 steps
+	stats = getData[[]simple.LaneCarStat](t, "/simple/lane-stat/test?begin=3&end=100&bucket=50")
+	if len(stats) != 1 || stats[0].Step != 3 || stats[0].LaneId != 2 || stats[0].MeanCount != 0.2857 {
+		t.Fatalf("unexpected stats beyond the end %+v", stats)
+	}
+	features := getData[[]testFeature](t, "/simple/lane-stat-geojson/test?begin=0&end=5")
+	assertIDs(t, url, featureIDs(features), 1, 2, 4)
+	get(t, "/simple/lane-stat/test?begin=5&end=5", 400)
+	if stats := getData[[]simple.LaneCarStat](t, "/simple/lane-stat/old?begin=0&end=5"); len(stats) != 2 {
+		t.Fatalf("unexpected version 1 stats %+v", stats)
+	}
+	get(t, "/simple/lane-stat/future?begin=0&end=5", 422)
+	if data := getData[[]any](t, "/simple/lane-stat/empty?begin=0&end=5"); len(data) != 0 {
+		t.Fatalf("want empty data but got %v", data)
+	}
+}
+
+func TestTrafficLights(t *testing.T) {
+	url := "/simple/traffic-lights/test?begin=0&end=5&" + bboxQuery
+	tls := getData[[]simple.TrafficLight](t, url)
+	if len(tls) != 5 || tls[4].State != 3 {
+		t.Fatalf("unexpected records %+v", tls)
+	}
+	// 停车线为车道3中心线的起点 the stop line is the first node of the center line of lane 3
+	if tls[0].Lng != 116.021 || tls[0].Lat != 39.92 {
+		t.Fatalf("unexpected stop line position %+v", tls[0])
+	}
+	// 车道3穿过该范围但没有节点在范围内 lane 3 crosses the bbox without nodes inside
+	url = "/simple/traffic-lights/test?begin=0&end=5&lat1=39.924&lat2=39.926&lng1=116.0&lng2=116.1"
+	if tls := getData[[]simple.TrafficLight](t, url); len(tls) != 5 {
+		t.Fatalf("want 5 records but got %+v", tls)
+	}
+	url = "/simple/traffic-lights/test?begin=0&end=5&lat1=41&lat2=42&lng1=117&lng2=118"
+	if tls := getData[[]simple.TrafficLight](t, url); len(tls) != 0 {
+		t.Fatalf("want no records outside the lanes but got %+v", tls)
+	}
+	get(t, "/simple/traffic-lights/unknown?begin=0&end=5&"+bboxQuery, 404)
+}
+
+func TestTrafficLightPhases(t *testing.T) {
+	plans := getData[[]simple.JunctionSignalPlan](t, "/simple/traffic-light-phases/test?begin=0&end=20")
+	if len(plans) != 1 || plans[0].JunctionId != 300000001 || len(plans[0].Lanes) != 1 {
+		t.Fatalf("unexpected plans %+v", plans)
+	}
+	// 红2步/绿2步/黄1步，周期5步 red 2 steps, green 2 steps, yellow 1 step, the cycle is 5 steps
+	lane := plans[0].Lanes[0]
+	if lane.LaneId != 3 || len(lane.Phases) != 6 || lane.GreenSplit != 0.4 {
+		t.Fatalf("unexpected lane %+v", lane)
+	}
+	if p := lane.Phases[1]; p.State != 2 || p.Begin != 2 || p.End != 4 || p.Duration != 2 {
+		t.Fatalf("unexpected phase %+v", p)
+	}
+	if p := lane.Phases[5]; p.State != 3 || p.Begin != 9 || p.End != 10 {
+		t.Fatalf("the last phase should end at the end of the simulation but got %+v", p)
+	}
+	if cycle := plans[0].CycleLength; cycle == nil || *cycle != 5 {
+		t.Fatalf("unexpected cycle length %v", cycle)
+	}
+	// 不足一个周期 less than a cycle
+	plans = getData[[]simple.JunctionSignalPlan](t, "/simple/traffic-light-phases/test?begin=0&end=5")
+	if len(plans) != 1 || plans[0].CycleLength != nil || len(plans[0].Lanes[0].Phases) != 3 {
+		t.Fatalf("unexpected plans %+v", plans)
+	}
+	get(t, "/simple/traffic-light-phases/test?begin=5&end=5", 400)
+	get(t, "/simple/traffic-light-phases/unknown?begin=0&end=5", 404)
+}
+
+func TestRequestID(t *testing.T) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/simple/sims", nil))
+	if id := w.Header().Get("X-Request-ID"); len(id) != 32 {
+		t.Fatalf("want a generated request ID but got %q", id)
+	}
+	for id, want := range map[string]string{"client-id.1": "client-id.1", "bad id": ""} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/simple/sims", nil)
+		req.Header.Set("X-Request-ID", id)
+		router.ServeHTTP(w, req)
+		got := w.Header().Get("X-Request-ID")
+		if want != "" && got != want || want == "" && (got == id || len(got) != 32) {
+			t.Fatalf("request ID %q: unexpected response header %q", id, got)
+		}
+	}
+}
+
+func TestBadParams(t *testing.T) {
+	urls := []string{
+		"/simple/cars/1test?begin=0&end=3&" + bboxQuery,
+		"/simple/cars/test?end=3&" + bboxQuery,
+		"/simple/people/test?begin=0&end=3",
+		"/simple/road-status/test?begin=x&end=3",
+		"/simple/lane-stat/test?begin=5&end=5",
+		"/simple/sims/1test",
+	}
+	for _, url := range urls {
+		if res := get(t, url, 400); res.Code != "bad_request" || res.Error == "" {
+			t.Fatalf("GET %s: unexpected response %+v", url, res)
+		}
+	}
+}
+
+func TestTableNotFound(t *testing.T) {
+	urls := []string{
+		"/simple/cars/empty?begin=0&end=3&" + bboxQuery,
+		"/simple/people/empty?begin=0&end=3&" + bboxQuery,
+		"/simple/traffic-lights/empty?begin=0&end=3&" + bboxQuery,
+		"/simple/traffic-light-phases/empty?begin=0&end=3",
+		"/simple/road-status/empty?begin=0&end=3",
+		"/simple/road-status-stat/empty?begin=0&end=3",
+	}
+	for _, url := range urls {
+		if data := getData[[]any](t, url); len(data) != 0 {
+			t.Fatalf("GET %s: want empty data but got %v", url, data)
+		}
+	}
+}
+
+func TestRoadStatus(t *testing.T) {
+	// 每5步记录一次，中间的step取左侧最近的记录
+	// recorded every 5 steps, the steps in between take the nearest records on the left
+	url := "/simple/road-status/test?begin=0&end=10"
+	all := getData[[]simple.RoadStatus](t, url)
+	if len(all) != 20 {
+		t.Fatalf("want 20 records but got %d", len(all))
+	}
+	if all[6].Step != 3 || all[6].Level != 1 || all[12].Step != 6 || all[12].Level != 4 {
+		t.Fatalf("unexpected interpolation %+v %+v", all[6], all[12])
+	}
+	all = getData[[]simple.RoadStatus](t, "/simple/road-status/test?begin=0&end=10&interval=5")
+	if len(all) != 4 {
+		t.Fatalf("want 4 records but got %d", len(all))
+	}
+	// 只需要记录间隔 only the interval is required
+	if all := getData[[]simple.RoadStatus](t, "/simple/road-status/novmin?begin=0&end=10&interval=5"); len(all) != 2 || all[1].Level != 3 {
+		t.Fatalf("unexpected records without v_min %+v", all)
+	}
+	get(t, "/simple/road-status/old?begin=0&end=10", 400)
+	get(t, "/simple/road-status/future?begin=0&end=10", 422)
+	get(t, "/simple/road-status/unknown?begin=0&end=10", 404)
+}
+
+func TestRoadStatusStat(t *testing.T) {
+	stat := getData[[]simple.RoadStatusStat](t, "/simple/road-status-stat/test?begin=0&end=10&interval=5")
+	if len(stat) != 2 {
+		t.Fatalf("want 2 steps but got %d", len(stat))
+	}
+	if stat[0].Step != 0 || stat[0].MeanCongestionLevel != 2 || stat[0].LevelCounts[1] != 1 {
+		t.Fatalf("unexpected stat %+v", stat[0])
+	}
+	if stat[1].Step != 5 || stat[1].MeanCongestionLevel != 4.5 || stat[1].LevelCounts[2] != 1 || stat[1].LevelCounts[3] != 1 {
+		t.Fatalf("unexpected stat %+v", stat[1])
+	}
+	if stat := getData[[]simple.RoadStatusStat](t, "/simple/road-status-stat/novmin?begin=0&end=10&interval=5"); len(stat) != 2 {
+		t.Fatalf("unexpected stat without v_min %+v", stat)
+	}
+	get(t, "/simple/road-status-stat/old?begin=0&end=10", 400)
+}
+
+func TestRoadStatusBreakdown(t *testing.T) {
+	series := getData[[]simple.RoadStatus](t, "/simple/road-status/test/1?begin=0&end=10&interval=5")
+	if len(series) != 2 || series[0].Level != 1 || series[1].Level != 4 {
+		t.Fatalf("unexpected series %+v", series)
+	}
+	get(t, "/simple/road-status/test/x?begin=0&end=10", 400)
+
+	// 区域只包含道路1 the region only contains road 1
+	polygon := `{"type":"Polygon","coordinates":[[[116,39.9],[116.05,39.9],[116.05,39.95],[116,39.95],[116,39.9]]]}`
+	url := "/simple/road-status-region/test?begin=0&end=10&interval=5"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url, strings.NewReader(polygon)))
+	if w.Code != 200 {
+		t.Fatalf("POST %s: want status 200 but got %d, body: %s", url, w.Code, w.Body.String())
+	}
+	var region struct {
+		Data simple.RoadStatusRegionStat `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &region); err != nil {
+		t.Fatal(err)
+	}
+	if len(region.Data.Roads) != 1 || region.Data.Roads[0] != 1 || len(region.Data.Stats) != 2 ||
+		region.Data.Stats[0].MeanCongestionLevel != 1 || region.Data.Stats[1].MeanCongestionLevel != 4 {
+		t.Fatalf("unexpected region stat %+v", region.Data)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"type":"Point","coordinates":[116,39.9]}`)))
+	if w.Code != 400 {
+		t.Fatalf("want status 400 for a non-polygon region but got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url+"&aoi=500000001", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"roads":[]`) {
+		t.Fatalf("unexpected response for aoi region %d %s", w.Code, w.Body.String())
+	}
+	// 区域内的道路由v_min选取 the roads in the region are selected by v_min
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/simple/road-status-region/novmin?begin=0&end=10", strings.NewReader(polygon)))
+	if w.Code != 400 {
+		t.Fatalf("want status 400 without v_min but got %d", w.Code)
+	}
+
+	// 道路2全程>=3，道路1仅在step 5之后 road 2 is >=3 all the time, road 1 only after step 5
+	durations := getData[[]simple.RoadStatusDuration](t, "/simple/road-status-duration/test?begin=0&end=10&threshold=3")
+	if len(durations) != 2 || durations[0].Id != 2 || durations[0].Steps != 10 || durations[0].Seconds != 10 ||
+		durations[1].Id != 1 || durations[1].Steps != 5 {
+		t.Fatalf("unexpected durations %+v", durations)
+	}
+	top := getData[[]simple.RoadCongestion](t, "/simple/road-status-top/test?begin=0&end=10&n=1")
+	if len(top) != 1 || top[0].Id != 2 || top[0].MeanLevel != 4 || top[0].MaxLevel != 5 {
+		t.Fatalf("unexpected top roads %+v", top)
+	}
+	if top := getData[[]simple.RoadCongestion](t, "/simple/road-status-top/novmin?begin=0&end=10"); len(top) != 1 || top[0].MeanLevel != 2.5 {
+		t.Fatalf("unexpected top roads without v_min %+v", top)
+	}
+	get(t, "/simple/road-status-top/old?begin=0&end=10", 400)
+}
+
+func TestCompare(t *testing.T) {
+	same := getData[simple.SimComparison](t, "/simple/compare?a=test&b=test&interval=5")
+	if same.Begin != 0 || same.End != 10 || len(same.Steps) != 2 || len(same.Roads) != 2 || len(same.RoadsGeoJson) != 2 {
+		t.Fatalf("unexpected comparison %+v", same)
+	}
+	for _, r := range same.Roads {
+		if r.MeanLevel.Delta != 0 {
+			t.Fatalf("want no difference but got %+v", r)
+		}
+	}
+	// empty没有任何记录 empty has no records
+	diff := getData[simple.SimComparison](t, "/simple/compare?a=test&b=empty&begin=0&end=20&interval=5")
+	if diff.End != 10 || len(diff.Steps) != 2 || len(diff.Roads) != 0 {
+		t.Fatalf("unexpected comparison %+v", diff)
+	}
+	if s := diff.Steps[0]; s.Vehicles.A != 2 || s.Vehicles.Delta != -2 || s.MeanV.A != 9 ||
+		s.MeanCongestionLevel.A != 2 || s.MeanCongestionLevel.Delta != -2 || s.LevelCounts[1].A != 1 {
+		t.Fatalf("unexpected step %+v", s)
+	}
+	// 道路几何使用b的v_min the road geometry uses v_min of b
+	if c := getData[simple.SimComparison](t, "/simple/compare?a=novmin&b=test&interval=5"); len(c.Roads) != 1 || len(c.RoadsGeoJson) != 1 {
+		t.Fatalf("unexpected comparison without v_min %+v", c)
+	}
+	if c := getData[simple.SimComparison](t, "/simple/compare?a=novmin&b=novmin"); len(c.Roads) != 1 || len(c.RoadsGeoJson) != 0 {
+		t.Fatalf("unexpected comparison without v_min %+v", c)
+	}
+	get(t, "/simple/compare?a=test&b=old", 400)
+	get(t, "/simple/compare?a=test&b=unknown", 404)
+	get(t, "/simple/compare?a=test", 400)
+	get(t, "/simple/compare?a=test&b=test&begin=20", 400)
+}
+
+func TestLanes(t *testing.T) {
+	cases := []struct {
+		url  string
+		want []int
+	}{
+		{"/simple/junclane/test", []int{3}},
+		{"/simple/all-roadlane/test", []int{1, 2, 5}},
+		{"/simple/all-lane/test", []int{1, 2, 3, 5}},
+		// 每条道路取最外侧的行车道，id替换为道路id
+		// the outermost driving lane of each road, with the road id
+		{"/simple/roadlane/test", []int{1, 2}},
+	}
+	for _, c := range cases {
+		features := getData[[]testFeature](t, c.url)
+		assertIDs(t, c.url, featureIDs(features), c.want...)
+		for _, f := range features {
+			if f.Geometry.Type != "LineString" {
+				t.Fatalf("GET %s: unexpected geometry %s", c.url, f.Geometry.Type)
+			}
+		}
+	}
+	get(t, "/simple/junclane/unknown", 404)
+	get(t, "/simple/roadlane/old", 400)
+}
+
+func TestGeometryRegion(t *testing.T) {
+	cases := []struct {
+		url  string
+		want []int
+	}{
+		// 请求的bbox代替微观区域 the requested bbox instead of the microscopic area
+		{"/simple/all-lane/test?lng1=116&lng2=118&lat1=39&lat2=42", []int{1, 2, 3, 4, 5}},
+		{"/simple/all-lane/test?lng1=116.025&lng2=116.1&lat1=39.9&lat2=40", []int{3}},
+		{"/simple/aoi/test?lng1=116.9&lng2=117.2&lat1=40.9&lat2=41.2", []int{500000003}},
+		{"/simple/roadlane/test?lng1=116&lng2=116.5&lat1=39.9&lat2=40", []int{1}},
+		// 线段穿过范围但没有节点在范围内 a segment crossing the bbox without a node inside
+		{"/simple/all-lane/test?lng1=116.0148&lng2=116.0152&lat1=39.9148&lat2=39.9152", []int{1}},
+		// 范围完全在AOI内 the bbox inside the AOI
+		{"/simple/maps/moss.test_map/aois?lng1=116.058&lng2=116.059&lat1=39.951&lat2=39.952", []int{500000001}},
+	}
+	for _, c := range cases {
+		assertIDs(t, c.url, featureIDs(getData[[]testFeature](t, c.url)), c.want...)
+	}
+	get(t, "/simple/all-lane/test?lng1=116", 400)
+
+	polygon := `{"type":"Polygon","coordinates":[[[116,39.9],[116.015,39.9],[116.015,39.95],[116,39.95],[116,39.9]]]}`
+	aoiPolygon := `{"type":"Polygon","coordinates":[[[116.9,40.9],[117.2,40.9],[117.2,41.2],[116.9,41.2],[116.9,40.9]]]}`
+	posts := []struct {
+		url  string
+		body string
+		want []int
+	}{
+		{"/simple/all-lane/test", polygon, []int{1, 2, 5}},
+		{"/simple/roadlane/test", polygon, []int{1}},
+		{"/simple/maps/moss.test_map/lanes?type=road", polygon, []int{1, 2, 5}},
+		{"/simple/maps/moss.test_map/aois", aoiPolygon, []int{500000003}},
+	}
+	for _, c := range posts {
+		var features []testFeature
+		if err := json.Unmarshal(send(t, http.MethodPost, c.url, "", c.body, 200).Data, &features); err != nil {
+			t.Fatalf("POST %s: %v", c.url, err)
+		}
+		assertIDs(t, c.url, featureIDs(features), c.want...)
+	}
+	res := send(t, http.MethodPost, "/simple/aoi/test", "", `{"type":"Point","coordinates":[116,39.9]}`, 400)
+	if res.Code != "bad_request" {
+		t.Fatalf("unexpected response for a non-polygon region %+v", res)
+	}
+}
+
+func TestGeometryClip(t *testing.T) {
+	box := orb.Bound{Min: orb.Point{116, 39.9}, Max: orb.Point{116.015, 39.95}}
+	query := fmt.Sprintf("lng1=%v&lng2=%v&lat1=%v&lat2=%v", box.Min.Lon(), box.Max.Lon(), box.Min.Lat(), box.Max.Lat())
+	for _, url := range []string{
+		"/simple/all-lane/test?clip=true&" + query,
+		"/simple/maps/moss.test_map/lanes?clip=true&" + query,
+		"/simple/maps/moss.test_map/roads?clip=true&" + query,
+	} {
+		features := getData[[]*geojson.Feature](t, url)
+		if len(features) == 0 {
+			t.Fatalf("GET %s: no features", url)
+		}
+		for _, f := range features {
+			if !box.Pad(1e-9).Contains(f.Geometry.Bound().Min) || !box.Pad(1e-9).Contains(f.Geometry.Bound().Max) {
+				t.Fatalf("GET %s: feature %v is not clipped: %v", url, f.ID, f.Geometry)
+			}
+		}
+	}
+	// 不裁剪时返回完整的车道 the whole lanes without clipping
+	features := getData[[]*geojson.Feature](t, "/simple/all-lane/test?"+query)
+	if len(features) == 0 || box.Contains(features[0].Geometry.Bound().Max) {
+		t.Fatalf("lanes should not be clipped by default %v", features)
+	}
+
+	aoiBox := orb.Bound{Min: orb.Point{116.055, 39.94}, Max: orb.Point{116.07, 39.97}}
+	url := fmt.Sprintf("/simple/maps/moss.test_map/aois?clip=true&lng1=%v&lng2=%v&lat1=%v&lat2=%v",
+		aoiBox.Min.Lon(), aoiBox.Max.Lon(), aoiBox.Min.Lat(), aoiBox.Max.Lat())
+	aois := getData[[]*geojson.Feature](t, url)
+	if len(aois) != 1 || aois[0].Geometry.Bound().Min.Lon() < 116.055-1e-9 {
+		t.Fatalf("GET %s: unexpected AOIs %v", url, aois)
+	}
+
+	// 以POST的三角形裁剪，斜边为lng = 116.03 - 0.6 * (lat - 39.9)
+	// Clipped by the POSTed triangle, whose hypotenuse is lng = 116.03 - 0.6 * (lat - 39.9)
+	triangle := `{"type":"Polygon","coordinates":[[[116,39.9],[116.03,39.9],[116,39.95],[116,39.9]]]}`
+	inTriangle := func(p orb.Point) bool {
+		return p.Lon() >= 116-1e-9 && p.Lat() >= 39.9-1e-9 && 116.03-0.6*(p.Lat()-39.9)-p.Lon() >= -1e-9
+	}
+	for _, url := range []string{
+		"/simple/all-lane/test?clip=true",
+		"/simple/maps/moss.test_map/lanes?clip=true",
+		"/simple/maps/moss.test_map/roads?clip=true",
+	} {
+		var features []*geojson.Feature
+		if err := json.Unmarshal(send(t, http.MethodPost, url, "", triangle, 200).Data, &features); err != nil || len(features) == 0 {
+			t.Fatalf("POST %s: unexpected features %v %v", url, features, err)
+		}
+		for _, f := range features {
+			for _, line := range lines(f.Geometry) {
+				for _, p := range line {
+					if !inTriangle(p) {
+						t.Fatalf("POST %s: feature %v is not clipped: %v", url, f.ID, f.Geometry)
+					}
+				}
+			}
+		}
+	}
+	// 部分在多边形内的AOI被裁剪 the AOI partly inside the polygon is cut
+	half := `{"type":"Polygon","coordinates":[[[116.05,39.94],[116.07,39.94],[116.07,39.955],[116.05,39.955],[116.05,39.94]]]}`
+	var clipped []*geojson.Feature
+	if err := json.Unmarshal(send(t, http.MethodPost, "/simple/maps/moss.test_map/aois?clip=true", "", half, 200).Data, &clipped); err != nil {
+		t.Fatal(err)
+	}
+	if len(clipped) != 1 || clipped[0].Geometry.GeoJSONType() != "Polygon" || clipped[0].Geometry.Bound().Max.Lat() > 39.955+1e-9 {
+		t.Fatalf("unexpected clipped AOIs %v", clipped)
+	}
+}
+
+// 线或多线的各段 The pieces of a line or multi-line
+func lines(g orb.Geometry) orb.MultiLineString {
+	switch g := g.(type) {
+	case orb.LineString:
+		return orb.MultiLineString{g}
+	case orb.MultiLineString:
+		return g
+	}
+	return nil
+}
+
+func TestAois(t *testing.T) {
+	url := "/simple/aoi/test"
+	features := getData[[]testFeature](t, url)
+	assertIDs(t, url, featureIDs(features), 500000001)
+	if features[0].Geometry.Type != "Polygon" {
+		t.Fatalf("unexpected geometry %s", features[0].Geometry.Type)
+	}
+	get(t, "/simple/aoi/unknown", 404)
+}
+
+func TestMaps(t *testing.T) {
+	if maps := getData[[]string](t, "/simple/maps"); fmt.Sprint(maps) != "[moss.test_map]" {
+		t.Fatalf("unexpected maps %v", maps)
+	}
+	// 私有模拟的地图仅对可访问该模拟的调用方可见，未被使用的地图对已认证的调用方可见
+	// The map of a private simulation is visible to the callers with access to it only,
+	// the unused map is visible to authenticated callers
+	for key, want := range map[string]string{
+		"alice-key": "[moss.private_map moss.test_map moss.unused_map]",
+		"bob-key":   "[moss.test_map moss.unused_map]",
+	} {
+		var maps []string
+		if err := json.Unmarshal(getWithAPIKey(t, "/simple/maps", key, 200).Data, &maps); err != nil || fmt.Sprint(maps) != want {
+			t.Fatalf("%s: want maps %s but got %v", key, want, maps)
+		}
+	}
+	get(t, "/simple/maps/moss.private_map", 401)
+	get(t, "/simple/maps/moss.private_map/lanes", 401)
+	getWithAPIKey(t, "/simple/maps/moss.private_map", "bob-key", 403)
+	getWithAPIKey(t, "/simple/maps/moss.private_map/roads", "bob-key", 403)
+	getWithAPIKey(t, "/simple/maps/moss.private_map", "alice-key", 200)
+	send(t, http.MethodGet, "/simple/maps/moss.private_map/aois", adminToken, "", 200)
+	get(t, "/simple/maps/moss.unused_map", 401)
+	getWithAPIKey(t, "/simple/maps/moss.unused_map/lanes", "bob-key", 200)
+	// 列表之外的地图不可访问 maps outside of the list cannot be accessed
+	if res := get(t, "/simple/maps/other.test_map", 404); res.Code != "map_not_found" {
+		t.Fatalf("unexpected response %+v", res)
+	}
+
+	info := getData[simple.MapInfo](t, "/simple/maps/moss.test_map")
+	if info.Name != "test_map" || info.Lanes != 5 || info.Roads != 2 || info.Junctions != 1 || info.Aois != 2 {
+		t.Fatalf("unexpected map info %+v", info)
+	}
+	if info.MinLng != 116.01 || info.MinLat != 39.91 || info.MaxLng != 117.1 || info.MaxLat != 41.1 {
+		t.Fatalf("unexpected map bound %+v", info)
+	}
+
+	box := "lng1=116&lng2=116.5&lat1=39.9&lat2=40"
+	cases := []struct {
+		url  string
+		want []int
+	}{
+		{"/simple/maps/moss.test_map/lanes", []int{1, 2, 3, 4, 5}},
+		{"/simple/maps/moss.test_map/lanes?" + box, []int{1, 2, 3, 5}},
+		{"/simple/maps/moss.test_map/lanes?type=road&" + box, []int{1, 2, 5}},
+		{"/simple/maps/moss.test_map/lanes?type=junction", []int{3}},
+		{"/simple/maps/moss.test_map/roads", []int{1, 2}},
+		{"/simple/maps/moss.test_map/roads?" + box, []int{1}},
+		{"/simple/maps/moss.test_map/aois", []int{500000001, 500000003}},
+		{"/simple/maps/moss.test_map/aois?" + box, []int{500000001}},
+	}
+	for _, c := range cases {
+		assertIDs(t, c.url, featureIDs(getData[[]testFeature](t, c.url)), c.want...)
+	}
+
+	res := get(t, "/simple/maps/moss.unknown", 404)
+	if res.Code != "map_not_found" || res.Details["map"] != "moss.unknown" {
+		t.Fatalf("unexpected not found response %+v", res)
+	}
+	get(t, "/simple/maps/unknown/lanes", 400)
+	get(t, "/simple/maps/moss.test_map/lanes?type=bad", 400)
+	get(t, "/simple/maps/moss.test_map/aois?lng1=116", 400)
+}
+
+func TestLaneProperties(t *testing.T) {
+	properties := func(url string) map[int]geojson.Properties {
+		features := getData[[]*geojson.Feature](t, url)
+		all := make(map[int]geojson.Properties, len(features))
+		for _, f := range features {
+			all[int(f.Properties.MustInt("id"))] = f.Properties
+		}
+		return all
+	}
+
+	lanes := properties("/simple/maps/moss.test_map/lanes")
+	if len(lanes[1]) != 2 {
+		t.Fatalf("only id and type by default, got %v", lanes[1])
+	}
+
+	lanes = properties("/simple/maps/moss.test_map/lanes?fields=lane,topology")
+	if l := lanes[1]; l["width"] != 3.2 || l["max_speed"] != 16.67 || l["turn"] != 1.0 || l["parent_id"] != 1.0 || l["length"].(float64) <= 0 {
+		t.Fatalf("unexpected lane properties %v", l)
+	}
+	if l := lanes[3]; fmt.Sprint(l["predecessors"], l["successors"]) != "[map[id:1 type:2]] [map[id:4 type:1]]" {
+		t.Fatalf("unexpected topology %v", l)
+	}
+	if l := lanes[2]; fmt.Sprint(l["predecessors"]) != "[]" || l["road_id"] != nil {
+		t.Fatalf("unexpected properties %v", l)
+	}
+
+	lanes = properties("/simple/maps/moss.test_map/lanes?fields=road,junction")
+	if l := lanes[1]; l["road_name"] != "Main Street" || l["driving_lanes"] != 2.0 || l["walking_lanes"] != 1.0 || l["junction_id"] != nil {
+		t.Fatalf("unexpected road properties %v", l)
+	}
+	if l := lanes[3]; l["junction_id"] != 300000001.0 || l["road_id"] != nil || len(l["junction_polygon"].([]any)) != 4 ||
+		fmt.Sprint(l["driving_lane_groups"]) != "[map[in_road_id:1 lane_ids:[3] out_road_id:2]]" {
+		t.Fatalf("unexpected junction properties %v", l)
+	}
+
+	roads := properties("/simple/roadlane/test?fields=all")
+	if r := roads[1]; r["road_name"] != "Main Street" || r["lane_id"] != 2.0 {
+		t.Fatalf("unexpected road properties %v", r)
+	}
+	if r := properties("/simple/maps/moss.test_map/roads?fields=road")[2]; r["road_name"] != "Second Street" {
+		t.Fatalf("unexpected road properties %v", r)
+	}
+	get(t, "/simple/all-lane/test?fields=bad", 400)
+}
+
+func TestJunctions(t *testing.T) {
+	url := "/simple/junctions/test"
+	junctions := getData[[]*geojson.Feature](t, url)
+	if len(junctions) != 1 {
+		t.Fatalf("GET %s: unexpected junctions %v", url, junctions)
+	}
+	j := junctions[0]
+	if hull, ok := j.Geometry.(orb.Polygon); !ok || len(hull[0]) != 4 {
+		t.Fatalf("the junction should be the hull of its lanes, got %v", j.Geometry)
+	}
+	props := j.Properties
+	if props.MustInt("id") != 300000001 ||
+		fmt.Sprint(props["entry_roads"], props["exit_roads"], props["traffic_light_lanes"]) != "[1] [2] [3]" {
+		t.Fatalf("unexpected junction properties %v", props)
+	}
+	if fmt.Sprint(props["connections"]) != "[map[in_lane_ids:[1] in_road_id:1 lane_id:3 out_lane_ids:[4] out_road_id:2 turn:2 type:1]]" {
+		t.Fatalf("unexpected connections %v", props["connections"])
+	}
+
+	// 没有信号灯表的模拟 a simulation without the traffic light table
+	if junctions := getData[[]*geojson.Feature](t, "/simple/junctions/old"); len(junctions) != 1 ||
+		fmt.Sprint(junctions[0].Properties["traffic_light_lanes"]) != "[]" {
+		t.Fatalf("unexpected junctions without traffic lights %v", junctions)
+	}
+	if junctions := getData[[]*geojson.Feature](t, url+"?lng1=116.05&lng2=116.1&lat1=39.9&lat2=40"); len(junctions) != 0 {
+		t.Fatalf("unexpected junctions outside the bbox %v", junctions)
+	}
+	clipped := getData[[]*geojson.Feature](t, url+"?clip=true&lng1=116.025&lng2=116.1&lat1=39.9&lat2=40")
+	if len(clipped) != 1 || clipped[0].Geometry.Bound().Min.Lon() < 116.025 {
+		t.Fatalf("unexpected clipped junctions %v", clipped)
+	}
+	get(t, "/simple/junctions/unknown", 404)
+}
+
+func TestMapCache(t *testing.T) {
+	// 只保留测试地图的缓存 keep the test map in the cache only
+	for _, mapPath := range []string{"moss.private_map", "moss.unused_map"} {
+		send(t, http.MethodDelete, "/simple/map-cache/"+mapPath, adminToken, "", 200)
+	}
+	for _, url := range []string{"/simple/junclane/test", "/simple/roadlane/test", "/simple/aoi/test"} {
+		get(t, url, 200)
+	}
+	get(t, "/simple/map-cache", 401)
+	getWithAPIKey(t, "/simple/map-cache", "alice-key", 403)
+	var stats simple.MapCacheStats
+	if err := json.Unmarshal(send(t, http.MethodGet, "/simple/map-cache", adminToken, "", 200).Data, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Maps != 1 || stats.Hits < 2 || stats.Nodes == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	send(t, http.MethodDelete, "/simple/map-cache/moss.test_map", "", "", 401)
+	send(t, http.MethodDelete, "/simple/map-cache/bad", adminToken, "", 400)
+	res := send(t, http.MethodDelete, "/simple/map-cache/moss.test_map", adminToken, "", 200)
+	if err := json.Unmarshal(res.Data, &stats); err != nil || stats.Maps != 0 || stats.Nodes != 0 {
+		t.Fatalf("unexpected stats after invalidation %+v: %v", stats, err)
+	}
+	get(t, "/simple/aoi/test", 200)
+	if stats := simple.MapCacheStatistics(); stats.Maps != 1 {
+		t.Fatalf("map should be reloaded after invalidation %+v", stats)
+	}
+}
+
+func TestTiles(t *testing.T) {
+	tile := func(name string, z maptile.Zoom) string {
+		tile := maptile.At(orb.Point{116.02, 39.92}, z)
+		return fmt.Sprintf("/simple/tiles/%s/%d/%d/%d.mvt", name, tile.Z, tile.X, tile.Y)
+	}
+	cases := []struct {
+		url    string
+		layers []string
+	}{
+		{tile("test", 8), nil},
+		{tile("test", 12), []string{"roads"}},
+		{tile("test", 16), []string{"roads", "aois", "junction_lanes", "road_lanes"}},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, c.url, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		router.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("GET %s: want status 200 but got %d, body: %s", c.url, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/vnd.mapbox-vector-tile" {
+			t.Fatalf("GET %s: unexpected content type %s", c.url, ct)
+		}
+		if ce := w.Header().Get("Content-Encoding"); ce != "gzip" {
+			t.Fatalf("GET %s: unexpected content encoding %s", c.url, ce)
+		}
+		// 只压缩一次 compressed only once
+		layers, err := mvt.UnmarshalGzipped(w.Body.Bytes())
+		if err != nil {
+			t.Fatalf("GET %s: decode tile: %v", c.url, err)
+		}
+		names := make([]string, 0, len(layers))
+		for _, l := range layers {
+			names = append(names, l.Name)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(c.layers) {
+			t.Fatalf("GET %s: want layers %v but got %v", c.url, c.layers, names)
+		}
+		for _, l := range layers {
+			if l.Name != "aois" && len(l.Features) == 0 {
+				t.Fatalf("GET %s: empty layer %s", c.url, l.Name)
+			}
+			for _, f := range l.Features {
+				if _, ok := f.Properties["id"]; !ok || f.Geometry == nil {
+					t.Fatalf("GET %s: bad feature in layer %s: %+v", c.url, l.Name, f)
+				}
+			}
+			// 解码后的坐标在瓦片坐标内（含缓冲区） decoded coordinates are in the tile extent with the buffer
+			if len(l.Features) == 0 {
+				continue
+			}
+			if b := l.Features[0].Geometry.Bound(); !mvt.MapboxGLDefaultExtentBound.Contains(b.Min) || !mvt.MapboxGLDefaultExtentBound.Contains(b.Max) {
+				t.Fatalf("GET %s: feature out of tile in layer %s: %v", c.url, l.Name, b)
+			}
+		}
+	}
+	// 不接受gzip时不压缩 not compressed without Accept-Encoding: gzip
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tile("test", 16), nil))
+	if ce := w.Header().Get("Content-Encoding"); w.Code != 200 || ce != "" {
+		t.Fatalf("unexpected response without gzip %d %s", w.Code, ce)
+	}
+	if layers, err := mvt.Unmarshal(w.Body.Bytes()); err != nil || len(layers) != 4 {
+		t.Fatalf("unexpected tile without gzip %v: %v", layers, err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/simple/tiles/test/3/100/1.mvt", nil))
+	if w.Code != 400 {
+		t.Fatalf("want status 400 but got %d", w.Code)
+	}
+	get(t, tile("unknown", 16), 404)
+}
+
+func TestStream(t *testing.T) {
+	server := httptest.NewServer(router)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/simple/stream/test?start=8&speed=100"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	read := func() map[string]any {
+		msg := make(map[string]any)
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+	if msg := read(); msg["type"] != "state" || msg["step"] != 8.0 {
+		t.Fatalf("unexpected message %v", msg)
+	}
+	for _, step := range []float64{8, 9} {
+		if msg := read(); msg["type"] != "frame" || msg["step"] != step {
+			t.Fatalf("unexpected message %v", msg)
+		}
+	}
+	if msg := read(); msg["type"] != "end" {
+		t.Fatalf("unexpected message %v", msg)
+	}
+	// 回到有车辆数据的step seek back to a step with vehicles
+	if err := conn.WriteJSON(map[string]any{"type": "seek", "step": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(); msg["type"] != "state" || msg["step"] != 0.0 {
+		t.Fatalf("unexpected message %v", msg)
+	}
+	if err := conn.WriteJSON(map[string]any{"type": "resume"}); err != nil {
+		t.Fatal(err)
+	}
+	read() // state
+	msg := read()
+	if cars, _ := msg["cars"].([]any); msg["type"] != "frame" || len(cars) != 1 {
+		t.Fatalf("unexpected message %v", msg)
+	}
+}
+
+func TestStreamAccessToken(t *testing.T) {
+	server := httptest.NewServer(router)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/simple/stream/private"
+	if _, res, err := websocket.DefaultDialer.Dial(url, nil); err == nil || res.StatusCode != 401 {
+		t.Fatalf("want 401 without a token but got %v", err)
+	}
+	token := signJWT(fmt.Sprintf(`{"sub":"carol","groups":["lab"],"exp":%d}`, time.Now().Add(time.Hour).Unix()), jwtSecret)
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?access_token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
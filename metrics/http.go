@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "moss_http_requests_total",
+		Help: "Number of HTTP requests by route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "moss_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by route.",
+		Buckets: DurationBuckets,
+	}, []string{"method", "route"})
+	httpResponseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "moss_http_response_size_bytes",
+		Help:    "Size of HTTP response bodies by route.",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+	}, []string{"method", "route"})
+	httpTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "moss_http_request_timeouts_total",
+		Help: "Number of HTTP requests aborted by the request timeout.",
+	})
+)
+
+// 按路由模板统计请求数、耗时与响应大小，需注册在timeout中间件之前以记录超时的408
+// Count the requests, latency and response size by the route template, should be registered before the timeout
+// middleware to record the 408 of timed out requests
+func Middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	// 未匹配的路径不作为标签，避免标签无限增长 unmatched paths are not used as labels to bound the cardinality
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	if size := c.Writer.Size(); size >= 0 {
+		httpResponseSize.WithLabelValues(c.Request.Method, route).Observe(float64(size))
+	}
+}
+
+// gin-timeout超时时的回调 Callback of gin-timeout on timeout
+func CountTimeout(*http.Request) {
+	httpTimeouts.Inc()
+}
@@ -0,0 +1,15 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 指标注册在prometheus的默认注册表中，其中还包括Go运行时与进程的指标
+// Metrics are registered in the default registry of prometheus, which also has the Go runtime and process metrics
+
+// 请求耗时等秒数的桶 Buckets of durations in seconds
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20}
+
+// 以Prometheus文本格式输出默认注册表中的指标 Write the metrics of the default registry in the Prometheus text format
+var Handler = gin.WrapH(promhttp.Handler())
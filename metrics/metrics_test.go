@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware)
+	r.GET("/items/:id", func(c *gin.Context) { c.String(200, "ok") })
+	r.GET("/metrics", Handler)
+	for _, path := range []string{"/items/1", "/items/2", "/unknown"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/items/:id", "200")); n != 2 {
+		t.Errorf("want 2 requests of the route but got %v", n)
+	}
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "unmatched", "404")); n != 1 {
+		t.Errorf("want 1 unmatched request but got %v", n)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`moss_http_request_duration_seconds_count{method="GET",route="/items/:id"} 2`,
+		"moss_http_request_timeouts_total 0",
+		"# TYPE go_goroutines gauge",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("want %q in\n%s", want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.fiblab.net/sim/backend/auth"
+	"git.fiblab.net/sim/backend/config"
+	"git.fiblab.net/sim/backend/logging"
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+)
+
+// 接口的限流类别，各类别的预算相互独立 Rate limit class of routes, each class has its own budget
+type Class int
+
+const (
+	Default   Class = iota // 一般接口 General routes
+	Expensive              // 车辆、行人与地图几何接口 Cars, people and map geometry routes
+)
+
+// 清理已补满的令牌桶的间隔 Interval to remove the refilled buckets
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 补充令牌，返回取出一个令牌前需要等待的时间 Refill the tokens, return the time to wait before a token can be taken
+func (b *bucket) refill(budget config.RateBudget, now time.Time) time.Duration {
+	b.tokens = math.Min(float64(budget.Burst), b.tokens+now.Sub(b.last).Seconds()*budget.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / budget.Rate * float64(time.Second))
+}
+
+type bucketKey struct {
+	class  Class
+	client string
+}
+
+// IP或CIDR列表 List of IPs or CIDRs
+type ipList []*net.IPNet
+
+func parseIPList(entries []string) (ipList, error) {
+	list := make(ipList, 0, len(entries))
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP or CIDR", e)
+			}
+			bits := 8 * net.IPv6len
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 8*net.IPv4len
+			}
+			list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP or CIDR", e)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func (l ipList) contains(ip net.IP) bool {
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 令牌桶限流器，所有调用方按IP限流，已认证的调用方同时按用户名限流
+// Token bucket rate limiter, all callers are limited by the IP and authenticated callers also by the user name
+type Limiter struct {
+	mu        sync.Mutex
+	budgets   map[Class]config.RateBudget
+	allow     ipList
+	deny      ipList
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func New(c config.RateLimit) (*Limiter, error) {
+	l := &Limiter{buckets: make(map[bucketKey]*bucket), now: time.Now}
+	if err := l.Reload(c); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// 更新预算与IP名单，已有令牌桶保留 Update the budgets and the IP lists, the existing buckets are kept
+func (l *Limiter) Reload(c config.RateLimit) error {
+	allow, err := parseIPList(c.AllowList)
+	if err != nil {
+		return fmt.Errorf("bad allowlist: %w", err)
+	}
+	deny, err := parseIPList(c.DenyList)
+	if err != nil {
+		return fmt.Errorf("bad denylist: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.budgets = map[Class]config.RateBudget{Default: c.Default, Expensive: c.Expensive}
+	l.allow, l.deny = allow, deny
+	return nil
+}
+
+func (l *Limiter) denied(ip net.IP) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ip != nil && l.deny.contains(ip)
+}
+
+// 检查各客户端键是否都还有令牌，都有时各取出一个，否则返回可重试前的等待时间
+// Check whether every client key has tokens left and take one from each if so,
+// otherwise return the time to wait before retrying
+func (l *Limiter) take(class Class, clients []string, ip net.IP) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	budget := l.budgets[class]
+	if budget.Rate <= 0 || ip != nil && l.allow.contains(ip) {
+		return true, 0
+	}
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+	buckets := make([]*bucket, 0, len(clients))
+	var wait time.Duration
+	for _, client := range clients {
+		key := bucketKey{class: class, client: client}
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(budget.Burst), last: now}
+			l.buckets[key] = b
+		}
+		wait = max(wait, b.refill(budget, now))
+		buckets = append(buckets, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// 删除已补满的令牌桶，与新建的桶等价 Remove the refilled buckets, which are equal to new ones
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		budget := l.budgets[key.class]
+		if budget.Rate <= 0 || b.tokens+now.Sub(b.last).Seconds()*budget.Rate >= float64(budget.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// 拒绝名单中IP的请求，返回403 Reject the requests from the IPs in the denylist with 403
+func (l *Limiter) AccessList(c *gin.Context) {
+	if ip := c.ClientIP(); l.denied(net.ParseIP(ip)) {
+		logging.FromContext(c.Request.Context()).Warn("deny request", "client_ip", ip)
+		util.AbortWithError(c, util.NewError(util.CodeForbidden, "access denied"))
+		return
+	}
+}
+
+// 限流中间件，需在认证之后，超出预算时返回429与Retry-After
+// Rate limit middleware after the authentication, 429 with Retry-After when the budget is exceeded
+func (l *Limiter) Limit(class Class) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		clients := []string{"ip:" + ip}
+		if p := auth.FromContext(c); p != nil {
+			clients = append(clients, "user:"+p.Subject)
+		}
+		if ok, wait := l.take(class, clients, net.ParseIP(ip)); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			util.AbortWithError(c, util.NewError(util.CodeRateLimited, "rate limit exceeded").With("retryAfter", retryAfter))
+			return
+		}
+	}
+}
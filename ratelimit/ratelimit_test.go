@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"git.fiblab.net/sim/backend/config"
+)
+
+func TestTake(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l, err := New(config.RateLimit{
+		Default:   config.RateBudget{Rate: 2, Burst: 2},
+		AllowList: []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.now = func() time.Time { return now }
+	ip := net.ParseIP("192.0.2.1")
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.take(Default, []string{"a"}, ip); !ok {
+			t.Fatalf("request %d: want allowed", i)
+		}
+	}
+	if ok, wait := l.take(Default, []string{"a"}, ip); ok || wait != 500*time.Millisecond {
+		t.Fatalf("want rejected with 500ms wait but got %v %v", ok, wait)
+	}
+	if ok, _ := l.take(Default, []string{"b"}, ip); !ok {
+		t.Fatal("want a separate bucket for another client")
+	}
+	if ok, _ := l.take(Expensive, []string{"a"}, ip); !ok {
+		t.Fatal("want no limit for a class without a rate")
+	}
+	if ok, _ := l.take(Default, []string{"a"}, net.ParseIP("10.1.2.3")); !ok {
+		t.Fatal("want no limit for an allowed IP")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.take(Default, []string{"a"}, ip); !ok {
+		t.Fatal("want allowed after refilling")
+	}
+	// 任一键没有令牌时拒绝，且不消耗其他键的令牌 rejected if any key is out of tokens, without taking tokens of the others
+	if ok, _ := l.take(Default, []string{"b", "a"}, ip); ok {
+		t.Fatal("want rejected when one of the keys is out of tokens")
+	}
+	if ok, _ := l.take(Default, []string{"b"}, ip); !ok {
+		t.Fatal("want the tokens of the other key kept")
+	}
+
+	// 补满的令牌桶被清理 the refilled buckets are removed
+	now = now.Add(sweepInterval)
+	l.take(Default, []string{"c"}, ip)
+	if len(l.buckets) != 1 {
+		t.Fatalf("want 1 bucket after the sweep but got %d", len(l.buckets))
+	}
+}
+
+func TestIPList(t *testing.T) {
+	list, err := parseIPList([]string{"192.0.2.1", "2001:db8::/32", "198.51.100.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"192.0.2.1":     true,
+		"192.0.2.2":     false,
+		"198.51.100.99": true,
+		"2001:db8::1":   true,
+		"2001:db9::1":   false,
+	} {
+		if got := list.contains(net.ParseIP(ip)); got != want {
+			t.Errorf("%s: want %v but got %v", ip, want, got)
+		}
+	}
+	if _, err := parseIPList([]string{"192.0.2.0/33"}); err == nil {
+		t.Error("want error for a bad CIDR")
+	}
+}
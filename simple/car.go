@@ -1,7 +1,7 @@
 package simple
 
 import (
-	"errors"
+	"context"
 
 	"git.fiblab.net/sim/backend/util"
 	"git.fiblab.net/utils/lens"
@@ -38,8 +38,39 @@ var (
 	carV2Tool = pgxtool.New(&CarV2{})
 )
 
+func queryCarsV2(ctx context.Context, t RecordTables, begin, end, interval int, b BBox) ([]*CarV2, error) {
+	all, err := storage.Trajectory.Cars(ctx, t, StepQuery{begin, end, 1, interval}, b)
+	if err != nil {
+		return nil, err
+	}
+	for _, one := range all {
+		one.Direction = util.ToFixed(one.Direction, 2)
+		one.Lng = util.ToFixed(one.Lng, 8)
+		one.Lat = util.ToFixed(one.Lat, 8)
+	}
+	return all, nil
+}
+
+func carsToColumnar(all []*CarV2) *util.Columnar {
+	t := util.NewColumnar(len(all))
+	t.AddInt32("step", func(i int) int32 { return int32(all[i].Step) })
+	t.AddInt32("id", func(i int) int32 { return int32(all[i].Id) })
+	t.AddInt32("laneId", func(i int) int32 { return int32(all[i].LaneId) })
+	t.AddFloat32("direction", func(i int) float32 { return float32(all[i].Direction) })
+	t.AddFloat64("lng", func(i int) float64 { return all[i].Lng })
+	t.AddFloat64("lat", func(i int) float64 { return all[i].Lat })
+	t.AddString("model", func(i int) string { return all[i].Model })
+	t.AddFloat32("z", func(i int) float32 { return float32(all[i].Z) })
+	t.AddFloat32("pitch", func(i int) float32 { return float32(all[i].Pitch) })
+	t.AddFloat32("v", func(i int) float32 { return float32(all[i].V) })
+	t.AddInt32("numPassengers", func(i int) int32 { return all[i].NumPassengers })
+	return t
+}
+
 // @Summary Get Vehicles
+// @Description The columns of the supported DBRecorder versions are mapped to CarV2, unsupported versions return 422.
 // @Produce application/json
+// @Produce application/vnd.moss.columnar
 // @Param tablename path string true "Simulation Name"
 // @Param begin query number true "the start step of the data"
 // @Param end query number true "Get the end step of the data (not included)"
@@ -48,46 +79,34 @@ var (
 // @Param lng1 query number true "min longitude for filtering"
 // @Param lng2 query number true "max longitude for filtering"
 // @Param interval query number false "Get the interval of the data (default is 1, return results step=begin,begin+1*interval,begin+2*interval...)"
+// @Param format query string false "Response format: json (default) or columnar (binary columnar layout, see util/columnar.go)"
 // @Success 200 object util.Response{data=[]CarV2} ""
 // @Router /simple/cars/{tablename} [get]
 func GetCarsByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
-	s := lens.ValidateParam[lens.StepCoordinate](c)
+	s := util.ValidateParam[lens.StepCoordinate](c)
 	if s == nil {
 		return
 	}
 
-	// get meta
-	metas, err := QueryMetadata(&u.Name)
+	meta, ok := simMetadata(c, u.Name)
+	if !ok {
+		return
+	}
+	all, err := queryCarsV2(c.Request.Context(), meta.tables(), *s.Begin, *s.End, *s.Interval, bboxOf(s))
+	if util.ResponseEmptyIfTableNotFound(c, all, err) {
+		return
+	}
 	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-	} else if len(metas) == 0 {
-		c.JSON(404, util.NewErrorResponse(errors.New("not found")))
+		util.AbortWithError(c, err)
+		return
 	}
-	meta := metas[0]
-	// download data
-	switch meta.Version {
-	case 2:
-		all, err := lens.QueryPgTableWithStep[CarV2](
-			carV2Tool, u.Name+"_s_cars",
-			*s.Begin, *s.End, 1, 0, *s.Interval,
-			"LAT>=$1 AND LAT<$2 AND LNG>=$3 AND LNG<$4",
-			[]any{*s.Lat1, *s.Lat2, *s.Lng1, *s.Lng2},
-		)
-		if err != nil {
-			c.JSON(500, util.NewErrorResponse(err))
-			return
-		}
-		for _, one := range all {
-			one.Direction = util.ToFixed(one.Direction, 2)
-			one.Lng = util.ToFixed(one.Lng, 8)
-			one.Lat = util.ToFixed(one.Lat, 8)
-		}
-		c.JSON(200, util.NewResponse(all))
-	default:
-		c.JSON(500, util.NewErrorResponse(errors.New("unsupported version")))
+	if util.WantColumnar(c) {
+		util.ResponseColumnar(c, carsToColumnar(all))
+		return
 	}
+	c.JSON(200, util.NewResponse(all))
 }
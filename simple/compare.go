@@ -0,0 +1,281 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+	"github.com/samber/lo"
+)
+
+// 车辆在一个step的统计 Vehicle statistics of a step
+type CarStepStat struct {
+	Step     int
+	Vehicles int
+	MeanV    float64
+}
+
+// 参数占位符：1为begin，2为end；placeholder为"$"（PostgreSQL）或"?"（SQLite），from为RecordTables.from的表
+// Parameters: 1 for begin, 2 for end; placeholder is "$" (PostgreSQL) or "?" (SQLite),
+// from is the table given by RecordTables.from
+func carStepStatsSQL(from string, placeholder string) string {
+	return fmt.Sprintf(
+		"SELECT step, COUNT(*), AVG(v) FROM %[1]s WHERE step >= %[2]s1 AND step < %[2]s2 GROUP BY step ORDER BY step",
+		from, placeholder,
+	)
+}
+
+type CompareParam struct {
+	A        *string `form:"a" binding:"required"` // 基准模拟 Baseline simulation
+	B        *string `form:"b" binding:"required"` // 对比模拟 Simulation compared with the baseline
+	Begin    *int    `form:"begin"`                // 默认为两个模拟共同范围的起点 The start of the common range by default
+	End      *int    `form:"end"`                  // 默认为两个模拟共同范围的终点 The end of the common range by default
+	Interval *int    `form:"interval"`             // 输出的step间隔，默认为1 Step interval of the output, 1 by default
+}
+
+func (p *CompareParam) Check() error {
+	for _, name := range []string{*p.A, *p.B} {
+		if !util.NameChecker.MatchString(name) {
+			return fmt.Errorf("%s is an invalid name", name)
+		}
+	}
+	if p.Interval == nil {
+		p.Interval = new(int)
+		*p.Interval = 1
+	}
+	if *p.Interval < 1 {
+		return errors.New("step interval should be larger than 0")
+	}
+	if p.Begin != nil && p.End != nil && *p.End <= *p.Begin {
+		return errors.New("end should be larger than begin")
+	}
+	return nil
+}
+
+// 两个模拟的指标及其差值（b-a） A metric of both simulations and the difference (b-a)
+type MetricDiff struct {
+	A     float64 `json:"a"`
+	B     float64 `json:"b"`
+	Delta float64 `json:"delta"`
+}
+
+func newMetricDiff(a, b float64) MetricDiff {
+	return MetricDiff{A: util.ToFixed(a, 4), B: util.ToFixed(b, 4), Delta: util.ToFixed(b-a, 4)}
+}
+
+type StepComparison struct {
+	Step                int          `json:"step"`
+	Vehicles            MetricDiff   `json:"vehicles"`              // 车辆数 Number of vehicles
+	MeanV               MetricDiff   `json:"meanV"`                 // 平均速度（米/秒） Mean speed (m/s)
+	MeanCongestionLevel MetricDiff   `json:"meanCongestionLevel"`   // 平均拥堵指数 Mean congestion level
+	LevelCounts         []MetricDiff `json:"congestionLevelCounts"` // 等级2->5的道路数 Road counts of level 2->5
+}
+
+// 道路时间加权平均路况等级的变化 Change of the time-weighted mean road status level of a road
+type RoadCongestionDelta struct {
+	Id        int        `json:"id"` // 道路ID Road ID
+	MeanLevel MetricDiff `json:"meanLevel"`
+}
+
+type SimComparison struct {
+	A        string                `json:"a"`
+	B        string                `json:"b"`
+	Begin    int                   `json:"begin"`
+	End      int                   `json:"end"`
+	Interval int                   `json:"interval"`
+	Steps    []StepComparison      `json:"steps"`
+	Roads    []RoadCongestionDelta `json:"roads"` // 两个模拟中均有路况的道路，按变化绝对值降序 Roads in both simulations, sorted by the absolute delta in descending order
+	// 道路（同/simple/roadlane）按等级变化着色的GeoJSON，属性含a、b、delta与color
+	// GeoJSON of the roads (as in /simple/roadlane) colored by the level difference, with a, b, delta and color as properties
+	// 道路由a的road_status_v_min选取，a没有时使用b的，均没有时为空
+	// The roads are selected by road_status_v_min of a, or of b if a has none, empty if neither has one
+	RoadsGeoJson []*geojson.Feature `json:"roadsGeoJson"`
+}
+
+// 单个模拟在对齐范围内的统计，表不存在时视为没有记录
+// Statistics of one simulation in the aligned range, missing tables mean no records
+type comparedSim struct {
+	cars        map[int]*CarStepStat
+	roadStats   map[int]RoadStatusStat
+	congestions map[int]*RoadCongestion
+}
+
+func queryComparedSim(ctx context.Context, meta *Metadata, begin, end, interval int) (*comparedSim, error) {
+	s := &comparedSim{
+		cars:      make(map[int]*CarStepStat),
+		roadStats: make(map[int]RoadStatusStat),
+	}
+	cars, err := storage.Trajectory.CarStepStats(ctx, meta.tables(), begin, end)
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		return nil, err
+	}
+	for _, one := range cars {
+		s.cars[one.Step] = one
+	}
+	dataInterval := *meta.RoadStatusInterval
+	err = storage.Trajectory.RoadStatusStats(ctx, meta.tables(), StepQuery{begin, end, dataInterval, interval}, func(one RoadStatusStat) error {
+		s.roadStats[one.Step] = one
+		return nil
+	})
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		return nil, err
+	}
+	all, err := queryRoadStatus(ctx, meta.tables(), begin, end, dataInterval, dataInterval)
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		return nil, err
+	}
+	s.congestions = roadCongestions(all, dataInterval, end)
+	return s, nil
+}
+
+// 等级变化的颜色，降低为绿色，升高为红色，变化3级及以上为最深色
+// Color of the level difference, green for decrease and red for increase, saturated at 3 levels
+func levelDeltaColor(delta float64) string {
+	t := math.Max(-1, math.Min(1, delta/3))
+	from := [3]float64{0xf7, 0xf7, 0xf7}
+	to := [3]float64{0xd7, 0x19, 0x1c}
+	if t < 0 {
+		to = [3]float64{0x1a, 0x96, 0x41}
+		t = -t
+	}
+	var rgb [3]int
+	for i := range rgb {
+		rgb[i] = int(math.Round(from[i] + (to[i]-from[i])*t))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2])
+}
+
+// @Summary Compare two simulations on the same map
+// @Description Both simulations should share the same map and have road status. Metrics are compared at the same steps in
+// @Description the common step range of both simulations (optionally narrowed by begin/end), every delta is b-a.
+// @Description Missing DBRecorder tables count as no records.
+// @Produce application/json
+// @Param a query string true "Baseline Simulation Name"
+// @Param b query string true "Simulation Name compared with the baseline"
+// @Param begin query number false "the start step of the data (default is the start of the common range)"
+// @Param end query number false "the end step of the data, not included (default is the end of the common range)"
+// @Param interval query number false "Get the interval of the data (default is 1, return results step=begin,begin+1*interval,begin+2*interval...)"
+// @Success 200 object util.Response{data=SimComparison} "successful operation"
+// @Router /simple/compare [get]
+func GetSimComparison(c *gin.Context) {
+	p := util.ValidateParam[CompareParam](c)
+	if p == nil {
+		return
+	}
+	metaA, ok := roadStatusMeta(c, *p.A)
+	if !ok || !checkSimAccess(c, metaA) {
+		return
+	}
+	metaB, ok := roadStatusMeta(c, *p.B)
+	if !ok || !checkSimAccess(c, metaB) {
+		return
+	}
+	if metaA.Map != metaB.Map {
+		util.AbortWithError(c, util.NewError(util.CodeBadRequest, "simulations should share the same map"))
+		return
+	}
+	begin := lo.Max([]int{metaA.Start, metaB.Start})
+	end := lo.Min([]int{metaA.Start + metaA.Steps, metaB.Start + metaB.Steps})
+	if p.Begin != nil {
+		begin = lo.Max([]int{begin, *p.Begin})
+	}
+	if p.End != nil {
+		end = lo.Min([]int{end, *p.End})
+	}
+	if end <= begin {
+		util.AbortWithError(c, util.NewError(util.CodeBadRequest, "no common step range"))
+		return
+	}
+	ctx := c.Request.Context()
+	a, err := queryComparedSim(ctx, metaA, begin, end, *p.Interval)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	b, err := queryComparedSim(ctx, metaB, begin, end, *p.Interval)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	g, err := mapGeometries.get(ctx, metaA.Map)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+
+	res := &SimComparison{
+		A:            metaA.Name,
+		B:            metaB.Name,
+		Begin:        begin,
+		End:          end,
+		Interval:     *p.Interval,
+		Steps:        make([]StepComparison, 0),
+		Roads:        make([]RoadCongestionDelta, 0),
+		RoadsGeoJson: make([]*geojson.Feature, 0),
+	}
+	for _, step := range lo.RangeWithSteps(begin, end, *p.Interval) {
+		one := StepComparison{Step: step, LevelCounts: make([]MetricDiff, 4)}
+		carA, carB := a.cars[step], b.cars[step]
+		if carA == nil {
+			carA = &CarStepStat{}
+		}
+		if carB == nil {
+			carB = &CarStepStat{}
+		}
+		one.Vehicles = newMetricDiff(float64(carA.Vehicles), float64(carB.Vehicles))
+		one.MeanV = newMetricDiff(carA.MeanV, carB.MeanV)
+		roadA, roadB := a.roadStats[step], b.roadStats[step]
+		one.MeanCongestionLevel = newMetricDiff(roadA.MeanCongestionLevel, roadB.MeanCongestionLevel)
+		for i := range one.LevelCounts {
+			var countA, countB int
+			if roadA.LevelCounts != nil {
+				countA = roadA.LevelCounts[i]
+			}
+			if roadB.LevelCounts != nil {
+				countB = roadB.LevelCounts[i]
+			}
+			one.LevelCounts[i] = newMetricDiff(float64(countA), float64(countB))
+		}
+		res.Steps = append(res.Steps, one)
+	}
+	id2Delta := make(map[int]RoadCongestionDelta)
+	for id, ca := range a.congestions {
+		if cb, ok := b.congestions[id]; ok {
+			id2Delta[id] = RoadCongestionDelta{Id: id, MeanLevel: newMetricDiff(ca.MeanLevel, cb.MeanLevel)}
+		}
+	}
+	res.Roads = append(res.Roads, lo.Values(id2Delta)...)
+	sort.Slice(res.Roads, func(i, j int) bool {
+		di, dj := math.Abs(res.Roads[i].MeanLevel.Delta), math.Abs(res.Roads[j].MeanLevel.Delta)
+		if di != dj {
+			return di > dj
+		}
+		return res.Roads[i].Id < res.Roads[j].Id
+	})
+	vMin := metaA.RoadStatusVMin
+	if vMin == nil {
+		vMin = metaB.RoadStatusVMin
+	}
+	if vMin == nil {
+		c.JSON(200, util.NewResponse(res))
+		return
+	}
+	for _, rl := range g.outermostRoadLanes(*vMin) {
+		d, ok := id2Delta[int(rl.Road.ID)]
+		if !ok {
+			continue
+		}
+		feature := newGeoJsonLane(rl.Road.ID, rl.Lane.Type, rl.Lane.Line)
+		feature.Properties["a"] = d.MeanLevel.A
+		feature.Properties["b"] = d.MeanLevel.B
+		feature.Properties["delta"] = d.MeanLevel.Delta
+		feature.Properties["color"] = levelDeltaColor(d.MeanLevel.Delta)
+		res.RoadsGeoJson = append(res.RoadsGeoJson, feature)
+	}
+	c.JSON(200, util.NewResponse(res))
+}
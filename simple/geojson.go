@@ -1,18 +1,15 @@
 package simple
 
 import (
-	"context"
 	"errors"
-	"strings"
+	"net/http"
 
 	"git.fiblab.net/sim/backend/util"
-	"git.fiblab.net/utils/lens"
-	"git.fiblab.net/utils/proj"
 	"github.com/gin-gonic/gin"
 	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
 	"github.com/paulmach/orb/geojson"
 	"github.com/samber/lo"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 const (
@@ -28,38 +25,62 @@ const (
 	RoadLane
 )
 
-type mapHeader struct {
+type MapHeader struct {
 	Data struct {
+		Name       string `bson:"name"`
 		Projection string `bson:"projection"`
 	} `bson:"data"`
 }
 
-type mapNode struct {
+type MapNode struct {
 	X float64 `bson:"x"`
 	Y float64 `bson:"y"`
 }
 
-type mapLane struct {
-	ID   int32     `bson:"id"`
-	Line []mapNode `bson:"line"`
-	Type int32     `bson:"type"`
+// 车道的连接关系 Connection of a lane to its predecessor or successor
+type MapLaneConnection struct {
+	ID   int32 `bson:"id" json:"id"`
+	Type int32 `bson:"type" json:"type"` // 1: 连接到对方的起点 head of the other lane, 2: 终点 tail
 }
 
-type mapAoi struct {
+type MapLane struct {
+	ID           int32               `bson:"id"`
+	Line         []MapNode           `bson:"line"`
+	Type         int32               `bson:"type"`
+	Turn         int32               `bson:"turn"`
+	ParentID     int32               `bson:"parent_id"`
+	MaxSpeed     float64             `bson:"max_speed"`
+	Width        float64             `bson:"width"`
+	Predecessors []MapLaneConnection `bson:"predecessors"`
+	Successors   []MapLaneConnection `bson:"successors"`
+}
+
+type MapAoi struct {
 	ID        int32     `bson:"id"`
-	Positions []mapNode `bson:"positions"`
+	Positions []MapNode `bson:"positions"`
+}
+
+type MapRoad struct {
+	ID      int32   `bson:"id" json:"id"`
+	Name    string  `bson:"name" json:"name"`
+	LaneIDs []int32 `bson:"lane_ids" json:"lane_ids"`
 }
 
-type mapRoad struct {
-	ID      int32   `bson:"id"`
-	LaneIDs []int32 `bson:"lane_ids"`
+// 路口内从一条道路驶向另一条道路的车道组 Junction lanes from one road to another
+type MapDrivingLaneGroup struct {
+	InRoadID  int32   `bson:"in_road_id" json:"in_road_id"`
+	OutRoadID int32   `bson:"out_road_id" json:"out_road_id"`
+	LaneIDs   []int32 `bson:"lane_ids" json:"lane_ids"`
 }
 
-func newGeoJsonLane(id int32, typ int32, coordinates [][]float64) *geojson.Feature {
-	lineString := orb.LineString(lo.Map(coordinates, func(c []float64, _ int) orb.Point {
-		return orb.Point{c[0], c[1]}
-	}))
-	feature := geojson.NewFeature(lineString)
+type MapJunction struct {
+	ID                int32                 `bson:"id" json:"id"`
+	LaneIDs           []int32               `bson:"lane_ids" json:"lane_ids"`
+	DrivingLaneGroups []MapDrivingLaneGroup `bson:"driving_lane_groups" json:"driving_lane_groups"`
+}
+
+func newGeoJsonLane(id int32, typ int32, line orb.Geometry) *geojson.Feature {
+	feature := geojson.NewFeature(line)
 	feature.ID = id
 	feature.Properties = map[string]any{
 		"id":   id,
@@ -68,123 +89,210 @@ func newGeoJsonLane(id int32, typ int32, coordinates [][]float64) *geojson.Featu
 	return feature
 }
 
-func downloadLanes(c *gin.Context, name string, typ LaneType) (geojsons []*geojson.Feature, finished bool) {
+func newGeoJsonAoi(a *geoAoi, polygon orb.Geometry) *geojson.Feature {
+	feature := geojson.NewFeature(polygon)
+	feature.ID = a.ID
+	feature.Properties = map[string]any{
+		"id": a.ID,
+	}
+	return feature
+}
+
+// 查询模拟对应地图的几何（经过缓存） Get the (cached) map geometry of the simulation
+func queryMapGeometry(c *gin.Context, name string) (meta *Metadata, g *mapGeometry, finished bool) {
 	finished = true
 
-	metas, err := QueryMetadata(&name)
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	} else if len(metas) == 0 {
-		c.JSON(404, util.NewErrorResponse(errors.New("not found")))
+	meta, ok := findSim(c, name)
+	if !ok {
 		return
 	}
-	meta := metas[0]
-	parts := strings.Split(meta.Map, ".")
-	if len(parts) != 2 {
-		c.JSON(500, util.NewErrorResponse(errors.New("bad map path format")))
+	g, err := mapGeometries.get(c.Request.Context(), meta.Map)
+	if err != nil {
+		util.AbortWithError(c, err)
 		return
 	}
-	col := lens.DefaultMongo().Client().Database(parts[0]).Collection(parts[1])
-	// header
-	header := col.FindOne(context.Background(), bson.M{"class": "header"})
-	if header.Err() != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
+	finished = false
+	return
+}
+
+// 几何接口的参数：可选的经纬度范围（四个参数需同时给出）与是否裁剪
+// Parameters of the geometry routes: the optional bbox (the four parameters should be given together) and clipping
+type GeometryParam struct {
+	Lng1 *float64 `form:"lng1"`
+	Lng2 *float64 `form:"lng2"`
+	Lat1 *float64 `form:"lat1"`
+	Lat2 *float64 `form:"lat2"`
+	Clip bool     `form:"clip"` // 在bbox或多边形的边界处裁剪几何 Cut the geometries at the boundary of the bbox or the polygon
+}
+
+func (p *GeometryParam) Check() error {
+	given := lo.CountBy([]*float64{p.Lng1, p.Lng2, p.Lat1, p.Lat2}, func(v *float64) bool { return v != nil })
+	if given != 0 && given != 4 {
+		return errors.New("bbox requires lng1, lng2, lat1 and lat2")
 	}
-	var h mapHeader
-	if err := header.Decode(&h); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
+	return nil
+}
+
+// 请求的空间范围：POST时为请求体中的GeoJSON多边形，否则为query中的bbox；均未给出时为nil，失败时中止请求
+// Spatial region of the request: the GeoJSON polygon in the body for POST, otherwise the bbox in the query;
+// nil if neither is given, the request is aborted on failure
+func requestRegion(c *gin.Context) (r *region, clipping bool, ok bool) {
+	p := &GeometryParam{}
+	if err := c.ShouldBindQuery(p); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return nil, false, false
+	}
+	if err := p.Check(); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return nil, false, false
+	}
+	if c.Request.Method == http.MethodPost {
+		body, err := c.GetRawData()
+		if err != nil {
+			util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+			return nil, false, false
+		}
+		polygon, err := util.ParseGeoJSONPolygon(body)
+		if err != nil {
+			util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+			return nil, false, false
+		}
+		return polygonRegion(polygon), p.Clip, true
 	}
-	xy2lnglat, err := proj.NewProjector(h.Data.Projection, WGS84CRS)
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
+	if p.Lng1 == nil {
+		return nil, p.Clip, true
 	}
-	defer xy2lnglat.Close()
-	lnglat2xy, err := proj.NewProjector(WGS84CRS, h.Data.Projection)
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
+	return boundRegion(orb.MultiPoint{{*p.Lng1, *p.Lat1}, {*p.Lng2, *p.Lat2}}.Bound()), p.Clip, true
+}
+
+// 几何的裁剪范围，不裁剪时为nil Region to clip the geometries to, nil if not clipping
+func clipRegion(r *region, clipping bool) *region {
+	if !clipping {
+		return nil
 	}
-	defer lnglat2xy.Close()
-	minXY := lnglat2xy.Transform(&proj.Coord{X: meta.MinLat, Y: meta.MinLng})
-	maxXY := lnglat2xy.Transform(&proj.Coord{X: meta.MaxLat, Y: meta.MaxLng})
-
-	// lanes
-
-	var parentIDFilter bson.D
-	switch typ {
-	case AllLane:
-		parentIDFilter = bson.D{{Key: "$exists", Value: true}}
-	case JunctionLane:
-		parentIDFilter = bson.D{{Key: "$gte", Value: 300000000}}
-	case RoadLane:
-		parentIDFilter = bson.D{{Key: "$lt", Value: 300000000}}
-	}
-
-	cur, err := col.Aggregate(context.Background(), bson.A{
-		bson.D{{Key: "$match", Value: bson.D{
-			{Key: "class", Value: "lane"},
-			{Key: "data.parent_id", Value: parentIDFilter},
-			{Key: "data.center_line.nodes", Value: bson.D{
-				{Key: "$elemMatch", Value: bson.D{
-					{Key: "x", Value: bson.D{
-						{Key: "$gte", Value: minXY.X},
-						{Key: "$lte", Value: maxXY.X},
-					}},
-					{Key: "y", Value: bson.D{
-						{Key: "$gte", Value: minXY.Y},
-						{Key: "$lte", Value: maxXY.Y},
-					}},
-				}},
-			}},
-		}}},
-		bson.D{{Key: "$project", Value: bson.D{
-			{Key: "id", Value: "$data.id"},
-			{Key: "line", Value: "$data.center_line.nodes"},
-			{Key: "type", Value: "$data.type"},
-		}}},
-	})
+	return r
+}
 
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
+// 在范围边界处裁剪折线，线可能被分为多段；r为nil时不裁剪，裁剪后为空时返回nil
+// Cut the line string at the boundary of the region, it may be split into pieces; not clipped if r is nil,
+// nil if nothing is left
+func clipLine(line orb.LineString, r *region) orb.Geometry {
+	if r == nil {
+		return line
+	}
+	var mls orb.MultiLineString
+	if r.polygon == nil {
+		mls = clip.LineString(r.bound, line)
+	} else {
+		mls = util.ClipLineStringByPolygon(line, r.polygon)
 	}
-	var lanes []*mapLane
-	if err := cur.All(context.Background(), &lanes); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
+	switch len(mls) {
+	case 0:
+		return nil
+	case 1:
+		return mls[0]
 	}
-	inMicroscopic := func(n mapNode) bool {
-		return minXY.X <= n.X && n.X <= maxXY.X &&
-			minXY.Y <= n.Y && n.Y <= maxXY.Y
+	return mls
+}
+
+// 在范围边界处裁剪多边形；r为nil时不裁剪，裁剪后为空时返回nil
+// Cut the polygon at the boundary of the region; not clipped if r is nil, nil if nothing is left
+func clipPolygon(p orb.Polygon, r *region) orb.Geometry {
+	if r == nil {
+		return p
+	}
+	if r.polygon == nil {
+		// clip.Polygon会修改输入，缓存的几何需复制 clip.Polygon modifies the input, the cached geometry is copied
+		if clipped := clip.Polygon(r.bound, p.Clone()); clipped != nil {
+			return clipped
+		}
+		return nil
 	}
-	convertToLngLat := func(n mapNode, _ int) []float64 {
-		c := xy2lnglat.Transform(&proj.Coord{X: n.X, Y: n.Y})
-		return []float64{c.Y, c.X}
+	mp := util.IntersectPolygons(orb.MultiPolygon{p}, r.polygon)
+	switch len(mp) {
+	case 0:
+		return nil
+	case 1:
+		return mp[0]
 	}
-	geojsons = lo.FilterMap(lanes, func(l *mapLane, _ int) (*geojson.Feature, bool) {
-		if !lo.SomeBy(l.Line, inMicroscopic) {
-			// 不在微观区域内，跳过
+	return mp
+}
+
+func (g *mapGeometry) laneFeatures(lanes []*geoLane, cr *region, sets propertySet) []*geojson.Feature {
+	return lo.FilterMap(lanes, func(l *geoLane, _ int) (*geojson.Feature, bool) {
+		line := clipLine(l.Line, cr)
+		if line == nil {
 			return nil, false
 		}
-		coordinates := lo.Map(l.Line, convertToLngLat)
-		geoLane := newGeoJsonLane(l.ID, l.Type, coordinates)
-		return geoLane, true
+		feature := newGeoJsonLane(l.ID, l.Type, line)
+		g.addLaneProperties(feature.Properties, l, sets)
+		return feature, true
 	})
-	finished = false
+}
+
+// 道路以最靠外的行车道表示，用road的id替换lane的id
+// Roads drawn as the outermost driving lanes, with the road id instead of the lane id
+func (g *mapGeometry) roadFeatures(roadLanes []geoRoadLane, cr *region, sets propertySet) []*geojson.Feature {
+	return lo.FilterMap(roadLanes, func(rl geoRoadLane, _ int) (*geojson.Feature, bool) {
+		line := clipLine(rl.Lane.Line, cr)
+		if line == nil {
+			return nil, false
+		}
+		feature := newGeoJsonLane(rl.Road.ID, rl.Lane.Type, line)
+		g.addLaneProperties(feature.Properties, rl.Lane, sets)
+		return feature, true
+	})
+}
+
+func aoiFeatures(aois []*geoAoi, cr *region) []*geojson.Feature {
+	return lo.FilterMap(aois, func(a *geoAoi, _ int) (*geojson.Feature, bool) {
+		polygon := clipPolygon(a.Polygon, cr)
+		if polygon == nil {
+			return nil, false
+		}
+		return newGeoJsonAoi(a, polygon), true
+	})
+}
+
+func downloadLanes(c *gin.Context, name string, typ LaneType) (geojsons []*geojson.Feature, finished bool) {
+	finished = true
+	r, clipping, ok := requestRegion(c)
+	if !ok {
+		return
+	}
+	sets, ok := requestFields(c)
+	if !ok {
+		return
+	}
+	meta, g, finished := queryMapGeometry(c, name)
+	if finished {
+		return
+	}
+	// 默认只保留与微观区域相交的车道 only lanes intersecting the microscopic area by default
+	if r == nil {
+		r = boundRegion(metaBound(meta))
+	}
+	geojsons = g.laneFeatures(g.lanes(typ, r), clipRegion(r, clipping), sets)
 	return
 }
 
 // @Summary Load junction lane geojson
+// @Description Lanes intersecting the bbox, the microscopic area of the simulation by default.
+// @Description POST a GeoJSON Polygon/MultiPolygon instead of the bbox to select the lanes intersecting the polygon.
+// @Accept application/json
 // @Produce application/json
 // @Param tablename path string true "Simulation Name"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
+// @Param fields query string false "comma separated property sets: lane, topology, road, junction or all"
 // @Success 200
 // @Router /simple/junclane/{tablename} [get]
+// @Router /simple/junclane/{tablename} [post]
 func GetJunclaneByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
@@ -196,12 +304,22 @@ func GetJunclaneByName(c *gin.Context) {
 }
 
 // @Summary Load road lane geojson in microscopic area
+// @Description Lanes intersecting the bbox, the microscopic area of the simulation by default.
+// @Description POST a GeoJSON Polygon/MultiPolygon instead of the bbox to select the lanes intersecting the polygon.
+// @Accept application/json
 // @Produce application/json
 // @Param tablename path string true "Simulation Name"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
+// @Param fields query string false "comma separated property sets: lane, topology, road, junction or all"
 // @Success 200
 // @Router /simple/all-roadlane/{tablename} [get]
+// @Router /simple/all-roadlane/{tablename} [post]
 func GetAllRoadlaneByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
@@ -213,12 +331,22 @@ func GetAllRoadlaneByName(c *gin.Context) {
 }
 
 // @Summary Load lane geojson in microscopic area
+// @Description Lanes intersecting the bbox, the microscopic area of the simulation by default.
+// @Description POST a GeoJSON Polygon/MultiPolygon instead of the bbox to select the lanes intersecting the polygon.
+// @Accept application/json
 // @Produce application/json
 // @Param tablename path string true "Simulation Name"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
+// @Param fields query string false "comma separated property sets: lane, topology, road, junction or all"
 // @Success 200
 // @Router /simple/all-lane/{tablename} [get]
+// @Router /simple/all-lane/{tablename} [post]
 func GetAllLaneByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
@@ -230,226 +358,82 @@ func GetAllLaneByName(c *gin.Context) {
 }
 
 // @Summary Load road lane geojson in microscopic area
+// @Description Every road with road status is drawn as its outermost driving lane, all roads by default.
+// @Description With a bbox or a POSTed GeoJSON Polygon/MultiPolygon only the roads whose lane intersects it are returned.
+// @Accept application/json
 // @Produce application/json
 // @Param tablename path string true "Simulation Name"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
+// @Param fields query string false "comma separated property sets: lane, topology, road, junction or all"
 // @Success 200
 // @Router /simple/roadlane/{tablename} [get]
+// @Router /simple/roadlane/{tablename} [post]
 func GetRoadlaneByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
-
-	metas, err := QueryMetadata(&u.Name)
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	} else if len(metas) == 0 {
-		c.JSON(404, util.NewErrorResponse(errors.New("not found")))
-		return
-	}
-	meta := metas[0]
-	parts := strings.Split(meta.Map, ".")
-	if meta.RoadStatusVMin == nil {
-		c.JSON(400, util.NewErrorResponse(errors.New("no road status information")))
+	r, clipping, ok := requestRegion(c)
+	if !ok {
 		return
 	}
-	if len(parts) != 2 {
-		c.JSON(500, util.NewErrorResponse(errors.New("bad map path format")))
-		return
-	}
-	col := lens.DefaultMongo().Client().Database(parts[0]).Collection(parts[1])
-	// header
-	header := col.FindOne(context.Background(), bson.M{"class": "header"})
-	if header.Err() != nil {
-		c.JSON(500, util.NewErrorResponse(header.Err()))
-		return
-	}
-	var h mapHeader
-	if err := header.Decode(&h); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	}
-	xy2lnglat, err := proj.NewProjector(h.Data.Projection, WGS84CRS)
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	}
-	defer xy2lnglat.Close()
-
-	// candidate lanes
-	cur, err := col.Aggregate(context.Background(), bson.A{
-		bson.D{{Key: "$match", Value: bson.D{
-			{Key: "class", Value: "lane"},
-			{Key: "data.parent_id", Value: bson.D{{Key: "$lt", Value: 300000000}}},             // road lane
-			{Key: "data.type", Value: 1},                                                       // type: driving
-			{Key: "data.max_speed", Value: bson.D{{Key: "$gte", Value: *meta.RoadStatusVMin}}}, // max_speed >= v_min
-		}}},
-		bson.D{{Key: "$project", Value: bson.D{
-			{Key: "id", Value: "$data.id"},
-			{Key: "line", Value: "$data.center_line.nodes"},
-			{Key: "type", Value: "$data.type"},
-		}}},
-	})
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	}
-	var lanes []*mapLane
-	if err := cur.All(context.Background(), &lanes); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+	sets, ok := requestFields(c)
+	if !ok {
 		return
 	}
 
-	// candidate road
-	cur, err = col.Aggregate(context.Background(), bson.A{
-		bson.D{{Key: "$match", Value: bson.D{
-			{Key: "class", Value: "road"},
-		}}},
-		bson.D{{Key: "$project", Value: bson.D{
-			{Key: "id", Value: "$data.id"},
-			{Key: "lane_ids", Value: "$data.lane_ids"},
-		}}},
-	})
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+	meta, g, finished := queryMapGeometry(c, u.Name)
+	if finished {
 		return
 	}
-	var roads []*mapRoad
-	if err := cur.All(context.Background(), &roads); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+	if meta.RoadStatusVMin == nil {
+		util.AbortWithError(c, errNoRoadStatus(meta.Name))
 		return
 	}
 
-	// 根据road找到对应的lanes
-	id2Lanes := make(map[int32]*mapLane)
-	for _, l := range lanes {
-		id2Lanes[l.ID] = l
-	}
-	roadLanes := make([]*mapLane, 0)
-	for _, r := range roads {
-		// 找到合适的driving lane（最靠外的）
-		for i := len(r.LaneIDs) - 1; i >= 0; i-- {
-			if lane := id2Lanes[r.LaneIDs[i]]; lane != nil {
-				lane.ID = r.ID // 用road的id替换lane的id
-				roadLanes = append(roadLanes, lane)
-				break
-			}
-		}
-	}
-
-	// 转换为geojson
-	convertToLngLat := func(n mapNode, _ int) []float64 {
-		c := xy2lnglat.Transform(&proj.Coord{X: n.X, Y: n.Y})
-		return []float64{c.Y, c.X}
-	}
-	geojsons := lo.FilterMap(roadLanes, func(l *mapLane, _ int) (*geojson.Feature, bool) {
-		coordinates := lo.Map(l.Line, convertToLngLat)
-		geoLane := newGeoJsonLane(l.ID, l.Type, coordinates)
-		return geoLane, true
-	})
+	// 每条道路取最靠外的行车道 the outermost driving lane of each road
+	geojsons := g.roadFeatures(roadLanesIn(g.outermostRoadLanes(*meta.RoadStatusVMin), r), clipRegion(r, clipping), sets)
 
 	c.JSON(200, util.NewResponse(geojsons))
 }
 
 // @Summary Load Aoi GeoJSON in microscopic area
+// @Description AOIs intersecting the bbox, the microscopic area of the simulation by default.
+// @Description POST a GeoJSON Polygon/MultiPolygon instead of the bbox to select the AOIs intersecting the polygon.
+// @Accept application/json
 // @Produce application/json
 // @Param tablename path string true "Simulation Name"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
 // @Success 200
 // @Router /simple/aoi/{tablename} [get]
+// @Router /simple/aoi/{tablename} [post]
 func GetAoiByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
-
-	metas, err := QueryMetadata(&u.Name)
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	} else if len(metas) == 0 {
-		c.JSON(404, util.NewErrorResponse(errors.New("not found")))
-		return
-	}
-	meta := metas[0]
-	parts := strings.Split(meta.Map, ".")
-	if len(parts) != 2 {
-		c.JSON(500, util.NewErrorResponse(errors.New("bad map path format")))
-		return
-	}
-	col := lens.DefaultMongo().Client().Database(parts[0]).Collection(parts[1])
-	// header
-	header := col.FindOne(context.Background(), bson.M{"class": "header"})
-	if header.Err() != nil {
-		c.JSON(500, util.NewErrorResponse(header.Err()))
+	r, clipping, ok := requestRegion(c)
+	if !ok {
 		return
 	}
-	var h mapHeader
-	if err := header.Decode(&h); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	}
-	xy2lnglat, err := proj.NewProjector(h.Data.Projection, WGS84CRS)
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	}
-	defer xy2lnglat.Close()
-	lnglat2xy, err := proj.NewProjector(WGS84CRS, h.Data.Projection)
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
-	}
-	defer lnglat2xy.Close()
-	minXY := lnglat2xy.Transform(&proj.Coord{X: meta.MinLat, Y: meta.MinLng})
-	maxXY := lnglat2xy.Transform(&proj.Coord{X: meta.MaxLat, Y: meta.MaxLng})
-
-	// aoi
-	cur, err := col.Aggregate(context.Background(), bson.A{
-		bson.D{{Key: "$match", Value: bson.D{
-			{Key: "class", Value: "aoi"},
-			{Key: "data.area", Value: bson.D{{Key: "$exists", Value: true}}},
-			{Key: "data.positions", Value: bson.D{
-				{Key: "$elemMatch", Value: bson.D{
-					{Key: "x", Value: bson.D{
-						{Key: "$gte", Value: minXY.X},
-						{Key: "$lte", Value: maxXY.X},
-					}},
-					{Key: "y", Value: bson.D{
-						{Key: "$gte", Value: minXY.Y},
-						{Key: "$lte", Value: maxXY.Y},
-					}},
-				}},
-			}},
-		}}},
-		bson.D{{Key: "$project", Value: bson.D{
-			{Key: "id", Value: "$data.id"},
-			{Key: "positions", Value: "$data.positions"},
-		}}},
-	})
-	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+
+	meta, g, finished := queryMapGeometry(c, u.Name)
+	if finished {
 		return
 	}
-	var aois []*mapAoi
-	if err := cur.All(context.Background(), &aois); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-		return
+	// 默认只保留与微观区域相交的AOI only AOIs intersecting the microscopic area by default
+	if r == nil {
+		r = boundRegion(metaBound(meta))
 	}
-	convertToLngLat := func(n mapNode, _ int) orb.Point {
-		c := xy2lnglat.Transform(&proj.Coord{X: n.X, Y: n.Y})
-		return orb.Point{c.Y, c.X}
-	}
-	geojsons := lo.Map(aois, func(a *mapAoi, _ int) *geojson.Feature {
-		coordinates := lo.Map(a.Positions, convertToLngLat)
-		polygon := orb.Polygon([]orb.Ring{coordinates})
-		feature := geojson.NewFeature(polygon)
-		feature.ID = a.ID
-		feature.Properties = map[string]any{
-			"id": a.ID,
-		}
-		return feature
-	})
+	geojsons := aoiFeatures(g.aois(r), clipRegion(r, clipping))
 
 	c.JSON(200, util.NewResponse(geojsons))
 }
@@ -0,0 +1,207 @@
+package simple
+
+import (
+	"context"
+	"sort"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/samber/lo"
+)
+
+// 路口内车道的连接关系 Connection of a junction lane
+type JunctionConnection struct {
+	LaneID     int32   `json:"lane_id"`
+	Type       int32   `json:"type"`         // 车道类型 Lane type
+	Turn       int32   `json:"turn"`         // 转向 Turn type
+	InRoadID   *int32  `json:"in_road_id"`   // 驶入的道路，前驱不是道路车道时为null The entry road, null if no predecessor is on a road
+	OutRoadID  *int32  `json:"out_road_id"`  // 驶出的道路 The exit road
+	InLaneIDs  []int32 `json:"in_lane_ids"`  // 前驱车道 Predecessor lanes
+	OutLaneIDs []int32 `json:"out_lane_ids"` // 后继车道 Successor lanes
+}
+
+// 连接车道所属的道路，不是道路车道时为nil The road of the connected lane, nil if it is not on a road
+func (g *mapGeometry) connectedRoad(cs []MapLaneConnection) *int32 {
+	for _, c := range cs {
+		if l := g.laneByID[c.ID]; l != nil && !l.isJunctionLane() {
+			id := l.ParentID
+			return &id
+		}
+	}
+	return nil
+}
+
+func (g *mapGeometry) junctionConnections(j *geoJunction) []JunctionConnection {
+	connections := make([]JunctionConnection, 0, len(j.LaneIDs))
+	for _, id := range j.LaneIDs {
+		l := g.laneByID[id]
+		if l == nil {
+			continue
+		}
+		connectionIDs := func(c MapLaneConnection, _ int) int32 { return c.ID }
+		connections = append(connections, JunctionConnection{
+			LaneID:     l.ID,
+			Type:       l.Type,
+			Turn:       l.Turn,
+			InRoadID:   g.connectedRoad(l.Predecessors),
+			OutRoadID:  g.connectedRoad(l.Successors),
+			InLaneIDs:  lo.Map(l.Predecessors, connectionIDs),
+			OutLaneIDs: lo.Map(l.Successors, connectionIDs),
+		})
+	}
+	return connections
+}
+
+// 驶入与驶出路口的道路，来自车道组与车道的连接关系，按ID升序排列
+// Entry and exit roads of the junction from the driving lane groups and the lane connections, in ascending ID order
+func junctionRoads(j *geoJunction, connections []JunctionConnection) (entries, exits []int32) {
+	for _, group := range j.DrivingLaneGroups {
+		entries = append(entries, group.InRoadID)
+		exits = append(exits, group.OutRoadID)
+	}
+	for _, c := range connections {
+		if c.InRoadID != nil {
+			entries = append(entries, *c.InRoadID)
+		}
+		if c.OutRoadID != nil {
+			exits = append(exits, *c.OutRoadID)
+		}
+	}
+	sortIDs := func(ids []int32) []int32 {
+		ids = lo.Uniq(ids)
+		sort.Slice(ids, func(i, k int) bool { return ids[i] < ids[k] })
+		return ids
+	}
+	return sortIDs(entries), sortIDs(exits)
+}
+
+// 路口的轮廓：车道的凸包，车道节点不足以构成多边形时为车道范围的中心
+// Footprint of the junction: the convex hull of its lanes, the center of the lanes if they cannot form a polygon
+func (g *mapGeometry) junctionFootprint(j *geoJunction) orb.Geometry {
+	if j.Hull != nil {
+		return orb.Polygon{j.Hull}
+	}
+	var points orb.MultiPoint
+	for _, id := range j.LaneIDs {
+		if l := g.laneByID[id]; l != nil {
+			points = append(points, l.Line...)
+		}
+	}
+	if len(points) == 0 {
+		return nil
+	}
+	return points.Bound().Center()
+}
+
+// 与范围相交的路口，r为nil时返回所有路口 Junctions intersecting the region, all junctions if r is nil
+func (g *mapGeometry) junctionsIn(r *region) []*geoJunction {
+	if r == nil {
+		return g.Junctions
+	}
+	return lo.Filter(g.Junctions, func(j *geoJunction, _ int) bool {
+		switch footprint := g.junctionFootprint(j).(type) {
+		case orb.Polygon:
+			return r.intersectsPolygon(footprint)
+		case orb.Point:
+			return r.intersectsLine(orb.LineString{footprint})
+		}
+		return false
+	})
+}
+
+// 在模拟的第一步有信号灯记录的车道，信号灯表不存在时为空
+// Lanes with a traffic light record at the first step of the simulation, empty if there is no traffic light table
+func queryTrafficLightLanes(ctx context.Context, meta *Metadata, ids []int) (map[int32]bool, error) {
+	lights, err := storage.Trajectory.TrafficLights(ctx, meta.tables(), ids, StepQuery{meta.Start, meta.Start + 1, 1, 1})
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		return nil, err
+	}
+	lanes := make(map[int32]bool, len(lights))
+	for _, one := range lights {
+		lanes[int32(one.Id)] = true
+	}
+	return lanes, nil
+}
+
+// @Summary Load junction geojson
+// @Description Junctions intersecting the bbox, the microscopic area of the simulation by default.
+// @Description The geometry is the convex hull of the junction lanes (a point if they cannot form a polygon).
+// @Description The properties are the entry and exit roads, the connections of the junction lanes with their turn types,
+// @Description the driving lane groups and the junction lanes with traffic lights in the simulation.
+// @Description POST a GeoJSON Polygon/MultiPolygon instead of the bbox to select the junctions intersecting the polygon.
+// @Accept application/json
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
+// @Success 200
+// @Router /simple/junctions/{tablename} [get]
+// @Router /simple/junctions/{tablename} [post]
+func GetJunctionsByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	r, clipping, ok := requestRegion(c)
+	if !ok {
+		return
+	}
+
+	meta, g, finished := queryMapGeometry(c, u.Name)
+	if finished {
+		return
+	}
+	// 默认只保留与微观区域相交的路口 only junctions intersecting the microscopic area by default
+	if r == nil {
+		r = boundRegion(metaBound(meta))
+	}
+	junctions := g.junctionsIn(r)
+	laneIDs := lo.FlatMap(junctions, func(j *geoJunction, _ int) []int {
+		return lo.Map(j.LaneIDs, func(id int32, _ int) int { return int(id) })
+	})
+	lightLanes, err := queryTrafficLightLanes(c.Request.Context(), meta, laneIDs)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+
+	cr := clipRegion(r, clipping)
+	geojsons := lo.FilterMap(junctions, func(j *geoJunction, _ int) (*geojson.Feature, bool) {
+		footprint := g.junctionFootprint(j)
+		switch f := footprint.(type) {
+		case orb.Polygon:
+			footprint = clipPolygon(f, cr)
+		case orb.Point:
+			if cr != nil && !cr.containsPoint(f) {
+				footprint = nil
+			}
+		}
+		if footprint == nil {
+			return nil, false
+		}
+		connections := g.junctionConnections(j)
+		entries, exits := junctionRoads(j, connections)
+		groups := j.DrivingLaneGroups
+		if groups == nil {
+			groups = []MapDrivingLaneGroup{}
+		}
+		feature := geojson.NewFeature(footprint)
+		feature.ID = j.ID
+		feature.Properties = map[string]any{
+			"id":                  j.ID,
+			"entry_roads":         entries,
+			"exit_roads":          exits,
+			"connections":         connections,
+			"driving_lane_groups": groups,
+			"traffic_light_lanes": lo.Filter(j.LaneIDs, func(id int32, _ int) bool { return lightLanes[id] }),
+		}
+		return feature, true
+	})
+
+	c.JSON(200, util.NewResponse(geojsons))
+}
@@ -0,0 +1,120 @@
+package simple
+
+import (
+	"fmt"
+	"strings"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+	"github.com/samber/lo"
+)
+
+// 车道GeoJSON的可选属性集合，通过fields=选择，默认只有id与type
+// Optional property sets of the lane GeoJSON selected by fields=, only id and type by default
+type propertySet uint8
+
+const (
+	// lane_id, width, max_speed, turn, length, parent_id
+	laneProperties propertySet = 1 << iota
+	// predecessors, successors
+	topologyProperties
+	// 所属道路 the parent road: road_id, road_name, driving_lanes, walking_lanes
+	roadProperties
+	// 所属路口 the parent junction: junction_id, junction_polygon, driving_lane_groups
+	junctionProperties
+
+	allProperties = laneProperties | topologyProperties | roadProperties | junctionProperties
+)
+
+var propertySets = map[string]propertySet{
+	"lane":     laneProperties,
+	"topology": topologyProperties,
+	"road":     roadProperties,
+	"junction": junctionProperties,
+	"all":      allProperties,
+}
+
+type FieldsParam struct {
+	Fields string `form:"fields"` // 逗号分隔的属性集合 Comma separated property sets
+}
+
+func (p *FieldsParam) sets() (propertySet, error) {
+	var sets propertySet
+	for _, name := range strings.Split(p.Fields, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		set, ok := propertySets[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown fields %q, should be lane, topology, road, junction or all", name)
+		}
+		sets |= set
+	}
+	return sets, nil
+}
+
+// 解析请求的属性集合，失败时中止请求 Parse the requested property sets, the request is aborted on failure
+func requestFields(c *gin.Context) (propertySet, bool) {
+	p := &FieldsParam{}
+	if err := c.ShouldBindQuery(p); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return 0, false
+	}
+	sets, err := p.sets()
+	if err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return 0, false
+	}
+	return sets, true
+}
+
+// 非nil的连接列表，使JSON中为[]而不是null Non-nil connections so that they are [] instead of null in JSON
+func laneConnections(cs []MapLaneConnection) []MapLaneConnection {
+	if cs == nil {
+		return []MapLaneConnection{}
+	}
+	return cs
+}
+
+// 按属性集合补充车道的属性，道路与路口属性只添加到对应的车道上
+// Add the properties of the lane by the sets, the road and junction properties only to the lanes of them
+func (g *mapGeometry) addLaneProperties(props geojson.Properties, l *geoLane, sets propertySet) {
+	if sets&laneProperties != 0 {
+		props["lane_id"] = l.ID
+		props["width"] = l.Width
+		props["max_speed"] = l.MaxSpeed
+		props["turn"] = l.Turn
+		props["length"] = l.Length
+		props["parent_id"] = l.ParentID
+	}
+	if sets&topologyProperties != 0 {
+		props["predecessors"] = laneConnections(l.Predecessors)
+		props["successors"] = laneConnections(l.Successors)
+	}
+	if sets&roadProperties != 0 && !l.isJunctionLane() {
+		if r := g.roadByID[l.ParentID]; r != nil {
+			props["road_id"] = r.ID
+			props["road_name"] = r.Name
+			props["driving_lanes"] = lo.CountBy(r.LaneIDs, func(id int32) bool { return g.laneTypeIs(id, 1) })
+			props["walking_lanes"] = lo.CountBy(r.LaneIDs, func(id int32) bool { return g.laneTypeIs(id, 2) })
+		}
+	}
+	if sets&junctionProperties != 0 && l.isJunctionLane() {
+		if j := g.junctionByID[l.ParentID]; j != nil {
+			props["junction_id"] = j.ID
+			props["junction_polygon"] = j.Hull
+			groups := j.DrivingLaneGroups
+			if groups == nil {
+				groups = []MapDrivingLaneGroup{}
+			}
+			props["driving_lane_groups"] = groups
+		}
+	}
+}
+
+func (g *mapGeometry) laneTypeIs(id int32, typ int32) bool {
+	l := g.laneByID[id]
+	return l != nil && l.Type == typ
+}
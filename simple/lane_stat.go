@@ -0,0 +1,157 @@
+package simple
+
+import (
+	"errors"
+	"fmt"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
+	"github.com/samber/lo"
+)
+
+// 车道在一个时间段内的车辆统计 Vehicle statistics of a lane in a step bucket
+type LaneCarStat struct {
+	Step      int      `json:"step"`      // 时间段起始step Start step of the bucket
+	LaneId    int      `json:"laneId"`    // 车道ID Lane ID
+	Records   int      `json:"records"`   // 车辆记录数（车·步） Number of vehicle records (vehicle-steps)
+	Vehicles  int      `json:"vehicles"`  // 不同车辆数 Number of distinct vehicles
+	MeanCount float64  `json:"meanCount"` // 平均每步车辆数 Mean number of vehicles per step
+	MeanV     float64  `json:"meanV"`     // 平均速度（米/秒） Mean speed (m/s)
+	MinV      float64  `json:"minV"`      // 最小速度（米/秒） Min speed (m/s)
+	MaxV      float64  `json:"maxV"`      // 最大速度（米/秒） Max speed (m/s)
+	Density   *float64 `json:"density"`   // 密度（辆/千米），车道不在地图中时为null Density (vehicles/km), null if the lane is not in the map
+}
+
+func (s *LaneCarStat) scanTargets() []any {
+	return []any{&s.Step, &s.LaneId, &s.Records, &s.Vehicles, &s.MeanV, &s.MinV, &s.MaxV}
+}
+
+// 参数占位符：1为begin，2为end，3为bucket；placeholder为"$"（PostgreSQL）或"?"（SQLite），from为RecordTables.from的表
+// Parameters: 1 for begin, 2 for end, 3 for bucket; placeholder is "$" (PostgreSQL) or "?" (SQLite),
+// from is the table given by RecordTables.from
+func laneCarStatsSQL(from string, placeholder string) string {
+	p := placeholder
+	return fmt.Sprintf(
+		"SELECT %[2]s1 + (step - %[2]s1) / %[2]s3 * %[2]s3 AS bucket, parent_id, COUNT(*), COUNT(DISTINCT id), AVG(v), MIN(v), MAX(v) "+
+			"FROM %[1]s WHERE step >= %[2]s1 AND step < %[2]s2 "+
+			"GROUP BY bucket, parent_id ORDER BY bucket, parent_id",
+		from, p,
+	)
+}
+
+type LaneStatParam struct {
+	Begin  *int `form:"begin" binding:"required"` // step>=begin
+	End    *int `form:"end"   binding:"required"` // step<end
+	Bucket *int `form:"bucket"`                   // 时间段的step数，默认为整个范围 Steps of a bucket, the whole range by default
+}
+
+func (p *LaneStatParam) Check() error {
+	if *p.End <= *p.Begin {
+		return errors.New("end should be larger than begin")
+	}
+	if p.Bucket == nil {
+		p.Bucket = new(int)
+		*p.Bucket = *p.End - *p.Begin
+	}
+	if *p.Bucket < 1 {
+		return errors.New("bucket should be larger than 0")
+	}
+	return nil
+}
+
+// 查询车道统计并根据地图计算密度 Query the lane statistics and compute the density with the map
+func queryLaneCarStats(c *gin.Context) (stats []*LaneCarStat, g *mapGeometry, finished bool) {
+	finished = true
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	p := util.ValidateParam[LaneStatParam](c)
+	if p == nil {
+		return
+	}
+	meta, ok := simMetadata(c, u.Name)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	// 模拟结束之后没有记录，不计入时间段 there are no records after the simulation ends, which do not count in the buckets
+	end := lo.Min([]int{*p.End, meta.Start + meta.Steps})
+	stats, err := storage.Trajectory.LaneCarStats(ctx, meta.tables(), *p.Begin, end, *p.Bucket)
+	if util.ResponseEmptyIfTableNotFound(c, stats, err) {
+		return
+	}
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	g, err = mapGeometries.get(ctx, meta.Map)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	for _, s := range stats {
+		// 最后一个时间段可能不完整 the last bucket may be partial
+		steps := lo.Min([]int{*p.Bucket, end - s.Step})
+		s.MeanCount = util.ToFixed(float64(s.Records)/float64(steps), 4)
+		s.MeanV = util.ToFixed(s.MeanV, 4)
+		if l := g.laneByID[int32(s.LaneId)]; l != nil && l.Length > 0 {
+			density := util.ToFixed(s.MeanCount/(l.Length/1000), 4)
+			s.Density = &density
+		}
+	}
+	finished = false
+	return
+}
+
+// @Summary Get vehicle count and speed statistics per lane
+// @Description Vehicle records are grouped by lane and step bucket [step, step+bucket)
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Param bucket query number false "Steps of a bucket (default is end-begin, i.e. one bucket)"
+// @Success 200 object util.Response{data=[]LaneCarStat} "successful operation"
+// @Router /simple/lane-stat/{tablename} [get]
+func GetLaneStatByName(c *gin.Context) {
+	stats, _, finished := queryLaneCarStats(c)
+	if finished {
+		return
+	}
+	c.JSON(200, util.NewResponse(stats))
+}
+
+// @Summary Get lane GeoJSON with vehicle statistics for choropleth rendering
+// @Description One LineString feature per lane and step bucket with the fields of LaneCarStat as properties.
+// @Description Lanes without vehicles or not in the map are omitted.
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Param bucket query number false "Steps of a bucket (default is end-begin, i.e. one feature per lane)"
+// @Success 200
+// @Router /simple/lane-stat-geojson/{tablename} [get]
+func GetLaneStatGeoJsonByName(c *gin.Context) {
+	stats, g, finished := queryLaneCarStats(c)
+	if finished {
+		return
+	}
+	geojsons := lo.FilterMap(stats, func(s *LaneCarStat, _ int) (*geojson.Feature, bool) {
+		l := g.laneByID[int32(s.LaneId)]
+		if l == nil {
+			return nil, false
+		}
+		feature := newGeoJsonLane(l.ID, l.Type, l.Line)
+		feature.Properties["step"] = s.Step
+		feature.Properties["records"] = s.Records
+		feature.Properties["vehicles"] = s.Vehicles
+		feature.Properties["meanCount"] = s.MeanCount
+		feature.Properties["meanV"] = s.MeanV
+		feature.Properties["minV"] = s.MinV
+		feature.Properties["maxV"] = s.MaxV
+		feature.Properties["density"] = s.Density
+		return feature, true
+	})
+	c.JSON(200, util.NewResponse(geojsons))
+}
@@ -0,0 +1,533 @@
+package simple
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"git.fiblab.net/sim/backend/util"
+	"git.fiblab.net/utils/proj"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+	"github.com/samber/lo"
+	"github.com/tidwall/rtree"
+	"golang.org/x/sync/singleflight"
+)
+
+// 投影到WGS84的车道 Lane projected into WGS84
+type geoLane struct {
+	order        int // 在地图中的顺序 order in the map
+	ID           int32
+	Type         int32
+	Turn         int32
+	ParentID     int32
+	MaxSpeed     float64
+	Width        float64
+	Predecessors []MapLaneConnection
+	Successors   []MapLaneConnection
+	Line         orb.LineString // [lng, lat]
+	Length       float64        // 长度（米） length (m)
+}
+
+func (l *geoLane) isJunctionLane() bool {
+	return l.ParentID >= 300000000
+}
+
+// 按所属道路/路口筛选 Filter by the parent (road or junction)
+func (l *geoLane) matchParent(typ LaneType) bool {
+	switch typ {
+	case JunctionLane:
+		return l.isJunctionLane()
+	case RoadLane:
+		return !l.isJunctionLane()
+	default:
+		return true
+	}
+}
+
+// 投影到WGS84的AOI AOI projected into WGS84
+type geoAoi struct {
+	order   int
+	ID      int32
+	Polygon orb.Polygon // [lng, lat]
+}
+
+// 路口及其车道的凸包 A junction and the convex hull of its lanes
+type geoJunction struct {
+	*MapJunction
+	Hull orb.Ring // [lng, lat]，车道节点少于3个不共线的点时为nil nil if the lanes have less than 3 non-collinear nodes
+}
+
+// 道路及其最外侧的行车道 A road and its outermost driving lane
+type geoRoadLane struct {
+	Road *MapRoad
+	Lane *geoLane
+}
+
+// 投影到WGS84并建立空间索引的地图几何，只读，可被多个请求共享
+// Map geometry projected into WGS84 with spatial indexes, read-only and shared by requests
+type mapGeometry struct {
+	Name         string
+	Projection   string
+	Lanes        []*geoLane
+	Roads        []*MapRoad
+	Junctions    []*geoJunction
+	Aois         []*geoAoi
+	Bound        orb.Bound // 车道与AOI的经纬度范围 Longitude/latitude bound of the lanes and AOIs
+	laneByID     map[int32]*geoLane
+	roadByID     map[int32]*MapRoad
+	junctionByID map[int32]*geoJunction
+	laneIndex    rtree.RTreeG[*geoLane]
+	aoiIndex     rtree.RTreeG[*geoAoi]
+	nodes        int // 节点总数，用于估计内存占用 total nodes to estimate the memory usage
+}
+
+func loadMapGeometry(ctx context.Context, mapPath string) (*mapGeometry, error) {
+	h, err := storage.Map.Header(ctx, mapPath)
+	if err != nil {
+		return nil, err
+	}
+	xy2lnglat, err := proj.NewProjector(h.Data.Projection, WGS84CRS)
+	if err != nil {
+		return nil, err
+	}
+	defer xy2lnglat.Close()
+	convertToLngLat := func(n MapNode, _ int) orb.Point {
+		c := xy2lnglat.Transform(&proj.Coord{X: n.X, Y: n.Y})
+		return orb.Point{c.Y, c.X}
+	}
+
+	lanes, err := storage.Map.Lanes(ctx, mapPath, LaneFilter{Parent: AllLane})
+	if err != nil {
+		return nil, err
+	}
+	roads, err := storage.Map.Roads(ctx, mapPath)
+	if err != nil {
+		return nil, err
+	}
+	junctions, err := storage.Map.Junctions(ctx, mapPath)
+	if err != nil {
+		return nil, err
+	}
+	aois, err := storage.Map.Aois(ctx, mapPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &mapGeometry{
+		Name:         h.Data.Name,
+		Projection:   h.Data.Projection,
+		Roads:        roads,
+		laneByID:     make(map[int32]*geoLane, len(lanes)),
+		roadByID:     make(map[int32]*MapRoad, len(roads)),
+		junctionByID: make(map[int32]*geoJunction, len(junctions)),
+	}
+	extend := func(b orb.Bound) {
+		if g.nodes == 0 {
+			g.Bound = b
+		} else {
+			g.Bound = g.Bound.Union(b)
+		}
+	}
+	for i, l := range lanes {
+		one := &geoLane{
+			order:        i,
+			ID:           l.ID,
+			Type:         l.Type,
+			Turn:         l.Turn,
+			ParentID:     l.ParentID,
+			MaxSpeed:     l.MaxSpeed,
+			Width:        l.Width,
+			Predecessors: l.Predecessors,
+			Successors:   l.Successors,
+			Line:         lo.Map(l.Line, convertToLngLat),
+		}
+		one.Length = geo.Length(one.Line)
+		g.Lanes = append(g.Lanes, one)
+		g.laneByID[one.ID] = one
+		if len(one.Line) > 0 {
+			b := one.Line.Bound()
+			g.laneIndex.Insert(b.Min, b.Max, one)
+			extend(b)
+		}
+		g.nodes += len(one.Line)
+	}
+	for _, r := range roads {
+		g.roadByID[r.ID] = r
+	}
+	for _, j := range junctions {
+		var points []orb.Point
+		for _, id := range j.LaneIDs {
+			if l := g.laneByID[id]; l != nil {
+				points = append(points, l.Line...)
+			}
+		}
+		one := &geoJunction{MapJunction: j, Hull: util.ConvexHull(points)}
+		g.Junctions = append(g.Junctions, one)
+		g.junctionByID[j.ID] = one
+		g.nodes += len(one.Hull)
+	}
+	for i, a := range aois {
+		one := &geoAoi{
+			order:   i,
+			ID:      a.ID,
+			Polygon: orb.Polygon{lo.Map(a.Positions, convertToLngLat)},
+		}
+		g.Aois = append(g.Aois, one)
+		if len(a.Positions) > 0 {
+			b := one.Polygon.Bound()
+			g.aoiIndex.Insert(b.Min, b.Max, one)
+			extend(b)
+		}
+		g.nodes += len(a.Positions)
+	}
+	return g, nil
+}
+
+// 经纬度范围或多边形 Longitude/latitude bound or polygon
+type region struct {
+	bound   orb.Bound
+	polygon orb.MultiPolygon // 为nil时为bound the bound if nil
+}
+
+func boundRegion(b orb.Bound) *region {
+	return &region{bound: b}
+}
+
+func polygonRegion(p orb.MultiPolygon) *region {
+	return &region{bound: p.Bound(), polygon: p}
+}
+
+func (r *region) intersectsLine(line orb.LineString) bool {
+	if r.polygon == nil {
+		return util.LineIntersectsBound(line, r.bound)
+	}
+	return util.LineIntersectsPolygon(line, r.polygon)
+}
+
+func (r *region) containsPoint(p orb.Point) bool {
+	if r.polygon == nil {
+		return r.bound.Contains(p)
+	}
+	return planar.MultiPolygonContains(r.polygon, p)
+}
+
+func (r *region) intersectsPolygon(p orb.Polygon) bool {
+	if r.polygon == nil {
+		return util.PolygonIntersectsBound(p, r.bound)
+	}
+	return util.PolygonsIntersect(orb.MultiPolygon{p}, r.polygon)
+}
+
+// 车道，r不为nil时返回中心线与范围相交的车道
+// Lanes, only lanes whose center line intersects the region when r is not nil
+func (g *mapGeometry) lanes(typ LaneType, r *region) []*geoLane {
+	if r == nil {
+		return lo.Filter(g.Lanes, func(l *geoLane, _ int) bool {
+			return l.matchParent(typ)
+		})
+	}
+	lanes := make([]*geoLane, 0)
+	g.laneIndex.Search(r.bound.Min, r.bound.Max, func(_, _ [2]float64, l *geoLane) bool {
+		if l.matchParent(typ) && r.intersectsLine(l.Line) {
+			lanes = append(lanes, l)
+		}
+		return true
+	})
+	sort.Slice(lanes, func(i, j int) bool { return lanes[i].order < lanes[j].order })
+	return lanes
+}
+
+// AOI，r不为nil时返回与范围相交的AOI AOIs, only AOIs intersecting the region when r is not nil
+func (g *mapGeometry) aois(r *region) []*geoAoi {
+	if r == nil {
+		return g.Aois
+	}
+	aois := make([]*geoAoi, 0)
+	g.aoiIndex.Search(r.bound.Min, r.bound.Max, func(_, _ [2]float64, a *geoAoi) bool {
+		if r.intersectsPolygon(a.Polygon) {
+			aois = append(aois, a)
+		}
+		return true
+	})
+	sort.Slice(aois, func(i, j int) bool { return aois[i].order < aois[j].order })
+	return aois
+}
+
+// 道路最靠外的行车道（max_speed >= vMin），没有时返回nil
+// The outermost driving lane (max_speed >= vMin) of the road, nil if there is none
+func (g *mapGeometry) outermostRoadLane(r *MapRoad, vMin float64) *geoLane {
+	// 找到合适的driving lane（最靠外的）
+	for i := len(r.LaneIDs) - 1; i >= 0; i-- {
+		lane := g.laneByID[r.LaneIDs[i]]
+		if lane != nil && !lane.isJunctionLane() && lane.Type == 1 && lane.MaxSpeed >= vMin {
+			return lane
+		}
+	}
+	return nil
+}
+
+// 每条道路最靠外的行车道（max_speed >= vMin）
+// The outermost driving lane (max_speed >= vMin) of each road
+func (g *mapGeometry) outermostRoadLanes(vMin float64) []geoRoadLane {
+	roadLanes := make([]geoRoadLane, 0)
+	for _, r := range g.Roads {
+		if lane := g.outermostRoadLane(r, vMin); lane != nil {
+			roadLanes = append(roadLanes, geoRoadLane{Road: r, Lane: lane})
+		}
+	}
+	return roadLanes
+}
+
+// 最靠外的行车道与范围相交的道路，先通过车道的空间索引找到候选道路
+// Roads whose outermost driving lane intersects the region, the candidates are found by the lane index first
+func (g *mapGeometry) outermostRoadLanesIn(vMin float64, r *region) []geoRoadLane {
+	roadLanes := make([]geoRoadLane, 0)
+	visited := make(map[int32]bool)
+	for _, l := range g.lanes(RoadLane, r) {
+		if visited[l.ParentID] {
+			continue
+		}
+		visited[l.ParentID] = true
+		road := g.roadByID[l.ParentID]
+		if road == nil {
+			continue
+		}
+		if lane := g.outermostRoadLane(road, vMin); lane != nil && (lane == l || r.intersectsLine(lane.Line)) {
+			roadLanes = append(roadLanes, geoRoadLane{Road: road, Lane: lane})
+		}
+	}
+	return roadLanes
+}
+
+// 车道与范围相交的道路，r为nil时不筛选 Roads whose lane intersects the region, not filtered if r is nil
+func roadLanesIn(roadLanes []geoRoadLane, r *region) []geoRoadLane {
+	if r == nil {
+		return roadLanes
+	}
+	return lo.Filter(roadLanes, func(rl geoRoadLane, _ int) bool {
+		return r.intersectsLine(rl.Lane.Line)
+	})
+}
+
+// 地图几何缓存统计 Map geometry cache statistics
+type MapCacheStats struct {
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	HitRatio  float64 `json:"hit_ratio"`
+	Evictions int64   `json:"evictions"` // 因容量或过期淘汰 evicted by capacity or TTL
+	Maps      int     `json:"maps"`
+	Nodes     int     `json:"nodes"`
+	MaxNodes  int     `json:"max_nodes"`
+}
+
+type mapCacheEntry struct {
+	mapPath  string
+	geometry *mapGeometry
+	expireAt time.Time
+}
+
+// 按"db.collection"缓存地图几何的LRU，容量按节点总数计
+// LRU of the map geometry keyed by "db.collection", the capacity is counted in nodes
+type mapCache struct {
+	ttl      time.Duration
+	maxNodes int
+	group    singleflight.Group
+
+	mu        sync.Mutex
+	ll        *list.List // 最近使用的在前 most recently used first
+	items     map[string]*list.Element
+	nodes     int
+	hits      int64
+	misses    int64
+	evictions int64
+	// 每个地图的失效次数，加载开始后失效的结果不再缓存
+	// Invalidation count of each map, a load that started before an invalidation is not cached
+	generations map[string]uint64
+}
+
+// 加载地图几何的超时，与请求的取消无关 Timeout of loading a map geometry, independent of the request cancellation
+const mapLoadTimeout = 2 * time.Minute
+
+func newMapCache(ttl time.Duration, maxNodes int) *mapCache {
+	return &mapCache{
+		ttl:         ttl,
+		maxNodes:    maxNodes,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+		generations: make(map[string]uint64),
+	}
+}
+
+var mapGeometries = newMapCache(30*time.Minute, 20000000)
+
+func initMapCache(ttl time.Duration, maxNodes int) {
+	mapGeometries = newMapCache(ttl, maxNodes)
+}
+
+func (c *mapCache) lookup(mapPath string) *mapGeometry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[mapPath]; ok {
+		e := el.Value.(*mapCacheEntry)
+		if time.Now().Before(e.expireAt) {
+			c.ll.MoveToFront(el)
+			c.hits++
+			return e.geometry
+		}
+		c.removeElement(el)
+		c.evictions++
+	}
+	c.misses++
+	return nil
+}
+
+// 获取地图几何，未命中时从storage加载，同一地图的并发加载只执行一次
+// Get the map geometry, load it from the storage on miss, concurrent loads of the same map run once
+func (c *mapCache) get(ctx context.Context, mapPath string) (*mapGeometry, error) {
+	if g := c.lookup(mapPath); g != nil {
+		return g, nil
+	}
+	ch := c.group.DoChan(mapPath, func() (any, error) {
+		// 加载被多个请求共享，不随第一个请求取消 the load is shared by requests and not canceled with the first one
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mapLoadTimeout)
+		defer cancel()
+		generation := c.generation(mapPath)
+		g, err := loadMapGeometry(loadCtx, mapPath)
+		if err != nil {
+			return nil, err
+		}
+		c.add(mapPath, g, generation)
+		return g, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*mapGeometry), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *mapCache) generation(mapPath string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generations[mapPath]
+}
+
+// 缓存加载结果，加载期间地图已失效时不缓存
+// Cache the loaded geometry, unless the map was invalidated during the load
+func (c *mapCache) add(mapPath string, g *mapGeometry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generations[mapPath] != generation {
+		return
+	}
+	if el, ok := c.items[mapPath]; ok {
+		c.removeElement(el)
+	}
+	if g.nodes > c.maxNodes {
+		// 单个地图超过容量，不缓存 a single map larger than the capacity is not cached
+		return
+	}
+	c.items[mapPath] = c.ll.PushFront(&mapCacheEntry{
+		mapPath:  mapPath,
+		geometry: g,
+		expireAt: time.Now().Add(c.ttl),
+	})
+	c.nodes += g.nodes
+	for c.nodes > c.maxNodes {
+		c.removeElement(c.ll.Back())
+		c.evictions++
+	}
+}
+
+func (c *mapCache) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*mapCacheEntry)
+	delete(c.items, e.mapPath)
+	c.nodes -= e.geometry.nodes
+}
+
+func (c *mapCache) invalidate(mapPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[mapPath]; ok {
+		c.removeElement(el)
+	}
+	c.generations[mapPath]++
+	c.group.Forget(mapPath)
+}
+
+func (c *mapCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.ll.Len() > 0 {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *mapCache) stats() MapCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := MapCacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Maps:      c.ll.Len(),
+		Nodes:     c.nodes,
+		MaxNodes:  c.maxNodes,
+	}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRatio = float64(s.Hits) / float64(total)
+	}
+	return s
+}
+
+// 使某个地图（"db.collection"）的缓存失效，地图数据更新后调用
+// Invalidate the cached geometry of a map ("db.collection"), call it after the map is updated
+func InvalidateMapCache(mapPath string) {
+	mapGeometries.invalidate(mapPath)
+}
+
+func MapCacheStatistics() MapCacheStats {
+	return mapGeometries.stats()
+}
+
+// @Summary Map geometry cache statistics
+// @Description Requires an admin.
+// @Produce application/json
+// @Success 200 object util.Response{data=MapCacheStats} "successful operation"
+// @Router /simple/map-cache [get]
+func GetMapCacheStats(c *gin.Context) {
+	c.JSON(200, util.NewResponse(MapCacheStatistics()))
+}
+
+// @Summary Drop a map from the map geometry cache
+// @Description Requires an admin. Call it after the map is updated, the map is reloaded by the next request.
+// @Produce application/json
+// @Param map path string true "Map Path (db.collection)"
+// @Success 200 object util.Response{data=MapCacheStats} "successful operation"
+// @Router /simple/map-cache/{map} [delete]
+func DeleteMapCache(c *gin.Context) {
+	mapPath := c.Param("map")
+	if _, _, err := splitMapPath(mapPath); err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	InvalidateMapCache(mapPath)
+	c.JSON(200, util.NewResponse(MapCacheStatistics()))
+}
+
+// 微观区域的经纬度范围 Longitude/latitude bound of the microscopic area
+func metaBound(meta *Metadata) orb.Bound {
+	return orb.Bound{
+		Min: orb.Point{meta.MinLng, meta.MinLat},
+		Max: orb.Point{meta.MaxLng, meta.MaxLat},
+	}
+}
@@ -0,0 +1,109 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// 在release关闭前阻塞加载的地图存储 Map store blocking the load until release is closed
+type blockingMapStore struct {
+	started chan struct{}
+	release chan struct{}
+	loads   int
+}
+
+func newBlockingMapStore() *blockingMapStore {
+	return &blockingMapStore{started: make(chan struct{}, 8), release: make(chan struct{})}
+}
+
+func (s *blockingMapStore) List(context.Context) ([]string, error) { return nil, nil }
+
+func (s *blockingMapStore) Header(ctx context.Context, _ string) (*MapHeader, error) {
+	s.loads++
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+		return &MapHeader{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *blockingMapStore) Lanes(context.Context, string, LaneFilter) ([]*MapLane, error) {
+	return nil, nil
+}
+
+func (s *blockingMapStore) Roads(context.Context, string) ([]*MapRoad, error) { return nil, nil }
+
+func (s *blockingMapStore) Junctions(context.Context, string) ([]*MapJunction, error) {
+	return nil, nil
+}
+
+func (s *blockingMapStore) Aois(context.Context, string, *XYBox) ([]*MapAoi, error) {
+	return nil, nil
+}
+
+func useMapStore(t *testing.T, m MapStore) {
+	old := storage
+	storage = &Storage{Map: m}
+	t.Cleanup(func() { storage = old })
+}
+
+func TestMapCacheLoadOutlivesCanceledCaller(t *testing.T) {
+	m := newBlockingMapStore()
+	useMapStore(t, m)
+	c := newMapCache(time.Minute, 1000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.get(ctx, "db.map")
+		first <- err
+	}()
+	<-m.started
+	second := make(chan error, 1)
+	go func() {
+		_, err := c.get(context.Background(), "db.map")
+		second <- err
+	}()
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled for the canceled caller but got %v", err)
+	}
+	close(m.release)
+	if err := <-second; err != nil {
+		t.Fatalf("the shared load should not be canceled with the first caller: %v", err)
+	}
+	if stats := c.stats(); stats.Maps != 1 || m.loads != 1 {
+		t.Fatalf("want one load and one cached map but got %d loads, %+v", m.loads, stats)
+	}
+}
+
+func TestMapCacheInvalidateDuringLoad(t *testing.T) {
+	m := newBlockingMapStore()
+	useMapStore(t, m)
+	c := newMapCache(time.Minute, 1000)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.get(context.Background(), "db.map")
+		done <- err
+	}()
+	<-m.started
+	c.invalidate("db.map")
+	close(m.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.stats(); stats.Maps != 0 {
+		t.Fatalf("a load started before the invalidation should not be cached: %+v", stats)
+	}
+	if _, err := c.get(context.Background(), "db.map"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.stats(); stats.Maps != 1 || m.loads != 2 {
+		t.Fatalf("want the map reloaded and cached but got %d loads, %+v", m.loads, stats)
+	}
+}
@@ -0,0 +1,309 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"git.fiblab.net/sim/backend/auth"
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"github.com/samber/lo"
+)
+
+// 不依赖模拟的地图接口，用于在运行模拟前预览地图
+// Map routes without a simulation, used to preview a map before any run exists
+
+// 地图概要 Summary of a map
+type MapInfo struct {
+	Map        string `json:"map"`        // 地图路径 Map path ("db.collection")
+	Name       string `json:"name"`       // header中的地图名 Map name in the header
+	Projection string `json:"projection"` // 投影 Projection (proj4 string)
+
+	// 车道与AOI的经纬度范围 Longitude/latitude bound of the lanes and AOIs
+
+	MinLng float64 `json:"min_lng"`
+	MinLat float64 `json:"min_lat"`
+	MaxLng float64 `json:"max_lng"`
+	MaxLat float64 `json:"max_lat"`
+
+	// 数量 Counts
+
+	Lanes     int `json:"lanes"`
+	Roads     int `json:"roads"`
+	Junctions int `json:"junctions"`
+	Aois      int `json:"aois"`
+}
+
+type mapUri struct {
+	Map string `uri:"map" binding:"required"`
+}
+
+type MapLaneParam struct {
+	Type *string `form:"type"` // all（默认）、junction或road all (default), junction or road
+}
+
+var laneTypes = map[string]LaneType{
+	"all":      AllLane,
+	"junction": JunctionLane,
+	"road":     RoadLane,
+}
+
+func (p *MapLaneParam) Check() error {
+	if p.Type != nil {
+		if _, ok := laneTypes[*p.Type]; !ok {
+			return errors.New("type should be all, junction or road")
+		}
+	}
+	return nil
+}
+
+func (p *MapLaneParam) laneType() LaneType {
+	if p.Type == nil {
+		return AllLane
+	}
+	return laneTypes[*p.Type]
+}
+
+const mapListTTL = time.Minute
+
+// 地图列表的缓存，在MongoDB中列出地图需要逐个查询集合 Cache of the map list, listing the maps in MongoDB queries every collection
+var mapList = cache.New(mapListTTL, 2*mapListTTL)
+
+// 配置的数据库或MAP_DIR中的地图（经过缓存） The (cached) maps in the configured database or MAP_DIR
+func listMaps(ctx context.Context) ([]string, error) {
+	if v, found := mapList.Get(""); found {
+		return v.([]string), nil
+	}
+	maps, err := storage.Map.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mapList.SetDefault("", maps)
+	return maps, nil
+}
+
+// 调用方可见的地图：管理员可见所有地图，其他调用方可见其可访问的模拟使用的地图，已认证的调用方还可见未被模拟使用的地图
+// Maps visible to the caller: admins see all maps, the others see the maps used by the simulations they can access,
+// and authenticated callers also see the maps not used by any simulation
+func mapsVisibleTo(ctx context.Context, p *auth.Principal) ([]string, error) {
+	maps, err := listMaps(ctx)
+	if err != nil || p != nil && p.Admin {
+		return maps, err
+	}
+	sims, err := QueryMetadata(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	used, visible := make(map[string]bool), make(map[string]bool)
+	for _, m := range sims {
+		used[m.Map] = true
+		if m.visibleTo(p) {
+			visible[m.Map] = true
+		}
+	}
+	return lo.Filter(maps, func(m string, _ int) bool { return visible[m] || p != nil && !used[m] }), nil
+}
+
+// 检查路径参数map是列出的地图且调用方可见，不存在时为404，匿名调用方为401，其他调用方为403
+// Check that the path parameter map is a listed map visible to the caller, 404 if missing, 401 for anonymous callers
+// and 403 for the others
+func RequireMapAccess(c *gin.Context) {
+	u := &mapUri{}
+	if err := c.ShouldBindUri(u); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return
+	}
+	if _, _, err := splitMapPath(u.Map); err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	maps, err := listMaps(ctx)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	// 只能访问列出的地图，即配置的数据库中的地图 only the listed maps, i.e. the maps in the configured database
+	if !lo.Contains(maps, u.Map) {
+		util.AbortWithError(c, newMapNotFound(u.Map))
+		return
+	}
+	p := auth.FromContext(c)
+	visible, err := mapsVisibleTo(ctx, p)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	if lo.Contains(visible, u.Map) {
+		return
+	}
+	if p == nil {
+		c.Header("WWW-Authenticate", "Bearer")
+		util.AbortWithError(c, util.NewError(util.CodeUnauthorized, "authentication required").With("map", u.Map))
+	} else {
+		util.AbortWithError(c, util.NewError(util.CodeForbidden, "no access to the map").With("map", u.Map))
+	}
+}
+
+// 获取路径参数map的地图几何（经过缓存），需在RequireMapAccess之后调用，失败时中止请求
+// Get the (cached) map geometry of the path parameter map, should be called after RequireMapAccess,
+// the request is aborted on failure
+func mapGeometryOf(c *gin.Context) (mapPath string, g *mapGeometry, ok bool) {
+	u := &mapUri{}
+	if err := c.ShouldBindUri(u); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return
+	}
+	g, err := mapGeometries.get(c.Request.Context(), u.Map)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	return u.Map, g, true
+}
+
+// @Summary List maps
+// @Description Maps ("db.collection") with a header document in the configured MongoDB database, or the map files in MAP_DIR.
+// @Description Only the maps used by the simulations visible to the caller are listed, and for authenticated callers also the maps not used by any simulation.
+// @Produce application/json
+// @Success 200 object util.Response{data=[]string} "successful operation"
+// @Router /simple/maps [get]
+func GetMaps(c *gin.Context) {
+	maps, err := mapsVisibleTo(c.Request.Context(), auth.FromContext(c))
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	c.JSON(200, util.NewResponse(maps))
+}
+
+// @Summary Get the header of a map
+// @Description The projection, the bound of the lanes and AOIs and the counts of lanes, roads, junctions and AOIs.
+// @Produce application/json
+// @Param map path string true "Map Path (db.collection)"
+// @Success 200 object util.Response{data=MapInfo} "successful operation"
+// @Router /simple/maps/{map} [get]
+func GetMapByPath(c *gin.Context) {
+	mapPath, g, ok := mapGeometryOf(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, util.NewResponse(&MapInfo{
+		Map:        mapPath,
+		Name:       g.Name,
+		Projection: g.Projection,
+		MinLng:     g.Bound.Min.Lon(),
+		MinLat:     g.Bound.Min.Lat(),
+		MaxLng:     g.Bound.Max.Lon(),
+		MaxLat:     g.Bound.Max.Lat(),
+		Lanes:      len(g.Lanes),
+		Roads:      len(g.Roads),
+		Junctions:  len(g.Junctions),
+		Aois:       len(g.Aois),
+	}))
+}
+
+// @Summary Load lane geojson of a map
+// @Description Without a bbox all lanes are returned, otherwise the lanes intersecting the bbox.
+// @Description POST a GeoJSON Polygon/MultiPolygon instead of the bbox to select the lanes intersecting the polygon.
+// @Accept application/json
+// @Produce application/json
+// @Param map path string true "Map Path (db.collection)"
+// @Param type query string false "all (default), junction or road"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
+// @Param fields query string false "comma separated property sets: lane, topology, road, junction or all"
+// @Success 200
+// @Router /simple/maps/{map}/lanes [get]
+// @Router /simple/maps/{map}/lanes [post]
+func GetMapLanes(c *gin.Context) {
+	// POST的请求体为GeoJSON，只从query中绑定参数 the body of POST is GeoJSON, bind the parameters from the query only
+	p := &MapLaneParam{}
+	if err := c.ShouldBindQuery(p); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return
+	}
+	if err := p.Check(); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return
+	}
+	r, clipping, ok := requestRegion(c)
+	if !ok {
+		return
+	}
+	sets, ok := requestFields(c)
+	if !ok {
+		return
+	}
+	_, g, ok := mapGeometryOf(c)
+	if !ok {
+		return
+	}
+	geojsons := g.laneFeatures(g.lanes(p.laneType(), r), clipRegion(r, clipping), sets)
+	c.JSON(200, util.NewResponse(geojsons))
+}
+
+// @Summary Load road geojson of a map
+// @Description Every road is drawn as its outermost driving lane with the road id, as /simple/roadlane without the speed threshold.
+// @Description Without a bbox all roads are returned, otherwise the roads whose lane intersects the bbox.
+// @Description POST a GeoJSON Polygon/MultiPolygon instead of the bbox to select by the polygon.
+// @Accept application/json
+// @Produce application/json
+// @Param map path string true "Map Path (db.collection)"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
+// @Param fields query string false "comma separated property sets: lane, topology, road, junction or all"
+// @Success 200
+// @Router /simple/maps/{map}/roads [get]
+// @Router /simple/maps/{map}/roads [post]
+func GetMapRoads(c *gin.Context) {
+	r, clipping, ok := requestRegion(c)
+	if !ok {
+		return
+	}
+	sets, ok := requestFields(c)
+	if !ok {
+		return
+	}
+	_, g, ok := mapGeometryOf(c)
+	if !ok {
+		return
+	}
+	geojsons := g.roadFeatures(roadLanesIn(g.outermostRoadLanes(0), r), clipRegion(r, clipping), sets)
+	c.JSON(200, util.NewResponse(geojsons))
+}
+
+// @Summary Load AOI geojson of a map
+// @Description Without a bbox all AOIs are returned, otherwise the AOIs intersecting the bbox.
+// @Description POST a GeoJSON Polygon/MultiPolygon instead of the bbox to select by the polygon.
+// @Accept application/json
+// @Produce application/json
+// @Param map path string true "Map Path (db.collection)"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Param lat1 query number false "min latitude for filtering"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param clip query bool false "cut the geometries at the bbox or the POSTed polygon"
+// @Success 200
+// @Router /simple/maps/{map}/aois [get]
+// @Router /simple/maps/{map}/aois [post]
+func GetMapAois(c *gin.Context) {
+	r, clipping, ok := requestRegion(c)
+	if !ok {
+		return
+	}
+	_, g, ok := mapGeometryOf(c)
+	if !ok {
+		return
+	}
+	geojsons := aoiFeatures(g.aois(r), clipRegion(r, clipping))
+	c.JSON(200, util.NewResponse(geojsons))
+}
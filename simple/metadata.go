@@ -2,12 +2,13 @@ package simple
 
 import (
 	"context"
-	"errors"
+	"fmt"
 
+	"git.fiblab.net/sim/backend/auth"
 	"git.fiblab.net/sim/backend/util"
-	"git.fiblab.net/utils/lens"
 	"git.fiblab.net/utils/pgxtool"
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 )
 
 type Metadata struct {
@@ -33,54 +34,64 @@ type Metadata struct {
 	// 版本 Version
 
 	Version int `json:"-" db:"version"`
+
+	// 访问控制，均为NULL时公开 Access control, public if both are NULL
+
+	Owner     *string `json:"owner" db:"owner"`      // 所有者，与调用方的Subject对应 Owner, matched with the Subject of the caller
+	GroupName *string `json:"group" db:"group_name"` // 可访问的组 Group with access
+}
+
+// 调用方能否访问该模拟 Whether the caller can access the simulation
+func (m *Metadata) visibleTo(p *auth.Principal) bool {
+	return p.CanAccess(lo.FromPtr(m.Owner), lo.FromPtr(m.GroupName))
 }
 
 var metaTool = pgxtool.New(&Metadata{})
 
-func QueryMetadata(name *string) ([]*Metadata, error) {
-	var where string
-	var args []any
-	if name == nil {
-		where = ""
-	} else {
-		where = "NAME=$1"
-		args = []any{*name}
-	}
-	rows, err := lens.DefaultPg().Query(
-		context.Background(),
-		metaTool.BuildSelectSQL("meta_simple", where, nil),
-		args...,
-	)
+func QueryMetadata(ctx context.Context, name *string) ([]*Metadata, error) {
+	all, err := storage.Meta.QueryMetadata(ctx, name)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	all := make([]*Metadata, 0)
-	for rows.Next() {
-		one := &Metadata{}
-		if err := metaTool.Scan(rows, one); err != nil {
-			return nil, err
-		}
-		all = append(all, one)
+	if name != nil && len(all) > 1 {
+		return nil, fmt.Errorf("duplicate records of simulation %s", *name)
 	}
-	if err = rows.Err(); err != nil {
+	return all, nil
+}
+
+// 按名称查询模拟元数据，不存在时返回simulation_not_found Query the simulation metadata by name, simulation_not_found if missing
+func lookupSim(ctx context.Context, name string) (*Metadata, error) {
+	metas, err := QueryMetadata(ctx, &name)
+	if err != nil {
 		return nil, err
 	}
-	if name != nil && len(all) > 1 {
-		return nil, errors.New("duplicate records")
+	if len(metas) == 0 {
+		return nil, util.ErrSimulationNotFound(name)
 	}
-	return all, nil
+	return metas[0], nil
+}
+
+// 查询模拟元数据，失败时中止请求 Query the simulation metadata, the request is aborted on failure
+func findSim(c *gin.Context, name string) (*Metadata, bool) {
+	meta, err := lookupSim(c.Request.Context(), name)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return nil, false
+	}
+	return meta, true
 }
 
 // @Summary Get All Simulation Metadata
+// @Description Only the simulations visible to the caller are listed.
 // @Produce application/json
 // @Success 200 object util.Response{data=[]Metadata} "successful operation"
 // @Router /simple/sims/ [get]
 func GetAllSim(c *gin.Context) {
-	if res, err := QueryMetadata(nil); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+	if res, err := QueryMetadata(c.Request.Context(), nil); err != nil {
+		util.AbortWithError(c, err)
 	} else {
-		c.JSON(200, util.NewResponse(res))
+		p := auth.FromContext(c)
+		c.JSON(200, util.NewResponse(lo.Filter(res, func(m *Metadata, _ int) bool { return m.visibleTo(p) })))
 	}
 }
 
@@ -90,15 +101,42 @@ func GetAllSim(c *gin.Context) {
 // @Success 200 object util.Response{data=[]Metadata} "successful operation"
 // @Router /simple/sims/{simname} [get]
 func GetSimByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
-	if res, err := QueryMetadata(&u.Name); err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
-	} else if len(res) == 0 {
-		c.JSON(404, util.NewErrorResponse(errors.New("not found")))
+	if meta, ok := findSim(c, u.Name); ok {
+		c.JSON(200, util.NewResponse([]*Metadata{meta}))
+	}
+}
+
+// 调用方无权访问模拟时中止请求，匿名调用方为401，其他调用方为403
+// Abort the request if the caller cannot access the simulation, 401 for anonymous callers and 403 for the others
+func checkSimAccess(c *gin.Context, m *Metadata) bool {
+	p := auth.FromContext(c)
+	if m.visibleTo(p) {
+		return true
+	}
+	if p == nil {
+		c.Header("WWW-Authenticate", "Bearer")
+		util.AbortWithError(c, util.NewError(util.CodeUnauthorized, "authentication required").With("simulation", m.Name))
 	} else {
-		c.JSON(200, util.NewResponse(res))
+		util.AbortWithError(c, util.NewError(util.CodeForbidden, "no access to the simulation").With("simulation", m.Name))
+	}
+	return false
+}
+
+// 检查调用方能否访问路径参数name对应的模拟，模拟不存在时交给后续处理函数返回404
+// Check whether the caller can access the simulation of the path parameter name,
+// missing simulations are left to the handlers to return 404
+func RequireSimAccess(c *gin.Context) {
+	name := c.Param("name")
+	res, err := QueryMetadata(c.Request.Context(), &name)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	if len(res) > 0 {
+		checkSimAccess(c, res[0])
 	}
 }
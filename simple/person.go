@@ -1,6 +1,8 @@
 package simple
 
 import (
+	"context"
+
 	"git.fiblab.net/sim/backend/util"
 	"git.fiblab.net/utils/lens"
 	"git.fiblab.net/utils/pgxtool"
@@ -33,8 +35,36 @@ var (
 	personTool = pgxtool.New(&Person{})
 )
 
+func queryPeople(ctx context.Context, t RecordTables, begin, end, interval int, b BBox) ([]*Person, error) {
+	all, err := storage.Trajectory.People(ctx, t, StepQuery{begin, end, 1, interval}, b)
+	if err != nil {
+		return nil, err
+	}
+	for _, one := range all {
+		one.Direction = util.ToFixed(one.Direction, 2)
+		one.Lng = util.ToFixed(one.Lng, 8)
+		one.Lat = util.ToFixed(one.Lat, 8)
+	}
+	return all, nil
+}
+
+func peopleToColumnar(all []*Person) *util.Columnar {
+	t := util.NewColumnar(len(all))
+	t.AddInt32("step", func(i int) int32 { return int32(all[i].Step) })
+	t.AddInt32("id", func(i int) int32 { return int32(all[i].Id) })
+	t.AddInt32("parentId", func(i int) int32 { return int32(all[i].ParentId) })
+	t.AddFloat32("direction", func(i int) float32 { return float32(all[i].Direction) })
+	t.AddFloat64("lng", func(i int) float64 { return all[i].Lng })
+	t.AddFloat64("lat", func(i int) float64 { return all[i].Lat })
+	t.AddFloat32("z", func(i int) float32 { return float32(all[i].Z) })
+	t.AddFloat32("v", func(i int) float32 { return float32(all[i].V) })
+	t.AddString("model", func(i int) string { return all[i].Model })
+	return t
+}
+
 // @Summary Get Pedestrians
 // @Produce application/json
+// @Produce application/vnd.moss.columnar
 // @Param tablename path string true "Simulation Name"
 // @Param begin query number true "the start step of the data"
 // @Param end query number true "Get the end step of the data (not included)"
@@ -43,32 +73,33 @@ var (
 // @Param lng1 query number true "min longitude for filtering"
 // @Param lng2 query number true "max longitude for filtering"
 // @Param interval query number false "Get the interval of the data (default is 1, return results step=begin,begin+1*interval,begin+2*interval...)"
+// @Param format query string false "Response format: json (default) or columnar (binary columnar layout, see util/columnar.go)"
 // @Success 200 object util.Response{data=[]Person} "北京返回值"
 // @Router /simple/people/{tablename} [get]
 func GetPeopleByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
-	s := lens.ValidateParam[lens.StepCoordinate](c)
+	s := util.ValidateParam[lens.StepCoordinate](c)
 	if s == nil {
 		return
 	}
-
-	all, err := lens.QueryPgTableWithStep[Person](
-		personTool, u.Name+"_s_people",
-		*s.Begin, *s.End, 1, 0, *s.Interval,
-		"LAT>=$1 AND LAT<$2 AND LNG>=$3 AND LNG<$4",
-		[]any{*s.Lat1, *s.Lat2, *s.Lng1, *s.Lng2},
-	)
+	meta, ok := simMetadata(c, u.Name)
+	if !ok {
+		return
+	}
+	all, err := queryPeople(c.Request.Context(), meta.tables(), *s.Begin, *s.End, *s.Interval, bboxOf(s))
+	if util.ResponseEmptyIfTableNotFound(c, all, err) {
+		return
+	}
 	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+		util.AbortWithError(c, err)
 		return
 	}
-	for _, one := range all {
-		one.Direction = util.ToFixed(one.Direction, 2)
-		one.Lng = util.ToFixed(one.Lng, 8)
-		one.Lat = util.ToFixed(one.Lat, 8)
+	if util.WantColumnar(c) {
+		util.ResponseColumnar(c, peopleToColumnar(all))
+		return
 	}
 	c.JSON(200, util.NewResponse(all))
 }
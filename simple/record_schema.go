@@ -0,0 +1,140 @@
+package simple
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// DBRecorder输出的表 Tables in the DBRecorder output
+type recordKind int
+
+const (
+	recordCars recordKind = iota
+	recordPeople
+	recordTrafficLights
+	recordRoadStatus
+)
+
+// 表名后缀 Table name suffixes
+var recordSuffixes = map[recordKind]string{
+	recordCars:          "_s_cars",
+	recordPeople:        "_s_people",
+	recordTrafficLights: "_s_traffic_light",
+	recordRoadStatus:    "_s_road",
+}
+
+// 返回值结构体的列，即各版本统一后的列 Columns of the result struct, i.e. the unified columns of all versions
+func (k recordKind) columns() []string {
+	switch k {
+	case recordCars:
+		return carV2Tool.ColumnNames
+	case recordPeople:
+		return personTool.ColumnNames
+	case recordTrafficLights:
+		return tlTool.ColumnNames
+	default:
+		return roadStatusTool.ColumnNames
+	}
+}
+
+// 一个DBRecorder版本中各表的列映射：统一的列 -> 该版本表中的SQL表达式，未列出的列在表中同名
+// Column mappings of the tables in a DBRecorder version: unified column -> SQL expression in the table of
+// this version, unlisted columns have the same name in the table
+type recordSchema map[recordKind]map[string]string
+
+// 支持的DBRecorder版本，新增版本时在此声明与当前列不同的部分
+// Supported DBRecorder versions, declare the columns that differ from the unified ones when adding a version
+var recordSchemas = map[int]recordSchema{
+	// 车辆所在车道为lane_id，车辆没有高程、俯仰角、模型与乘客数，行人没有高程与模型
+	// The lane of a vehicle is lane_id, vehicles have no elevation, pitch, model or passengers,
+	// and people have no elevation or model
+	1: {
+		recordCars: {
+			"parent_id":      "lane_id",
+			"model":          "''",
+			"z":              "CAST(0 AS DOUBLE PRECISION)",
+			"pitch":          "CAST(0 AS DOUBLE PRECISION)",
+			"num_passengers": "0",
+		},
+		recordPeople: {
+			"z":     "CAST(0 AS DOUBLE PRECISION)",
+			"model": "''",
+		},
+	},
+	2: {},
+}
+
+// 升序排列的支持版本 Supported versions in ascending order
+func supportedVersions() []int {
+	versions := lo.Keys(recordSchemas)
+	sort.Ints(versions)
+	return versions
+}
+
+// 不支持的DBRecorder版本 Unsupported DBRecorder version
+func errUnsupportedVersion(version int) *util.Error {
+	versions := supportedVersions()
+	return util.NewError(util.CodeUnsupportedVersion, "unsupported version %d, supported versions are %v", version, versions).
+		With("version", version).
+		With("supportedVersions", versions)
+}
+
+// 一个模拟的DBRecorder表 DBRecorder tables of a simulation
+type RecordTables struct {
+	Name    string // 模拟名 Simulation name
+	Version int    // DBRecorder版本 DBRecorder version
+}
+
+func (m *Metadata) tables() RecordTables {
+	return RecordTables{Name: m.Name, Version: m.Version}
+}
+
+func (t RecordTables) check() error {
+	if _, ok := recordSchemas[t.Version]; !ok {
+		return errUnsupportedVersion(t.Version)
+	}
+	return nil
+}
+
+func (t RecordTables) table(kind recordKind) string {
+	return t.Name + recordSuffixes[kind]
+}
+
+// 用于FROM子句的表，列名与统一的列不同时为重命名列的子查询
+// Table for the FROM clause, a subquery renaming the columns if they differ from the unified ones
+func (t RecordTables) from(kind recordKind) (string, error) {
+	if err := t.check(); err != nil {
+		return "", err
+	}
+	mapping := recordSchemas[t.Version][kind]
+	if len(mapping) == 0 {
+		return t.table(kind), nil
+	}
+	columns := lo.Map(kind.columns(), func(col string, _ int) string {
+		if expr, ok := mapping[col]; ok {
+			return expr + " AS " + col
+		}
+		return col
+	})
+	return fmt.Sprintf("(SELECT %s FROM %s) AS %s", strings.Join(columns, ","), t.table(kind), t.table(kind)), nil
+}
+
+// 查询模拟元数据并确认DBRecorder版本受支持，失败时中止请求（不支持的版本为422）
+// Query the simulation metadata and check that the DBRecorder version is supported,
+// the request is aborted on failure (422 for unsupported versions)
+func simMetadata(c *gin.Context, name string) (meta *Metadata, ok bool) {
+	meta, ok = findSim(c, name)
+	if !ok {
+		return
+	}
+	if err := meta.tables().check(); err != nil {
+		util.AbortWithError(c, err)
+		return nil, false
+	}
+	return meta, true
+}
@@ -0,0 +1,274 @@
+package simple
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// 注册或更新模拟的请求体，更新时只修改非null的字段
+// Payload to register or update a simulation, only the non-null fields are modified on update
+type MetadataPayload struct {
+	Name               *string  `json:"name"`
+	Start              *int     `json:"start"`
+	Steps              *int     `json:"steps"`
+	Time               *float64 `json:"time"`
+	TotalAgents        *int     `json:"total_agents"`
+	Map                *string  `json:"map"` // "db.collection"
+	MinLng             *float64 `json:"min_lng"`
+	MinLat             *float64 `json:"min_lat"`
+	MaxLng             *float64 `json:"max_lng"`
+	MaxLat             *float64 `json:"max_lat"`
+	RoadStatusVMin     *float64 `json:"road_status_v_min"`
+	RoadStatusInterval *int     `json:"road_status_interval"`
+	Version            *int     `json:"version"`
+	// 空字符串表示清除 An empty string clears the field
+	Owner *string `json:"owner"`
+	Group *string `json:"group"`
+}
+
+// 新建模拟时的必需字段 Fields required to register a simulation
+func (p *MetadataPayload) missing() []string {
+	missing := make([]string, 0)
+	for key, v := range map[string]bool{
+		"name":         p.Name == nil,
+		"start":        p.Start == nil,
+		"steps":        p.Steps == nil,
+		"time":         p.Time == nil,
+		"total_agents": p.TotalAgents == nil,
+		"map":          p.Map == nil,
+		"min_lng":      p.MinLng == nil,
+		"min_lat":      p.MinLat == nil,
+		"max_lng":      p.MaxLng == nil,
+		"max_lat":      p.MaxLat == nil,
+		"version":      p.Version == nil,
+	} {
+		if v {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func (p *MetadataPayload) apply(m *Metadata) {
+	set := func(dst *float64, src *float64) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setInt := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	if p.Name != nil {
+		m.Name = *p.Name
+	}
+	if p.Map != nil {
+		m.Map = *p.Map
+	}
+	setInt(&m.Start, p.Start)
+	setInt(&m.Steps, p.Steps)
+	set(&m.Time, p.Time)
+	setInt(&m.TotalAgents, p.TotalAgents)
+	set(&m.MinLng, p.MinLng)
+	set(&m.MinLat, p.MinLat)
+	set(&m.MaxLng, p.MaxLng)
+	set(&m.MaxLat, p.MaxLat)
+	if p.RoadStatusVMin != nil {
+		m.RoadStatusVMin = p.RoadStatusVMin
+	}
+	if p.RoadStatusInterval != nil {
+		m.RoadStatusInterval = p.RoadStatusInterval
+	}
+	setInt(&m.Version, p.Version)
+	setString := func(dst **string, src *string) {
+		if src != nil {
+			*dst = src
+			if *src == "" {
+				*dst = nil
+			}
+		}
+	}
+	setString(&m.Owner, p.Owner)
+	setString(&m.GroupName, p.Group)
+}
+
+// 检查元数据（不含地图是否存在） Validate the metadata except whether the map exists
+func validateMetadata(m *Metadata) error {
+	errs := make([]string, 0)
+	if !util.NameChecker.MatchString(m.Name) {
+		errs = append(errs, fmt.Sprintf("%s is an invalid name", m.Name))
+	}
+	if m.Start < 0 {
+		errs = append(errs, "start should not be negative")
+	}
+	if m.Steps <= 0 {
+		errs = append(errs, "steps should be positive")
+	}
+	if m.Time <= 0 {
+		errs = append(errs, "time should be positive")
+	}
+	if m.TotalAgents < 0 {
+		errs = append(errs, "total_agents should not be negative")
+	}
+	if _, _, err := splitMapPath(m.Map); err != nil {
+		errs = append(errs, fmt.Sprintf("map should be \"db.collection\" but got %q", m.Map))
+	}
+	if m.MinLng >= m.MaxLng || m.MinLat >= m.MaxLat {
+		errs = append(errs, "min_lng/min_lat should be less than max_lng/max_lat")
+	}
+	if m.MinLng < -180 || m.MaxLng > 180 || m.MinLat < -90 || m.MaxLat > 90 {
+		errs = append(errs, "bbox should be in [-180, 180] x [-90, 90]")
+	}
+	if (m.RoadStatusVMin == nil) != (m.RoadStatusInterval == nil) {
+		errs = append(errs, "road_status_v_min and road_status_interval should be given together")
+	}
+	if m.RoadStatusInterval != nil && *m.RoadStatusInterval < 1 {
+		errs = append(errs, "road_status_interval should be positive")
+	}
+	if m.RoadStatusVMin != nil && *m.RoadStatusVMin < 0 {
+		errs = append(errs, "road_status_v_min should not be negative")
+	}
+	if !lo.Contains(supportedVersions(), m.Version) {
+		errs = append(errs, fmt.Sprintf("version should be one of %v", supportedVersions()))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// 检查元数据并确认地图存在，失败时填写HTTP返回值
+// Validate the metadata and check that the map exists, the HTTP response is written on failure
+func checkMetadata(c *gin.Context, m *Metadata) bool {
+	if err := validateMetadata(m); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return false
+	}
+	if _, err := storage.Map.Header(c.Request.Context(), m.Map); errors.Is(err, errMapNotFound) {
+		// 注册请求中的地图不存在属于请求错误 A missing map in the registration is a bad request
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err).With("map", m.Map))
+		return false
+	} else if err != nil {
+		util.AbortWithError(c, err)
+		return false
+	}
+	return true
+}
+
+func bindMetadataPayload(c *gin.Context) *MetadataPayload {
+	p := &MetadataPayload{}
+	if err := c.ShouldBindJSON(p); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return nil
+	}
+	return p
+}
+
+// @Summary Register a simulation
+// @Description Requires an admin. The map "db.collection" should exist.
+// @Accept application/json
+// @Produce application/json
+// @Param metadata body MetadataPayload true "Simulation Metadata"
+// @Success 201 object util.Response{data=Metadata} "successful operation"
+// @Router /simple/sims [post]
+func PostSim(c *gin.Context) {
+	p := bindMetadataPayload(c)
+	if p == nil {
+		return
+	}
+	if missing := p.missing(); len(missing) > 0 {
+		util.AbortWithError(c, util.NewError(util.CodeBadRequest, "missing fields: %s", strings.Join(missing, ", ")))
+		return
+	}
+	m := &Metadata{}
+	p.apply(m)
+	if !checkMetadata(c, m) {
+		return
+	}
+	if res, err := QueryMetadata(c.Request.Context(), &m.Name); err != nil {
+		util.AbortWithError(c, err)
+		return
+	} else if len(res) > 0 {
+		util.AbortWithError(c, util.NewError(util.CodeConflict, "simulation %s already exists", m.Name).With("simulation", m.Name))
+		return
+	}
+	// 并发注册同名模拟时由唯一索引拒绝 concurrent registrations of the same name are rejected by the unique index
+	if err := storage.Meta.CreateMetadata(c.Request.Context(), m); util.CheckIsUniqueViolation(err) {
+		util.AbortWithError(c, util.NewError(util.CodeConflict, "simulation %s already exists", m.Name).With("simulation", m.Name))
+		return
+	} else if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	c.JSON(201, util.NewResponse(m))
+}
+
+// @Summary Update a simulation
+// @Description Requires an admin. Only the given fields are modified, the name cannot be changed.
+// @Accept application/json
+// @Produce application/json
+// @Param simname path string true "Simulation Name"
+// @Param metadata body MetadataPayload true "Fields to modify"
+// @Success 200 object util.Response{data=Metadata} "successful operation"
+// @Router /simple/sims/{simname} [patch]
+func PatchSimByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	p := bindMetadataPayload(c)
+	if p == nil {
+		return
+	}
+	if p.Name != nil && *p.Name != u.Name {
+		util.AbortWithError(c, util.NewError(util.CodeBadRequest, "name cannot be changed"))
+		return
+	}
+	m, ok := findSim(c, u.Name)
+	if !ok {
+		return
+	}
+	p.apply(m)
+	if !checkMetadata(c, m) {
+		return
+	}
+	if err := storage.Meta.UpdateMetadata(c.Request.Context(), m); err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	intervalCache.Delete(m.Name)
+	c.JSON(200, util.NewResponse(m))
+}
+
+// @Summary Delete a simulation
+// @Description Requires an admin.
+// @Produce application/json
+// @Param simname path string true "Simulation Name"
+// @Param drop_tables query bool false "Also drop the _s_cars/_s_people/_s_traffic_light/_s_road tables (default is false)"
+// @Success 200 object util.Response{data=Metadata} "successful operation"
+// @Router /simple/sims/{simname} [delete]
+func DeleteSimByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	meta, ok := findSim(c, u.Name)
+	if !ok {
+		return
+	}
+	dropTables := c.Query("drop_tables") == "true"
+	if err := storage.Meta.DeleteMetadata(c.Request.Context(), u.Name, dropTables); err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	intervalCache.Delete(u.Name)
+	c.JSON(200, util.NewResponse(meta))
+}
@@ -1,8 +1,10 @@
 package simple
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"git.fiblab.net/sim/backend/util"
@@ -10,6 +12,8 @@ import (
 	"git.fiblab.net/utils/pgxtool"
 	"github.com/gin-gonic/gin"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type RoadStatus struct {
@@ -30,9 +34,61 @@ func (t *RoadStatus) Copy(newStep int) lens.IHasStep {
 
 var (
 	roadStatusTool = pgxtool.New(&RoadStatus{})
-	intervalCache  = cache.New(1*time.Minute, 2*time.Minute) // job -> road status interval
+	intervalCache  = cache.New(1*time.Minute, 2*time.Minute) // job -> roadStatusSource
+
+	// 计数器的值不可读，命中率由原子计数计算 values of the counters are not readable, the hit ratio uses atomic counts
+	intervalCacheHits, intervalCacheMisses atomic.Int64
+	intervalCacheRequests                  = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "moss_interval_cache_requests_total",
+		Help: "Lookups of the road status interval cache by result (hit or miss).",
+	}, []string{"result"})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "moss_interval_cache_hit_ratio",
+		Help: "Ratio of hits among the lookups of the road status interval cache since start.",
+	}, intervalCacheHitRatio)
 )
 
+func intervalCacheHitRatio() float64 {
+	hits, misses := float64(intervalCacheHits.Load()), float64(intervalCacheMisses.Load())
+	if hits+misses == 0 {
+		return 0
+	}
+	return hits / (hits + misses)
+}
+
+func initIntervalCache(ttl time.Duration) {
+	intervalCache = cache.New(ttl, 2*ttl)
+}
+
+// 路况表及其记录的step间隔 Road status table and the step interval of its records
+type roadStatusSource struct {
+	tables   RecordTables
+	interval int
+}
+
+// 获取路况表与记录的step间隔（经过缓存），失败时填写HTTP返回值
+// Get the road status table and the (cached) step interval of its records, the HTTP response is written on failure
+func roadStatusInterval(c *gin.Context, name string) (t RecordTables, interval int, ok bool) {
+	if i, found := intervalCache.Get(name); found {
+		intervalCacheHits.Add(1)
+		intervalCacheRequests.WithLabelValues("hit").Inc()
+		src := i.(roadStatusSource)
+		return src.tables, src.interval, true
+	}
+	intervalCacheMisses.Add(1)
+	intervalCacheRequests.WithLabelValues("miss").Inc()
+	meta, ok := roadStatusMeta(c, name)
+	if !ok {
+		return
+	}
+	return meta.tables(), *meta.RoadStatusInterval, true
+}
+
+// dataInterval: 路况记录的step间隔 step interval of the recorded road status
+func queryRoadStatus(ctx context.Context, t RecordTables, begin, end, dataInterval, outputInterval int) ([]*RoadStatus, error) {
+	return storage.Trajectory.RoadStatus(ctx, t, StepQuery{begin, end, dataInterval, outputInterval})
+}
+
 // @Summary Get Road Status
 // @Produce application/json
 // @Param tablename path string true "Simulation Name"
@@ -42,41 +98,24 @@ var (
 // @Success 200 object util.Response{data=[]RoadStatus} "successful operation"
 // @Router /simple/road-status/{tablename} [get]
 func GetRoadStatusByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
-	s := lens.ValidateParam[lens.Step](c)
+	s := util.ValidateParam[lens.Step](c)
 	if s == nil {
 		return
 	}
-	var interval int
-	if i, ok := intervalCache.Get(u.Name); ok {
-		interval = i.(int)
-	} else {
-		metas, err := QueryMetadata(&u.Name)
-		if err != nil {
-			c.JSON(500, util.NewErrorResponse(err))
-			return
-		} else if len(metas) == 0 {
-			c.JSON(404, util.NewErrorResponse(errors.New("no found")))
-			return
-		}
-		meta := metas[0]
-		if meta.RoadStatusInterval == nil {
-			c.JSON(400, util.NewErrorResponse(errors.New("no road status information")))
-			return
-		}
-		interval = *meta.RoadStatusInterval
-		intervalCache.Set(u.Name, interval, cache.DefaultExpiration)
+	t, interval, ok := roadStatusInterval(c, u.Name)
+	if !ok {
+		return
+	}
+	all, err := queryRoadStatus(c.Request.Context(), t, *s.Begin, *s.End, interval, *s.Interval)
+	if util.ResponseEmptyIfTableNotFound(c, all, err) {
+		return
 	}
-	all, err := lens.QueryPgTableWithStep[RoadStatus](
-		roadStatusTool, u.Name+"_s_road",
-		*s.Begin, *s.End, interval, 0, *s.Interval,
-		"", nil,
-	)
 	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+		util.AbortWithError(c, err)
 		return
 	}
 
@@ -89,6 +128,98 @@ type RoadStatusStat struct {
 	LevelCounts         []int   `json:"congestionLevelCounts"` // 拥堵比例（未归一化，按顺序从等级2->5 轻度拥堵/中度拥堵/重度拥堵/极端拥堵） Congestion level counts (not normalized, in order from level 2->5: mild/moderate/severe/extreme)
 }
 
+// 参数占位符：1为起始step，2为结束step；placeholder为"$"（PostgreSQL）或"?"（SQLite），from为RecordTables.from的表
+// Parameters: 1 for the begin step, 2 for the end step; placeholder is "$" (PostgreSQL) or "?" (SQLite),
+// from is the table given by RecordTables.from
+func roadStatusStatsSQL(from string, placeholder string) string {
+	return fmt.Sprintf(
+		"SELECT step, CAST(AVG(level) AS DOUBLE PRECISION), "+
+			"COUNT(CASE WHEN level=2 THEN 1 END), COUNT(CASE WHEN level=3 THEN 1 END), "+
+			"COUNT(CASE WHEN level=4 THEN 1 END), COUNT(CASE WHEN level=5 THEN 1 END) "+
+			"FROM %[1]s WHERE step >= %[2]s1 AND step < %[2]s2 GROUP BY step ORDER BY step",
+		from, placeholder,
+	)
+}
+
+// 逐行读取按step聚合的路况统计，rows与sql.Rows和pgx.Rows兼容
+// Read the road status statistics aggregated by step row by row, rows works with both sql.Rows and pgx.Rows
+func scanRoadStatusStats(rows interface {
+	Next() bool
+	Scan(dest ...any) error
+}, selector *roadStatusStatSelector) error {
+	for rows.Next() {
+		one := RoadStatusStat{LevelCounts: make([]int, 4)}
+		if err := rows.Scan(
+			&one.Step, &one.MeanCongestionLevel,
+			&one.LevelCounts[0], &one.LevelCounts[1], &one.LevelCounts[2], &one.LevelCounts[3],
+		); err != nil {
+			return err
+		}
+		if err := selector.push(one); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从按step升序到达的统计中流式选出输出step对应的统计，语义与selectSteps一致
+// Select the statistics of the output steps from statistics arriving in ascending step order, the same semantics as selectSteps
+type roadStatusStatSelector struct {
+	q    StepQuery
+	emit func(RoadStatusStat) error
+	next int             // 下一个输出step next output step
+	last *RoadStatusStat // 最近一个step的统计 statistics of the latest step
+}
+
+func newRoadStatusStatSelector(q StepQuery, emit func(RoadStatusStat) error) *roadStatusStatSelector {
+	return &roadStatusStatSelector{q: q, emit: emit, next: q.Begin}
+}
+
+// 查询时需要向前多读取的step数 Steps to look back when querying
+func (s *roadStatusStatSelector) lookBack() int {
+	if s.q.DataInterval > 1 {
+		return s.q.DataInterval
+	}
+	return 0
+}
+
+func (s *roadStatusStatSelector) push(one RoadStatusStat) error {
+	if s.q.DataInterval <= 1 {
+		if one.Step >= s.q.Begin && one.Step < s.q.End && (one.Step-s.q.Begin)%s.q.OutputInterval == 0 {
+			return s.emit(one)
+		}
+		return nil
+	}
+	if err := s.flush(one.Step); err != nil {
+		return err
+	}
+	s.last = &one
+	return nil
+}
+
+// 输出step<until的统计 Output the statistics of steps < until
+func (s *roadStatusStatSelector) flush(until int) error {
+	for ; s.next < until && s.next < s.q.End; s.next += s.q.OutputInterval {
+		if s.last == nil {
+			continue
+		}
+		one := *s.last
+		one.Step = s.next
+		if err := s.emit(one); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 所有统计到达后调用 Call after all statistics arrived
+func (s *roadStatusStatSelector) finish() error {
+	if s.q.DataInterval <= 1 {
+		return nil
+	}
+	return s.flush(s.q.End)
+}
+
 // @Summary Get Road Status Statistics
 // @Produce application/json
 // @Param tablename path string true "Simulation Name"
@@ -98,51 +229,49 @@ type RoadStatusStat struct {
 // @Success 200 object util.Response{data=[]RoadStatusStat} "successful operation"
 // @Router /simple/road-status-stat/{tablename} [get]
 func GetRoadStatusStatByName(c *gin.Context) {
-	// 查询与GetRoadStatusByName一致，在后端计算统计指标
+	// 查询与GetRoadStatusByName一致，在数据库中按step分组计算统计指标
+	// The query is the same as GetRoadStatusByName, the statistics are grouped by step in the database
 	// MeanCongestionLevel = sum(level)/len(level)
 	// LevelCounts = [count(level=2), count(level=3), count(level=4), count(level=5)]
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
 
-	s := lens.ValidateParam[lens.Step](c)
+	s := util.ValidateParam[lens.Step](c)
 	if s == nil {
 		return
 	}
 
-	var interval int
-	if i, ok := intervalCache.Get(u.Name); ok {
-		interval = i.(int)
-	} else {
-		metas, err := QueryMetadata(&u.Name)
-		if err != nil {
-			c.JSON(500, util.NewErrorResponse(err))
-			return
-		} else if len(metas) == 0 {
-			c.JSON(404, util.NewErrorResponse(errors.New("no found")))
-			return
-		}
-		meta := metas[0]
-		if meta.RoadStatusInterval == nil {
-			c.JSON(400, util.NewErrorResponse(errors.New("no road status information")))
-			return
-		}
-		interval = *meta.RoadStatusInterval
-		intervalCache.Set(u.Name, interval, cache.DefaultExpiration)
+	t, interval, ok := roadStatusInterval(c, u.Name)
+	if !ok {
+		return
 	}
 
-	all, err := lens.QueryPgTableWithStep[RoadStatus](
-		roadStatusTool, u.Name+"_s_road",
-		*s.Begin, *s.End, interval, 0, *s.Interval,
-		"", nil,
+	// 先收集所有统计再一次写入，出错时返回正确的状态码
+	// Collect all statistics before writing the response so that errors get a proper status code
+	stats := make([]RoadStatusStat, 0)
+	err := storage.Trajectory.RoadStatusStats(
+		c.Request.Context(), t, StepQuery{*s.Begin, *s.End, interval, *s.Interval},
+		func(one RoadStatusStat) error {
+			stats = append(stats, one)
+			return nil
+		},
 	)
+	if util.ResponseEmptyIfTableNotFound(c, []RoadStatusStat{}, err) {
+		return
+	}
 	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+		util.AbortWithError(c, err)
 		return
 	}
+	c.JSON(200, util.NewResponse(stats))
+}
 
-	// 计算统计指标
+// 按step分组计算统计指标 Compute the statistics grouped by step
+// MeanCongestionLevel = sum(level)/len(level)
+// LevelCounts = [count(level=2), count(level=3), count(level=4), count(level=5)]
+func roadStatusStats(all []*RoadStatus) []RoadStatusStat {
 	// 按照step分组
 	step2status := make(map[int][]*RoadStatus)
 	for _, v := range all {
@@ -170,5 +299,5 @@ func GetRoadStatusStatByName(c *gin.Context) {
 	sort.Slice(stat, func(i, j int) bool {
 		return stat[i].Step < stat[j].Step
 	})
-	c.JSON(200, util.NewResponse(stat))
+	return stat
 }
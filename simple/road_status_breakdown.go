@@ -0,0 +1,358 @@
+package simple
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"git.fiblab.net/sim/backend/util"
+	"git.fiblab.net/utils/lens"
+	"github.com/gin-gonic/gin"
+	"github.com/patrickmn/go-cache"
+	"github.com/paulmach/orb"
+	"github.com/samber/lo"
+)
+
+func errNoRoadStatus(name string) *util.Error {
+	return util.NewError(util.CodeNoRoadStatus, "simulation %s has no road status information", name).With("simulation", name)
+}
+
+// 查询有路况记录间隔的模拟的元数据，失败时填写HTTP返回值
+// Query the metadata of a simulation with the road status interval, the HTTP response is written on failure
+func roadStatusMeta(c *gin.Context, name string) (meta *Metadata, ok bool) {
+	meta, ok = simMetadata(c, name)
+	if !ok {
+		return
+	}
+	if meta.RoadStatusInterval == nil {
+		util.AbortWithError(c, errNoRoadStatus(meta.Name))
+		return nil, false
+	}
+	intervalCache.Set(name, roadStatusSource{meta.tables(), *meta.RoadStatusInterval}, cache.DefaultExpiration)
+	return meta, true
+}
+
+// 同roadStatusMeta，但还需要选取道路几何的v_min
+// The same as roadStatusMeta, but the v_min selecting the road geometry is also required
+func roadGeometryMeta(c *gin.Context, name string) (meta *Metadata, ok bool) {
+	meta, ok = roadStatusMeta(c, name)
+	if !ok {
+		return
+	}
+	if meta.RoadStatusVMin == nil {
+		util.AbortWithError(c, errNoRoadStatus(meta.Name))
+		return nil, false
+	}
+	return meta, true
+}
+
+// 开始与结束step Begin and end steps
+type StepRange struct {
+	Begin *int `form:"begin" binding:"required"` // step>=begin
+	End   *int `form:"end"   binding:"required"` // step<end
+}
+
+func (r *StepRange) Check() error {
+	if *r.End <= *r.Begin {
+		return errors.New("end should be larger than begin")
+	}
+	return nil
+}
+
+// 按记录间隔查询路况，每条记录代表[step, min(step+interval, end))内的路况
+// Query the road status at the recorded interval, each record stands for [step, min(step+interval, end))
+func queryRoadStatusWindow(c *gin.Context, name string, r StepRange) (meta *Metadata, all []*RoadStatus, ok bool) {
+	meta, ok = roadStatusMeta(c, name)
+	if !ok {
+		return
+	}
+	interval := *meta.RoadStatusInterval
+	all, err := queryRoadStatus(c.Request.Context(), meta.tables(), *r.Begin, *r.End, interval, interval)
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		util.AbortWithError(c, err)
+		return nil, nil, false
+	}
+	return meta, all, true
+}
+
+// @Summary Get the road status time series of a road
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param id path int true "Road ID"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Param interval query number false "Get the interval of the data (default is 1, return results step=begin,begin+1*interval,begin+2*interval...)"
+// @Success 200 object util.Response{data=[]RoadStatus} "successful operation"
+// @Router /simple/road-status/{tablename}/{id} [get]
+func GetRoadStatusSeriesByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		util.AbortWithError(c, util.NewError(util.CodeBadRequest, "id should be an integer"))
+		return
+	}
+	s := util.ValidateParam[lens.Step](c)
+	if s == nil {
+		return
+	}
+	t, interval, ok := roadStatusInterval(c, u.Name)
+	if !ok {
+		return
+	}
+	all, err := storage.Trajectory.RoadStatusOf(
+		c.Request.Context(), t, []int{id}, StepQuery{*s.Begin, *s.End, interval, *s.Interval},
+	)
+	if util.ResponseEmptyIfTableNotFound(c, all, err) {
+		return
+	}
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	c.JSON(200, util.NewResponse(all))
+}
+
+type RoadStatusRegionParam struct {
+	lens.Step
+	Aoi *int32 `form:"aoi"` // 使用AOI的多边形作为区域 Use the polygon of the AOI as the region
+}
+
+type RoadStatusRegionStat struct {
+	Roads []int            `json:"roads"` // 区域内的道路ID IDs of the roads in the region
+	Stats []RoadStatusStat `json:"stats"`
+}
+
+// @Summary Get road status statistics of the roads in a region
+// @Description The region is the polygon of the AOI given by the aoi query parameter,
+// @Description otherwise a GeoJSON Polygon/MultiPolygon (Geometry, Feature or FeatureCollection) in the request body.
+// @Description A road is in the region if its outermost driving lane (as in /simple/roadlane) intersects it.
+// @Accept application/json
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Param interval query number false "Get the interval of the data (default is 1, return results step=begin,begin+1*interval,begin+2*interval...)"
+// @Param aoi query int false "AOI ID used as the region"
+// @Success 200 object util.Response{data=RoadStatusRegionStat} "successful operation"
+// @Router /simple/road-status-region/{tablename} [post]
+func PostRoadStatusRegionByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	// 请求体为GeoJSON，只从query中绑定参数 the body is GeoJSON, bind the parameters from the query only
+	p := &RoadStatusRegionParam{}
+	if err := c.ShouldBindQuery(p); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return
+	}
+	if err := p.Check(); err != nil {
+		util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+		return
+	}
+	meta, ok := roadGeometryMeta(c, u.Name)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	g, err := mapGeometries.get(ctx, meta.Map)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+
+	var region orb.MultiPolygon
+	if p.Aoi != nil {
+		aoi, found := lo.Find(g.Aois, func(a *geoAoi) bool { return a.ID == *p.Aoi })
+		if !found {
+			util.AbortWithError(c, util.NewError(util.CodeNotFound, "aoi %d not found", *p.Aoi).With("aoi", *p.Aoi))
+			return
+		}
+		region = orb.MultiPolygon{aoi.Polygon}
+	} else {
+		body, err := c.GetRawData()
+		if err != nil {
+			util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+			return
+		}
+		if region, err = util.ParseGeoJSONPolygon(body); err != nil {
+			util.AbortWithError(c, util.WrapError(util.CodeBadRequest, err))
+			return
+		}
+	}
+	roads := lo.Map(roadLanesIn(g.outermostRoadLanes(*meta.RoadStatusVMin), polygonRegion(region)), func(rl geoRoadLane, _ int) int {
+		return int(rl.Road.ID)
+	})
+
+	all, err := storage.Trajectory.RoadStatusOf(
+		ctx, meta.tables(), roads, StepQuery{*p.Begin, *p.End, *meta.RoadStatusInterval, *p.Interval},
+	)
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		util.AbortWithError(c, err)
+		return
+	}
+	c.JSON(200, util.NewResponse(RoadStatusRegionStat{Roads: roads, Stats: roadStatusStats(all)}))
+}
+
+type RoadStatusDurationParam struct {
+	StepRange
+	Threshold *int `form:"threshold"` // 路况等级阈值，默认为3 Level threshold, 3 by default
+}
+
+func (p *RoadStatusDurationParam) Check() error {
+	if err := p.StepRange.Check(); err != nil {
+		return err
+	}
+	if p.Threshold == nil {
+		p.Threshold = new(int)
+		*p.Threshold = 3
+	}
+	if *p.Threshold < 0 || *p.Threshold > 6 {
+		return errors.New("threshold should be in [0, 6]")
+	}
+	return nil
+}
+
+type RoadStatusDuration struct {
+	Id      int     `json:"id"`      // 道路ID Road ID
+	Steps   int     `json:"steps"`   // 路况等级>=阈值的step数 Steps with level >= threshold
+	Seconds float64 `json:"seconds"` // 对应的时长（秒） The duration (second)
+}
+
+// @Summary Get how long each road is at or above a road status level
+// @Description Roads are sorted by the duration in descending order
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Param threshold query number false "Road status level threshold (default is 3), level >= threshold counts"
+// @Success 200 object util.Response{data=[]RoadStatusDuration} "successful operation"
+// @Router /simple/road-status-duration/{tablename} [get]
+func GetRoadStatusDurationByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	p := util.ValidateParam[RoadStatusDurationParam](c)
+	if p == nil {
+		return
+	}
+	meta, all, ok := queryRoadStatusWindow(c, u.Name, p.StepRange)
+	if !ok {
+		return
+	}
+	interval := *meta.RoadStatusInterval
+	id2Duration := make(map[int]*RoadStatusDuration)
+	for _, s := range all {
+		d, ok := id2Duration[s.Id]
+		if !ok {
+			d = &RoadStatusDuration{Id: s.Id}
+			id2Duration[s.Id] = d
+		}
+		if s.Level >= *p.Threshold {
+			d.Steps += lo.Min([]int{interval, *p.End - s.Step})
+		}
+	}
+	durations := lo.Values(id2Duration)
+	for _, d := range durations {
+		d.Seconds = float64(d.Steps) * meta.Time
+	}
+	sort.Slice(durations, func(i, j int) bool {
+		if durations[i].Steps != durations[j].Steps {
+			return durations[i].Steps > durations[j].Steps
+		}
+		return durations[i].Id < durations[j].Id
+	})
+	c.JSON(200, util.NewResponse(durations))
+}
+
+type RoadStatusTopParam struct {
+	StepRange
+	N *int `form:"n"` // 返回的道路数，默认为10 Number of roads, 10 by default
+}
+
+func (p *RoadStatusTopParam) Check() error {
+	if err := p.StepRange.Check(); err != nil {
+		return err
+	}
+	if p.N == nil {
+		p.N = new(int)
+		*p.N = 10
+	}
+	if *p.N < 1 || *p.N > 1000 {
+		return errors.New("n should be in [1, 1000]")
+	}
+	return nil
+}
+
+type RoadCongestion struct {
+	Id        int     `json:"id"`        // 道路ID Road ID
+	MeanLevel float64 `json:"meanLevel"` // 时间加权的平均路况等级 Time-weighted mean level
+	MaxLevel  int     `json:"maxLevel"`  // 最大路况等级 Max level
+}
+
+// 按记录间隔查询的路况计算各道路的时间加权平均等级与最大等级
+// Time-weighted mean and max levels of each road from the road status queried at the recorded interval
+func roadCongestions(all []*RoadStatus, interval, end int) map[int]*RoadCongestion {
+	type acc struct {
+		congestion *RoadCongestion
+		sum        float64
+		steps      int
+	}
+	id2Acc := make(map[int]*acc)
+	for _, s := range all {
+		a, ok := id2Acc[s.Id]
+		if !ok {
+			a = &acc{congestion: &RoadCongestion{Id: s.Id}}
+			id2Acc[s.Id] = a
+		}
+		steps := lo.Min([]int{interval, end - s.Step})
+		a.sum += float64(s.Level * steps)
+		a.steps += steps
+		a.congestion.MaxLevel = lo.Max([]int{a.congestion.MaxLevel, s.Level})
+	}
+	id2Congestion := make(map[int]*RoadCongestion, len(id2Acc))
+	for id, a := range id2Acc {
+		a.congestion.MeanLevel = util.ToFixed(a.sum/float64(a.steps), 4)
+		id2Congestion[id] = a.congestion
+	}
+	return id2Congestion
+}
+
+// @Summary Get the most congested roads in a time window
+// @Description Roads are ranked by the time-weighted mean road status level
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Param n query number false "Number of roads (default is 10, at most 1000)"
+// @Success 200 object util.Response{data=[]RoadCongestion} "successful operation"
+// @Router /simple/road-status-top/{tablename} [get]
+func GetRoadStatusTopByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	p := util.ValidateParam[RoadStatusTopParam](c)
+	if p == nil {
+		return
+	}
+	meta, all, ok := queryRoadStatusWindow(c, u.Name, p.StepRange)
+	if !ok {
+		return
+	}
+	top := lo.Values(roadCongestions(all, *meta.RoadStatusInterval, *p.End))
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].MeanLevel != top[j].MeanLevel {
+			return top[i].MeanLevel > top[j].MeanLevel
+		}
+		return top[i].Id < top[j].Id
+	})
+	if len(top) > *p.N {
+		top = top[:*p.N]
+	}
+	c.JSON(200, util.NewResponse(top))
+}
@@ -0,0 +1,123 @@
+package simple
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// 合成的路况表 Tables of the synthetic road status
+var benchTables = RecordTables{Name: "bench", Version: 2}
+
+// 生成合成的路况表，每dataInterval步记录一次所有道路
+// Create a synthetic road status table recording all roads every dataInterval steps
+func newSyntheticRoadStatus(tb testing.TB, roads, steps, dataInterval int) *sqliteTrajectoryStore {
+	tb.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(tb.TempDir(), "road.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE bench_s_road (step INT, id INT, level INT); CREATE INDEX bench_s_road_step ON bench_s_road (step)"); err != nil {
+		tb.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO bench_s_road VALUES (?, ?, ?)")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	for step := 0; step < steps; step += dataInterval {
+		for id := 0; id < roads; id++ {
+			if _, err := stmt.Exec(step, id, r.Intn(7)); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	if err := stmt.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+	return &sqliteTrajectoryStore{db: db}
+}
+
+func collectRoadStatusStats(s *sqliteTrajectoryStore, q StepQuery) ([]RoadStatusStat, error) {
+	stats := make([]RoadStatusStat, 0)
+	err := s.RoadStatusStats(context.Background(), benchTables, q, func(one RoadStatusStat) error {
+		stats = append(stats, one)
+		return nil
+	})
+	return stats, err
+}
+
+func TestRoadStatusStatsSQL(t *testing.T) {
+	s := newSyntheticRoadStatus(t, 20, 100, 5)
+	for _, q := range []StepQuery{
+		{Begin: 0, End: 100, DataInterval: 5, OutputInterval: 1},
+		{Begin: 3, End: 77, DataInterval: 5, OutputInterval: 7},
+		{Begin: 0, End: 100, DataInterval: 1, OutputInterval: 10},
+	} {
+		rows, err := s.RoadStatus(context.Background(), benchTables, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := roadStatusStats(rows)
+		got, err := collectRoadStatusStats(s, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%+v: want %d steps but got %d", q, len(want), len(got))
+		}
+		for i := range want {
+			if got[i].Step != want[i].Step ||
+				math.Abs(got[i].MeanCongestionLevel-want[i].MeanCongestionLevel) > 1e-9 ||
+				fmt.Sprint(got[i].LevelCounts) != fmt.Sprint(want[i].LevelCounts) {
+				t.Fatalf("%+v: want %+v but got %+v", q, want[i], got[i])
+			}
+		}
+	}
+}
+
+// 比较在Go中分组与在数据库中GROUP BY的耗时与内存
+// Compare grouping in Go with GROUP BY in the database
+//
+//	go test ./simple -run '^$' -bench RoadStatusStats -benchmem
+func BenchmarkRoadStatusStats(b *testing.B) {
+	const roads, steps, dataInterval = 2000, 18000, 30
+	s := newSyntheticRoadStatus(b, roads, steps, dataInterval)
+	q := StepQuery{Begin: 0, End: steps, DataInterval: dataInterval, OutputInterval: dataInterval}
+	b.Run("Go", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rows, err := s.RoadStatus(context.Background(), benchTables, q)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if n := len(roadStatusStats(rows)); n != steps/dataInterval {
+				b.Fatalf("want %d steps but got %d", steps/dataInterval, n)
+			}
+		}
+	})
+	b.Run("SQL", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			stats, err := collectRoadStatusStats(s, q)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if n := len(stats); n != steps/dataInterval {
+				b.Fatalf("want %d steps but got %d", steps/dataInterval, n)
+			}
+		}
+	})
+}
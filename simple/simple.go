@@ -0,0 +1,31 @@
+package simple
+
+import (
+	"context"
+	"fmt"
+
+	"git.fiblab.net/sim/backend/config"
+)
+
+// 根据配置初始化simple包，不修改表结构，需要时先执行Migrate
+// Initialize package simple with the config, the tables are left as they are, run Migrate first if needed
+func Init(c *config.Config) error {
+	initIntervalCache(c.Cache.IntervalTTL.Duration)
+	initMapCache(c.Cache.MapTTL.Duration, c.Cache.MapMaxNodes)
+	s, err := newStorage(c)
+	if err != nil {
+		return err
+	}
+	SetStorage(s)
+	return nil
+}
+
+// 补齐元数据表结构：访问控制的列与名称的唯一索引，由-migrate显式执行
+// Bring the metadata table up to date: the access control columns and the unique index on the name, run explicitly
+// by -migrate
+func Migrate(ctx context.Context) error {
+	if err := storage.Meta.Migrate(ctx); err != nil {
+		return fmt.Errorf("migrate meta_simple: %w", err)
+	}
+	return nil
+}
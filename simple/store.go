@@ -0,0 +1,238 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"git.fiblab.net/sim/backend/config"
+	"git.fiblab.net/sim/backend/util"
+	"git.fiblab.net/utils/lens"
+	"github.com/paulmach/orb"
+	"github.com/samber/lo"
+)
+
+// 存储后端 Storage backends
+const (
+	StoragePg   = "pg"   // PostgreSQL + MongoDB
+	StorageFile = "file" // SQLite + 地图JSON文件 SQLite + map JSON files
+)
+
+var (
+	errBadMapPath  = util.NewError(util.CodeBadRequest, "bad map path format")
+	errMapNotFound = errors.New("map not found")
+)
+
+// 地图不存在的错误，带map_not_found错误码 Error of a missing map with the map_not_found code
+func newMapNotFound(mapPath string) error {
+	return util.WrapError(util.CodeMapNotFound, fmt.Errorf("%w: %s", errMapNotFound, mapPath)).With("map", mapPath)
+}
+
+// 模拟元数据存储 Simulation metadata store
+type MetadataStore interface {
+	// 补齐表结构：访问控制的列与名称的唯一索引，可重复执行
+	// Bring the table up to date: the access control columns and the unique index on the name, can run repeatedly
+	Migrate(ctx context.Context) error
+	// name为nil时返回所有模拟 Return all simulations when name is nil
+	QueryMetadata(ctx context.Context, name *string) ([]*Metadata, error)
+	CreateMetadata(ctx context.Context, m *Metadata) error
+	// 按名称更新除名称外的所有字段 Update all fields except the name by the name
+	UpdateMetadata(ctx context.Context, m *Metadata) error
+	// dropTables为true时同时删除DBRecorder输出的表 Also drop the DBRecorder output tables if dropTables is true
+	DeleteMetadata(ctx context.Context, name string, dropTables bool) error
+}
+
+// 名称的唯一索引，并发注册同名模拟时只有一个成功
+// Unique index on the name so that only one of concurrent registrations of the same simulation succeeds
+const createMetaNameIndexSQL = "CREATE UNIQUE INDEX IF NOT EXISTS meta_simple_name ON meta_simple (name)"
+
+// 访问控制的列，旧的meta_simple没有时由迁移添加
+// Access control columns, added by the migration to older meta_simple tables without them
+var metaAccessColumns = []string{"owner", "group_name"}
+
+// 按step查询的参数，语义与lens.QueryPgTableWithStep一致
+// Step query, the semantics is the same as lens.QueryPgTableWithStep
+type StepQuery struct {
+	Begin          int // step>=begin
+	End            int // step<end
+	DataInterval   int // 表中数据点间隔 Step interval of the records in the table
+	OutputInterval int // 返回值的step间隔 Step interval of the output
+}
+
+// 经纬度范围 Longitude/latitude bounding box
+type BBox struct {
+	MinLng float64
+	MinLat float64
+	MaxLng float64
+	MaxLat float64
+}
+
+// 轨迹存储，即DBRecorder的输出 Trajectory store, i.e. the output of DBRecorder
+//
+// 表不存在时返回的错误满足util.CheckIsTableNotFound，不同DBRecorder版本的列由recordSchemas统一
+// Errors for missing tables satisfy util.CheckIsTableNotFound, the columns of the DBRecorder versions are unified by recordSchemas
+type TrajectoryStore interface {
+	Cars(ctx context.Context, t RecordTables, q StepQuery, b BBox) ([]*CarV2, error)
+	People(ctx context.Context, t RecordTables, q StepQuery, b BBox) ([]*Person, error)
+	// 指定车道的信号灯，表中只有step、车道ID与状态，空间筛选需先根据地图确定车道
+	// Traffic lights of the given lanes, the table only has step, lane ID and state,
+	// so spatial filtering should select the lanes with the map first
+	TrafficLights(ctx context.Context, t RecordTables, ids []int, q StepQuery) ([]*TrafficLight, error)
+	RoadStatus(ctx context.Context, t RecordTables, q StepQuery) ([]*RoadStatus, error)
+	// 指定道路的路况 Road status of the given roads
+	RoadStatusOf(ctx context.Context, t RecordTables, ids []int, q StepQuery) ([]*RoadStatus, error)
+	// 在数据库中按step聚合路况统计，按step升序逐条回调emit，emit返回错误时中止
+	// Aggregate the road status statistics by step in the database, emit is called in ascending step order
+	// and the query stops when emit returns an error
+	RoadStatusStats(ctx context.Context, t RecordTables, q StepQuery, emit func(RoadStatusStat) error) error
+	// 单个车辆/行人的轨迹 Trajectory of a single vehicle/person
+	CarTrajectory(ctx context.Context, t RecordTables, id int, q StepQuery) ([]*CarV2, error)
+	PersonTrajectory(ctx context.Context, t RecordTables, id int, q StepQuery) ([]*Person, error)
+	// 按车道与时间段聚合车辆记录，bucket为时间段的step数
+	// Aggregate vehicle records by lane and step bucket, bucket is the number of steps in a bucket
+	LaneCarStats(ctx context.Context, t RecordTables, begin, end, bucket int) ([]*LaneCarStat, error)
+	// 按step聚合车辆数与平均速度，按step升序排列 Vehicle count and mean speed aggregated by step in ascending step order
+	CarStepStats(ctx context.Context, t RecordTables, begin, end int) ([]*CarStepStat, error)
+	// 信号灯状态变化点，即每个车道在[begin, end)内第一条记录及状态与前一条不同的记录，按车道ID与step升序排列
+	// State changes of the traffic lights, i.e. the first record of each lane in [begin, end) and the records
+	// whose state differs from the previous one, ordered by lane ID and step
+	TrafficLightChanges(ctx context.Context, t RecordTables, begin, end int) ([]*TrafficLight, error)
+}
+
+// 投影坐标系下的范围 Bounding box in the projected coordinate system of the map
+type XYBox struct {
+	MinX float64
+	MinY float64
+	MaxX float64
+	MaxY float64
+}
+
+func (b *XYBox) bound() orb.Bound {
+	return orb.Bound{Min: orb.Point{b.MinX, b.MinY}, Max: orb.Point{b.MaxX, b.MaxY}}
+}
+
+func xyPoints(nodes []MapNode) []orb.Point {
+	return lo.Map(nodes, func(n MapNode, _ int) orb.Point { return orb.Point{n.X, n.Y} })
+}
+
+// 折线是否与范围相交（包括线段穿过范围而没有节点在范围内的情况）
+// Whether the line intersects the box, including segments crossing it without a node inside
+func (b *XYBox) IntersectsLine(nodes []MapNode) bool {
+	return util.LineIntersectsBound(xyPoints(nodes), b.bound())
+}
+
+// 多边形是否与范围相交（包括范围完全在多边形内的情况）
+// Whether the polygon intersects the box, including the box being inside the polygon
+func (b *XYBox) IntersectsPolygon(nodes []MapNode) bool {
+	return util.PolygonIntersectsBound(orb.Polygon{xyPoints(nodes)}, b.bound())
+}
+
+// 车道筛选条件，零值表示不筛选 Lane filter, zero values mean no filtering
+type LaneFilter struct {
+	Parent      LaneType // 按所属道路/路口筛选 Filter by the parent (road or junction)
+	Type        *int32   // 车道类型 Lane type
+	MinMaxSpeed *float64 // 限速下限 Lower bound of max_speed
+	Box         *XYBox   // 中心线与范围相交 The center line intersects the box
+}
+
+// 地图存储，mapPath格式为"db.collection"，地图不存在时返回的错误满足errors.Is(err, errMapNotFound)
+// Map store, the format of mapPath is "db.collection", errors for missing maps satisfy errors.Is(err, errMapNotFound)
+type MapStore interface {
+	// 所有可用地图的路径，按名称排序 Paths of all available maps ordered by name
+	List(ctx context.Context) ([]string, error)
+	Header(ctx context.Context, mapPath string) (*MapHeader, error)
+	Lanes(ctx context.Context, mapPath string, f LaneFilter) ([]*MapLane, error)
+	Roads(ctx context.Context, mapPath string) ([]*MapRoad, error)
+	Junctions(ctx context.Context, mapPath string) ([]*MapJunction, error)
+	// box为nil时返回所有AOI，否则返回与范围相交的AOI
+	// Return all AOIs when box is nil, otherwise AOIs intersecting the box
+	Aois(ctx context.Context, mapPath string, box *XYBox) ([]*MapAoi, error)
+}
+
+// 按db tag获取列名与对应字段的指针 Get column names and field pointers by the db tags
+func dbColumns(v any) (columns []string, ptrs []any) {
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		if tag, ok := rt.Field(i).Tag.Lookup("db"); ok {
+			columns = append(columns, tag)
+			ptrs = append(ptrs, rv.Field(i).Addr().Interface())
+		}
+	}
+	return
+}
+
+// 按step查询的SQL，语义与lens.QueryPgTableWithStep一致，DataInterval>1时向前多读取一个间隔以便selectSteps取左侧最近的数据；
+// extraWhere的参数在前，placeholder为"$"（PostgreSQL）或"?"（SQLite）
+// SQL of a step query with the same semantics as lens.QueryPgTableWithStep, one more interval is read back when
+// DataInterval>1 so that selectSteps can take the nearest records on the left;
+// the arguments of extraWhere come first, placeholder is "$" (PostgreSQL) or "?" (SQLite)
+func stepQuerySQL(from string, columns []string, q StepQuery, extraWhere string, extraArgs []any, placeholder string) (string, []any) {
+	ph := func(i int) string {
+		if placeholder == "$" {
+			return fmt.Sprintf("$%d", i)
+		}
+		return "?"
+	}
+	n := len(extraArgs)
+	args := append(append([]any{}, extraArgs...), q.Begin, q.End)
+	where := fmt.Sprintf("step>=%s AND step<%s", ph(n+1), ph(n+2))
+	if q.DataInterval > 1 {
+		args[n] = q.Begin - q.DataInterval
+	} else if q.OutputInterval > 1 {
+		// 只读取输出的step only read the output steps
+		where += fmt.Sprintf(" AND (step-%s)%%%s=0", ph(n+3), ph(n+4))
+		args = append(args, q.Begin, q.OutputInterval)
+	}
+	if extraWhere != "" {
+		where = extraWhere + " AND " + where
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY step", strings.Join(columns, ","), from, where), args
+}
+
+func bboxOf(s *lens.StepCoordinate) BBox {
+	return BBox{MinLng: *s.Lng1, MinLat: *s.Lat1, MaxLng: *s.Lng2, MaxLat: *s.Lat2}
+}
+
+type Storage struct {
+	Meta       MetadataStore
+	Trajectory TrajectoryStore
+	Map        MapStore
+}
+
+var storage *Storage
+
+func SetStorage(s *Storage) {
+	storage = s
+	// 缓存的地图几何来自之前的存储 the cached map geometry came from the previous storage
+	mapGeometries.purge()
+}
+
+func DefaultStorage() *Storage {
+	return storage
+}
+
+func newStorage(c *config.Config) (*Storage, error) {
+	switch c.Storage.Backend {
+	case StoragePg:
+		return withInstrumentation(newPgStorage(), "postgres", "postgres", "mongo"), nil
+	case StorageFile:
+		s, err := newFileStorage(c.Storage.SQLitePath, c.Storage.MapDir)
+		if err != nil {
+			return nil, err
+		}
+		return withInstrumentation(s, "sqlite", "sqlite", "file"), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %s", c.Storage.Backend)
+	}
+}
+
+func splitMapPath(mapPath string) (db string, col string, err error) {
+	parts := strings.Split(mapPath, ".")
+	if len(parts) != 2 {
+		return "", "", errBadMapPath
+	}
+	return parts[0], parts[1], nil
+}
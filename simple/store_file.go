@@ -0,0 +1,573 @@
+package simple
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"git.fiblab.net/sim/backend/util"
+	"git.fiblab.net/utils/lens"
+	"github.com/jackc/pgx/v4"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/samber/lo"
+)
+
+// 基于文件的存储：元数据与轨迹存储在SQLite中（表结构与PostgreSQL一致），
+// 地图存储在mapDir下的"db.collection.json"文件中（内容为pb2coll导出的文档数组）
+//
+// File-backed storage: metadata and trajectories are stored in SQLite with the same
+// tables as PostgreSQL, maps are stored in "db.collection.json" files under mapDir
+// (a JSON array of the documents written by pb2coll).
+func newFileStorage(sqlitePath string, mapDir string) (*Storage, error) {
+	db, err := sql.Open("sqlite3", sqlitePath)
+	if err != nil {
+		return nil, err
+	}
+	if sqlitePath == ":memory:" {
+		// 每个连接都是独立的内存数据库 every connection is a separate in-memory database
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return &Storage{
+		Meta:       &sqliteMetadataStore{db: db},
+		Trajectory: &sqliteTrajectoryStore{db: db},
+		Map:        &fileMapStore{dir: mapDir, maps: make(map[string]*fileMap)},
+	}, nil
+}
+
+func sqliteError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "no such table") {
+		return fmt.Errorf("%w: %v", util.ErrTableNotFound, err)
+	}
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return fmt.Errorf("%w: %v", util.ErrUniqueViolation, err)
+	}
+	return err
+}
+
+type sqliteMetadataStore struct {
+	db *sql.DB
+}
+
+func (s *sqliteMetadataStore) Migrate(ctx context.Context) error {
+	// SQLite不支持ADD COLUMN IF NOT EXISTS，先查询已有的列 SQLite has no ADD COLUMN IF NOT EXISTS, query the existing columns first
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM pragma_table_info('meta_simple')")
+	if err != nil {
+		return sqliteError(err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		// 表不存在，没有需要迁移的数据 the table does not exist, there is nothing to migrate
+		return nil
+	}
+	for _, col := range metaAccessColumns {
+		if existing[col] {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, "ALTER TABLE meta_simple ADD COLUMN "+col+" TEXT"); err != nil {
+			return sqliteError(err)
+		}
+	}
+	_, err = s.db.ExecContext(ctx, createMetaNameIndexSQL)
+	return sqliteError(err)
+}
+
+func (s *sqliteMetadataStore) QueryMetadata(ctx context.Context, name *string) ([]*Metadata, error) {
+	columns, _ := dbColumns(&Metadata{})
+	query := fmt.Sprintf("SELECT %s FROM meta_simple", strings.Join(columns, ","))
+	var args []any
+	if name != nil {
+		query += " WHERE name=?"
+		args = []any{*name}
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	all := make([]*Metadata, 0)
+	for rows.Next() {
+		one := &Metadata{}
+		_, ptrs := dbColumns(one)
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *sqliteMetadataStore) CreateMetadata(ctx context.Context, m *Metadata) error {
+	columns, ptrs := dbColumns(m)
+	_, err := s.db.ExecContext(
+		ctx,
+		fmt.Sprintf(
+			"INSERT INTO meta_simple (%s) VALUES (%s)",
+			strings.Join(columns, ","), strings.TrimSuffix(strings.Repeat("?,", len(columns)), ","),
+		),
+		sqliteValues(ptrs)...,
+	)
+	return sqliteError(err)
+}
+
+func (s *sqliteMetadataStore) UpdateMetadata(ctx context.Context, m *Metadata) error {
+	columns, ptrs := dbColumns(m)
+	values := sqliteValues(ptrs)
+	sets := make([]string, 0, len(columns))
+	args := make([]any, 0, len(columns))
+	for i, col := range columns {
+		if col != "name" {
+			sets = append(sets, col+"=?")
+			args = append(args, values[i])
+		}
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		fmt.Sprintf("UPDATE meta_simple SET %s WHERE name=?", strings.Join(sets, ",")),
+		append(args, m.Name)...,
+	)
+	return sqliteError(err)
+}
+
+func (s *sqliteMetadataStore) DeleteMetadata(ctx context.Context, name string, dropTables bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM meta_simple WHERE name=?", name); err != nil {
+		return sqliteError(err)
+	}
+	if dropTables {
+		for _, suffix := range recordSuffixes {
+			if _, err := tx.ExecContext(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{name + suffix}.Sanitize()); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// 字段指针对应的值 Values of the field pointers
+func sqliteValues(ptrs []any) []any {
+	return lo.Map(ptrs, func(p any, _ int) any { return reflect.ValueOf(p).Elem().Interface() })
+}
+
+type sqliteTrajectoryStore struct {
+	db *sql.DB
+}
+
+// 按step查询SQLite表，语义与lens.QueryPgTableWithStep一致
+// Query a SQLite table by step with the same semantics as lens.QueryPgTableWithStep
+func querySQLiteWithStep[T any, PT interface {
+	lens.IHasStep
+	*T
+}](
+	ctx context.Context, db *sql.DB, t RecordTables, kind recordKind, q StepQuery,
+	extraWhere string, extraArgs []any,
+) ([]PT, error) {
+	from, err := t.from(kind)
+	if err != nil {
+		return nil, err
+	}
+	columns, _ := dbColumns(PT(new(T)))
+	query, args := stepQuerySQL(from, columns, q, extraWhere, extraArgs, "?")
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	all := make([]PT, 0)
+	for rows.Next() {
+		var one PT = new(T)
+		_, ptrs := dbColumns(one)
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return selectSteps(all, q), nil
+}
+
+// 从按step升序排列的数据中选出输出step对应的数据
+// 如果DataInterval=1，精确匹配输出step；否则取输出step左侧最近的数据并替换step
+// Select records for the output steps from records sorted by step.
+// If DataInterval=1, steps are matched exactly; otherwise the nearest records on the left are copied with the output step.
+func selectSteps[PT lens.IHasStep](sorted []PT, q StepQuery) []PT {
+	outputSteps := lo.RangeWithSteps(q.Begin, q.End, q.OutputInterval)
+	result := make([]PT, 0)
+	if q.DataInterval <= 1 {
+		for _, one := range sorted {
+			step := one.GetStep()
+			if step >= q.Begin && step < q.End && (step-q.Begin)%q.OutputInterval == 0 {
+				result = append(result, one)
+			}
+		}
+		return result
+	}
+	i := 0        // 下一条未处理的数据 next unprocessed record
+	var last []PT // 最近一个step的数据 records of the latest step
+	for _, out := range outputSteps {
+		for i < len(sorted) && sorted[i].GetStep() <= out {
+			step := sorted[i].GetStep()
+			last = last[:0:0]
+			for i < len(sorted) && sorted[i].GetStep() == step {
+				last = append(last, sorted[i])
+				i++
+			}
+		}
+		for _, one := range last {
+			result = append(result, one.Copy(out).(PT))
+		}
+	}
+	return result
+}
+
+const sqliteBBoxWhere = "lat>=? AND lat<? AND lng>=? AND lng<?"
+
+// id IN (?,?,...)
+func sqliteIDsWhere(ids []int) (string, []any) {
+	where := "id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",") + ")"
+	return where, lo.Map(ids, func(id int, _ int) any { return id })
+}
+
+func (s *sqliteTrajectoryStore) Cars(ctx context.Context, t RecordTables, q StepQuery, b BBox) ([]*CarV2, error) {
+	return querySQLiteWithStep[CarV2](ctx, s.db, t, recordCars, q, sqliteBBoxWhere, pgBBoxArgs(b))
+}
+
+func (s *sqliteTrajectoryStore) People(ctx context.Context, t RecordTables, q StepQuery, b BBox) ([]*Person, error) {
+	return querySQLiteWithStep[Person](ctx, s.db, t, recordPeople, q, sqliteBBoxWhere, pgBBoxArgs(b))
+}
+
+func (s *sqliteTrajectoryStore) TrafficLights(ctx context.Context, t RecordTables, ids []int, q StepQuery) ([]*TrafficLight, error) {
+	if len(ids) == 0 {
+		return []*TrafficLight{}, nil
+	}
+	where, args := sqliteIDsWhere(ids)
+	return querySQLiteWithStep[TrafficLight](ctx, s.db, t, recordTrafficLights, q, where, args)
+}
+
+func (s *sqliteTrajectoryStore) RoadStatus(ctx context.Context, t RecordTables, q StepQuery) ([]*RoadStatus, error) {
+	return querySQLiteWithStep[RoadStatus](ctx, s.db, t, recordRoadStatus, q, "", nil)
+}
+
+func (s *sqliteTrajectoryStore) RoadStatusOf(ctx context.Context, t RecordTables, ids []int, q StepQuery) ([]*RoadStatus, error) {
+	if len(ids) == 0 {
+		return []*RoadStatus{}, nil
+	}
+	where, args := sqliteIDsWhere(ids)
+	return querySQLiteWithStep[RoadStatus](ctx, s.db, t, recordRoadStatus, q, where, args)
+}
+
+func (s *sqliteTrajectoryStore) RoadStatusStats(ctx context.Context, t RecordTables, q StepQuery, emit func(RoadStatusStat) error) error {
+	selector := newRoadStatusStatSelector(q, emit)
+	from, err := t.from(recordRoadStatus)
+	if err != nil {
+		return err
+	}
+	rows, err := s.db.QueryContext(ctx, roadStatusStatsSQL(from, "?"), q.Begin-selector.lookBack(), q.End)
+	if err != nil {
+		return sqliteError(err)
+	}
+	defer rows.Close()
+	if err := scanRoadStatusStats(rows, selector); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return selector.finish()
+}
+
+func (s *sqliteTrajectoryStore) CarTrajectory(ctx context.Context, t RecordTables, id int, q StepQuery) ([]*CarV2, error) {
+	return querySQLiteWithStep[CarV2](ctx, s.db, t, recordCars, q, "id=?", []any{id})
+}
+
+func (s *sqliteTrajectoryStore) PersonTrajectory(ctx context.Context, t RecordTables, id int, q StepQuery) ([]*Person, error) {
+	return querySQLiteWithStep[Person](ctx, s.db, t, recordPeople, q, "id=?", []any{id})
+}
+
+func (s *sqliteTrajectoryStore) LaneCarStats(ctx context.Context, t RecordTables, begin, end, bucket int) ([]*LaneCarStat, error) {
+	from, err := t.from(recordCars)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, laneCarStatsSQL(from, "?"), begin, end, bucket)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	all := make([]*LaneCarStat, 0)
+	for rows.Next() {
+		one := &LaneCarStat{}
+		if err := rows.Scan(one.scanTargets()...); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *sqliteTrajectoryStore) TrafficLightChanges(ctx context.Context, t RecordTables, begin, end int) ([]*TrafficLight, error) {
+	from, err := t.from(recordTrafficLights)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, trafficLightChangesSQL(from, "?"), begin, end)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	all := make([]*TrafficLight, 0)
+	for rows.Next() {
+		one := &TrafficLight{}
+		if err := rows.Scan(&one.Step, &one.Id, &one.State); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *sqliteTrajectoryStore) CarStepStats(ctx context.Context, t RecordTables, begin, end int) ([]*CarStepStat, error) {
+	from, err := t.from(recordCars)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, carStepStatsSQL(from, "?"), begin, end)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+	defer rows.Close()
+	all := make([]*CarStepStat, 0)
+	for rows.Next() {
+		one := &CarStepStat{}
+		if err := rows.Scan(&one.Step, &one.Vehicles, &one.MeanV); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+type fileMapDoc struct {
+	Class string          `json:"class"`
+	Data  json.RawMessage `json:"data"`
+}
+
+type fileLane struct {
+	ID           int32               `json:"id"`
+	Type         int32               `json:"type"`
+	Turn         int32               `json:"turn"`
+	ParentID     int32               `json:"parent_id"`
+	MaxSpeed     float64             `json:"max_speed"`
+	Width        float64             `json:"width"`
+	Predecessors []MapLaneConnection `json:"predecessors"`
+	Successors   []MapLaneConnection `json:"successors"`
+	CenterLine   struct {
+		Nodes []MapNode `json:"nodes"`
+	} `json:"center_line"`
+}
+
+type fileAoi struct {
+	ID        int32     `json:"id"`
+	Area      *float64  `json:"area"`
+	Positions []MapNode `json:"positions"`
+}
+
+type fileMap struct {
+	header    *MapHeader
+	lanes     []*fileLane
+	roads     []*MapRoad
+	junctions []*MapJunction
+	aois      []*fileAoi
+}
+
+type fileMapStore struct {
+	dir  string
+	mu   sync.Mutex
+	maps map[string]*fileMap // mapPath -> map
+}
+
+func (s *fileMapStore) load(mapPath string) (*fileMap, error) {
+	db, col, err := splitMapPath(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.maps[mapPath]; ok {
+		return m, nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, db+"."+col+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, newMapNotFound(mapPath)
+	} else if err != nil {
+		return nil, err
+	}
+	var docs []fileMapDoc
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, fmt.Errorf("parse map %s: %w", mapPath, err)
+	}
+	m := &fileMap{}
+	for _, doc := range docs {
+		switch doc.Class {
+		case "header":
+			m.header = &MapHeader{}
+			err = json.Unmarshal(doc.Data, &m.header.Data)
+		case "lane":
+			one := &fileLane{}
+			err = json.Unmarshal(doc.Data, one)
+			m.lanes = append(m.lanes, one)
+		case "road":
+			one := &MapRoad{}
+			err = json.Unmarshal(doc.Data, one)
+			m.roads = append(m.roads, one)
+		case "junction":
+			one := &MapJunction{}
+			err = json.Unmarshal(doc.Data, one)
+			m.junctions = append(m.junctions, one)
+		case "aoi":
+			one := &fileAoi{}
+			err = json.Unmarshal(doc.Data, one)
+			m.aois = append(m.aois, one)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse map %s: %w", mapPath, err)
+		}
+	}
+	if m.header == nil {
+		return nil, fmt.Errorf("map %s has no header", mapPath)
+	}
+	s.maps[mapPath] = m
+	return m, nil
+}
+
+// MAP_DIR中的"db.collection.json"文件 The "db.collection.json" files in MAP_DIR
+func (s *fileMapStore) List(ctx context.Context) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	maps := make([]string, 0, len(files))
+	for _, f := range files {
+		mapPath := strings.TrimSuffix(filepath.Base(f), ".json")
+		if _, _, err := splitMapPath(mapPath); err == nil {
+			maps = append(maps, mapPath)
+		}
+	}
+	sort.Strings(maps)
+	return maps, nil
+}
+
+func (s *fileMapStore) Header(ctx context.Context, mapPath string) (*MapHeader, error) {
+	m, err := s.load(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	return m.header, nil
+}
+
+func (s *fileMapStore) Lanes(ctx context.Context, mapPath string, f LaneFilter) ([]*MapLane, error) {
+	m, err := s.load(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	lanes := make([]*MapLane, 0)
+	for _, l := range m.lanes {
+		if f.Parent == JunctionLane && l.ParentID < 300000000 ||
+			f.Parent == RoadLane && l.ParentID >= 300000000 ||
+			f.Type != nil && l.Type != *f.Type ||
+			f.MinMaxSpeed != nil && l.MaxSpeed < *f.MinMaxSpeed ||
+			f.Box != nil && !f.Box.IntersectsLine(l.CenterLine.Nodes) {
+			continue
+		}
+		// 返回副本，避免调用方修改缓存 return a copy so that callers cannot modify the cache
+		lanes = append(lanes, &MapLane{
+			ID:           l.ID,
+			Line:         l.CenterLine.Nodes,
+			Type:         l.Type,
+			Turn:         l.Turn,
+			ParentID:     l.ParentID,
+			MaxSpeed:     l.MaxSpeed,
+			Width:        l.Width,
+			Predecessors: l.Predecessors,
+			Successors:   l.Successors,
+		})
+	}
+	return lanes, nil
+}
+
+func (s *fileMapStore) Roads(ctx context.Context, mapPath string) ([]*MapRoad, error) {
+	m, err := s.load(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(m.roads, func(r *MapRoad, _ int) *MapRoad {
+		rr := *r
+		return &rr
+	}), nil
+}
+
+func (s *fileMapStore) Junctions(ctx context.Context, mapPath string) ([]*MapJunction, error) {
+	m, err := s.load(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(m.junctions, func(j *MapJunction, _ int) *MapJunction {
+		jj := *j
+		return &jj
+	}), nil
+}
+
+func (s *fileMapStore) Aois(ctx context.Context, mapPath string, box *XYBox) ([]*MapAoi, error) {
+	m, err := s.load(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	aois := make([]*MapAoi, 0)
+	for _, a := range m.aois {
+		if a.Area == nil || box != nil && !box.IntersectsPolygon(a.Positions) {
+			continue
+		}
+		aois = append(aois, &MapAoi{ID: a.ID, Positions: a.Positions})
+	}
+	return aois, nil
+}
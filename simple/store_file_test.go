@@ -0,0 +1,43 @@
+package simple
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqliteMigrate(t *testing.T) {
+	s, err := newFileStorage(filepath.Join(t.TempDir(), "simple.db"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	// 表不存在时跳过 skipped without the table
+	if err := s.Meta.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	db := s.Meta.(*sqliteMetadataStore).db
+	// 没有访问控制列的旧表 an older table without the access control columns
+	if _, err := db.Exec(`CREATE TABLE meta_simple (
+		name TEXT NOT NULL, start INT NOT NULL, steps INT NOT NULL, time FLOAT8 NOT NULL, total_agents INT NOT NULL,
+		map TEXT NOT NULL, min_lng FLOAT8 NOT NULL, min_lat FLOAT8 NOT NULL, max_lng FLOAT8 NOT NULL, max_lat FLOAT8 NOT NULL,
+		road_status_v_min FLOAT8, road_status_interval INT, version INT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO meta_simple VALUES ('a', 0, 10, 1.0, 0, 'moss.map', 0, 0, 1, 1, NULL, NULL, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	// 迁移可重复执行 the migration can run repeatedly
+	for i := 0; i < 2; i++ {
+		if err := s.Meta.Migrate(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := s.Meta.QueryMetadata(ctx, nil)
+	if err != nil || len(all) != 1 || all[0].Owner != nil || all[0].GroupName != nil {
+		t.Fatalf("unexpected metadata %+v: %v", all, err)
+	}
+	if err := s.Meta.CreateMetadata(ctx, all[0]); err == nil {
+		t.Fatal("want unique violation for a duplicate name")
+	}
+}
@@ -0,0 +1,248 @@
+package simple
+
+import (
+	"context"
+	"time"
+
+	"git.fiblab.net/sim/backend/logging"
+	"git.fiblab.net/sim/backend/metrics"
+	"git.fiblab.net/sim/backend/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "moss_storage_query_duration_seconds",
+		Help:    "Duration of storage queries by database and operation.",
+		Buckets: metrics.DurationBuckets,
+	}, []string{"store", "op"})
+	trajectoryRows = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "moss_trajectory_query_rows",
+		Help:    "Rows returned per trajectory query by operation.",
+		Buckets: prometheus.ExponentialBuckets(1, 10, 7),
+	}, []string{"op"})
+)
+
+// 存储名对应的OpenTelemetry db.system Values of the OpenTelemetry db.system of the stores
+var dbSystems = map[string]string{
+	"postgres": "postgresql",
+	"mongo":    "mongodb",
+	"sqlite":   "sqlite",
+	"file":     "file",
+}
+
+// 一次存储调用：记录耗时指标、请求日志中的数据库统计与span，rows<0表示不返回行；
+// target为模拟名或地图路径
+// A storage call: record the duration metric, the database statistics of the request log and a span,
+// rows<0 means no rows are returned; target is the simulation name or the map path
+func instrument(ctx context.Context, store, op, target string, call func(ctx context.Context) (rows int, err error)) error {
+	ctx, span := tracing.Tracer().Start(ctx, store+" "+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String(dbSystems[store]), semconv.DBOperation(op)))
+	if target != "" {
+		span.SetAttributes(attribute.String("moss.target", target))
+	}
+	start := time.Now()
+	rows, err := call(ctx)
+	d := time.Since(start)
+	queryDuration.WithLabelValues(store, op).Observe(d.Seconds())
+	logging.RecordQuery(ctx, d, rows)
+	if rows >= 0 {
+		span.SetAttributes(attribute.Int("db.response.returned_rows", rows))
+	}
+	tracing.Finish(span, err)
+	return err
+}
+
+// 不返回行的存储调用 A storage call without rows
+func instrumentCall(ctx context.Context, store, op, target string, call func(ctx context.Context) error) error {
+	return instrument(ctx, store, op, target, func(ctx context.Context) (int, error) {
+		return -1, call(ctx)
+	})
+}
+
+// 返回行的存储调用 A storage call with rows
+func instrumentRows[T any](ctx context.Context, store, op, target string, call func(ctx context.Context) ([]T, error)) ([]T, error) {
+	var res []T
+	err := instrument(ctx, store, op, target, func(ctx context.Context) (int, error) {
+		var err error
+		res, err = call(ctx)
+		return len(res), err
+	})
+	return res, err
+}
+
+// 轨迹查询，同时记录返回的行数 A trajectory query, the returned rows are also recorded
+func trajectoryQuery[T any](ctx context.Context, store, op, target string, call func(ctx context.Context) ([]T, error)) ([]T, error) {
+	res, err := instrumentRows(ctx, store, op, target, call)
+	if err == nil {
+		trajectoryRows.WithLabelValues(op).Observe(float64(len(res)))
+	}
+	return res, err
+}
+
+// 为存储的每个调用记录指标、日志与span，store为数据库名如"postgres"、"mongo"
+// Record the metrics, logs and spans of every call of the storage, the store is the database name such as "postgres" or "mongo"
+func withInstrumentation(s *Storage, metaStore, trajectoryStore, mapStore string) *Storage {
+	return &Storage{
+		Meta:       &instrumentedMetadataStore{s.Meta, metaStore},
+		Trajectory: &instrumentedTrajectoryStore{s.Trajectory, trajectoryStore},
+		Map:        &instrumentedMapStore{s.Map, mapStore},
+	}
+}
+
+type instrumentedMetadataStore struct {
+	s     MetadataStore
+	store string
+}
+
+func (i *instrumentedMetadataStore) Migrate(ctx context.Context) error {
+	return instrumentCall(ctx, i.store, "Migrate", "", i.s.Migrate)
+}
+
+func (i *instrumentedMetadataStore) QueryMetadata(ctx context.Context, name *string) ([]*Metadata, error) {
+	return instrumentRows(ctx, i.store, "QueryMetadata", lo.FromPtr(name), func(ctx context.Context) ([]*Metadata, error) {
+		return i.s.QueryMetadata(ctx, name)
+	})
+}
+
+func (i *instrumentedMetadataStore) CreateMetadata(ctx context.Context, m *Metadata) error {
+	return instrumentCall(ctx, i.store, "CreateMetadata", m.Name, func(ctx context.Context) error {
+		return i.s.CreateMetadata(ctx, m)
+	})
+}
+
+func (i *instrumentedMetadataStore) UpdateMetadata(ctx context.Context, m *Metadata) error {
+	return instrumentCall(ctx, i.store, "UpdateMetadata", m.Name, func(ctx context.Context) error {
+		return i.s.UpdateMetadata(ctx, m)
+	})
+}
+
+func (i *instrumentedMetadataStore) DeleteMetadata(ctx context.Context, name string, dropTables bool) error {
+	return instrumentCall(ctx, i.store, "DeleteMetadata", name, func(ctx context.Context) error {
+		return i.s.DeleteMetadata(ctx, name, dropTables)
+	})
+}
+
+type instrumentedTrajectoryStore struct {
+	s     TrajectoryStore
+	store string
+}
+
+func (i *instrumentedTrajectoryStore) Cars(ctx context.Context, t RecordTables, q StepQuery, b BBox) ([]*CarV2, error) {
+	return trajectoryQuery(ctx, i.store, "Cars", t.Name, func(ctx context.Context) ([]*CarV2, error) {
+		return i.s.Cars(ctx, t, q, b)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) People(ctx context.Context, t RecordTables, q StepQuery, b BBox) ([]*Person, error) {
+	return trajectoryQuery(ctx, i.store, "People", t.Name, func(ctx context.Context) ([]*Person, error) {
+		return i.s.People(ctx, t, q, b)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) TrafficLights(ctx context.Context, t RecordTables, ids []int, q StepQuery) ([]*TrafficLight, error) {
+	return trajectoryQuery(ctx, i.store, "TrafficLights", t.Name, func(ctx context.Context) ([]*TrafficLight, error) {
+		return i.s.TrafficLights(ctx, t, ids, q)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) RoadStatus(ctx context.Context, t RecordTables, q StepQuery) ([]*RoadStatus, error) {
+	return trajectoryQuery(ctx, i.store, "RoadStatus", t.Name, func(ctx context.Context) ([]*RoadStatus, error) {
+		return i.s.RoadStatus(ctx, t, q)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) RoadStatusOf(ctx context.Context, t RecordTables, ids []int, q StepQuery) ([]*RoadStatus, error) {
+	return trajectoryQuery(ctx, i.store, "RoadStatusOf", t.Name, func(ctx context.Context) ([]*RoadStatus, error) {
+		return i.s.RoadStatusOf(ctx, t, ids, q)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) RoadStatusStats(ctx context.Context, t RecordTables, q StepQuery, emit func(RoadStatusStat) error) error {
+	return instrument(ctx, i.store, "RoadStatusStats", t.Name, func(ctx context.Context) (int, error) {
+		rows := 0
+		err := i.s.RoadStatusStats(ctx, t, q, func(s RoadStatusStat) error {
+			rows++
+			return emit(s)
+		})
+		return rows, err
+	})
+}
+
+func (i *instrumentedTrajectoryStore) CarTrajectory(ctx context.Context, t RecordTables, id int, q StepQuery) ([]*CarV2, error) {
+	return trajectoryQuery(ctx, i.store, "CarTrajectory", t.Name, func(ctx context.Context) ([]*CarV2, error) {
+		return i.s.CarTrajectory(ctx, t, id, q)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) PersonTrajectory(ctx context.Context, t RecordTables, id int, q StepQuery) ([]*Person, error) {
+	return trajectoryQuery(ctx, i.store, "PersonTrajectory", t.Name, func(ctx context.Context) ([]*Person, error) {
+		return i.s.PersonTrajectory(ctx, t, id, q)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) LaneCarStats(ctx context.Context, t RecordTables, begin, end, bucket int) ([]*LaneCarStat, error) {
+	return trajectoryQuery(ctx, i.store, "LaneCarStats", t.Name, func(ctx context.Context) ([]*LaneCarStat, error) {
+		return i.s.LaneCarStats(ctx, t, begin, end, bucket)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) CarStepStats(ctx context.Context, t RecordTables, begin, end int) ([]*CarStepStat, error) {
+	return trajectoryQuery(ctx, i.store, "CarStepStats", t.Name, func(ctx context.Context) ([]*CarStepStat, error) {
+		return i.s.CarStepStats(ctx, t, begin, end)
+	})
+}
+
+func (i *instrumentedTrajectoryStore) TrafficLightChanges(ctx context.Context, t RecordTables, begin, end int) ([]*TrafficLight, error) {
+	return trajectoryQuery(ctx, i.store, "TrafficLightChanges", t.Name, func(ctx context.Context) ([]*TrafficLight, error) {
+		return i.s.TrafficLightChanges(ctx, t, begin, end)
+	})
+}
+
+type instrumentedMapStore struct {
+	s     MapStore
+	store string
+}
+
+func (i *instrumentedMapStore) List(ctx context.Context) ([]string, error) {
+	return instrumentRows(ctx, i.store, "List", "", func(ctx context.Context) ([]string, error) {
+		return i.s.List(ctx)
+	})
+}
+
+func (i *instrumentedMapStore) Header(ctx context.Context, mapPath string) (h *MapHeader, err error) {
+	err = instrumentCall(ctx, i.store, "Header", mapPath, func(ctx context.Context) error {
+		h, err = i.s.Header(ctx, mapPath)
+		return err
+	})
+	return
+}
+
+func (i *instrumentedMapStore) Lanes(ctx context.Context, mapPath string, f LaneFilter) ([]*MapLane, error) {
+	return instrumentRows(ctx, i.store, "Lanes", mapPath, func(ctx context.Context) ([]*MapLane, error) {
+		return i.s.Lanes(ctx, mapPath, f)
+	})
+}
+
+func (i *instrumentedMapStore) Roads(ctx context.Context, mapPath string) ([]*MapRoad, error) {
+	return instrumentRows(ctx, i.store, "Roads", mapPath, func(ctx context.Context) ([]*MapRoad, error) {
+		return i.s.Roads(ctx, mapPath)
+	})
+}
+
+func (i *instrumentedMapStore) Junctions(ctx context.Context, mapPath string) ([]*MapJunction, error) {
+	return instrumentRows(ctx, i.store, "Junctions", mapPath, func(ctx context.Context) ([]*MapJunction, error) {
+		return i.s.Junctions(ctx, mapPath)
+	})
+}
+
+func (i *instrumentedMapStore) Aois(ctx context.Context, mapPath string, box *XYBox) ([]*MapAoi, error) {
+	return instrumentRows(ctx, i.store, "Aois", mapPath, func(ctx context.Context) ([]*MapAoi, error) {
+		return i.s.Aois(ctx, mapPath, box)
+	})
+}
@@ -0,0 +1,481 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"git.fiblab.net/sim/backend/util"
+	"git.fiblab.net/utils/lens"
+	"github.com/jackc/pgx/v4"
+	"github.com/samber/lo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 基于PostgreSQL（元数据与轨迹）和MongoDB（地图）的存储，使用lens中的默认连接
+// Storage on PostgreSQL (metadata and trajectories) and MongoDB (maps) with the default connections in lens
+func newPgStorage() *Storage {
+	return &Storage{
+		Meta:       &pgMetadataStore{},
+		Trajectory: &pgTrajectoryStore{},
+		Map:        &mongoMapStore{},
+	}
+}
+
+type pgMetadataStore struct{}
+
+func (s *pgMetadataStore) Migrate(ctx context.Context) error {
+	adds := lo.Map(metaAccessColumns, func(col string, _ int) string { return "ADD COLUMN IF NOT EXISTS " + col + " TEXT" })
+	if _, err := lens.DefaultPg().Exec(ctx, "ALTER TABLE meta_simple "+strings.Join(adds, ", ")); util.CheckIsTableNotFound(err) {
+		// 表不存在，没有需要迁移的数据 the table does not exist, there is nothing to migrate
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err := lens.DefaultPg().Exec(ctx, createMetaNameIndexSQL)
+	return err
+}
+
+func (s *pgMetadataStore) QueryMetadata(ctx context.Context, name *string) ([]*Metadata, error) {
+	var where string
+	var args []any
+	if name == nil {
+		where = ""
+	} else {
+		where = "NAME=$1"
+		args = []any{*name}
+	}
+	rows, err := lens.DefaultPg().Query(
+		ctx,
+		metaTool.BuildSelectSQL("meta_simple", where, nil),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := make([]*Metadata, 0)
+	for rows.Next() {
+		one := &Metadata{}
+		if err := metaTool.Scan(rows, one); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *pgMetadataStore) CreateMetadata(ctx context.Context, m *Metadata) error {
+	_, err := metaTool.InsertOne(lens.DefaultPg(), ctx, "meta_simple", m)
+	return err
+}
+
+func (s *pgMetadataStore) UpdateMetadata(ctx context.Context, m *Metadata) error {
+	// 第1个参数为name，与Flatten的顺序一致 $1 is the name, the same order as Flatten
+	sets := make([]string, 0, len(metaTool.ColumnNames))
+	for i, col := range metaTool.ColumnNames {
+		if col != "name" {
+			sets = append(sets, fmt.Sprintf("%s=$%d", col, i+1))
+		}
+	}
+	_, err := lens.DefaultPg().Exec(
+		ctx,
+		fmt.Sprintf("UPDATE meta_simple SET %s WHERE name=$1", strings.Join(sets, ",")),
+		metaTool.Flatten(m)...,
+	)
+	return err
+}
+
+func (s *pgMetadataStore) DeleteMetadata(ctx context.Context, name string, dropTables bool) error {
+	tx, err := lens.DefaultPg().Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "DELETE FROM meta_simple WHERE name=$1", name); err != nil {
+		return err
+	}
+	if dropTables {
+		for _, suffix := range recordSuffixes {
+			if _, err := tx.Exec(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{name + suffix}.Sanitize()); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+type pgTrajectoryStore struct{}
+
+// 按step查询PostgreSQL表，语义与lens.QueryPgTableWithStep一致
+// Query a PostgreSQL table by step with the same semantics as lens.QueryPgTableWithStep
+func queryPgWithStep[T any, PT interface {
+	lens.IHasStep
+	*T
+}](
+	ctx context.Context, t RecordTables, kind recordKind, q StepQuery,
+	extraWhere string, extraArgs []any,
+) ([]PT, error) {
+	from, err := t.from(kind)
+	if err != nil {
+		return nil, err
+	}
+	columns, _ := dbColumns(PT(new(T)))
+	query, args := stepQuerySQL(from, columns, q, extraWhere, extraArgs, "$")
+	rows, err := lens.DefaultPg().Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := make([]PT, 0)
+	for rows.Next() {
+		var one PT = new(T)
+		_, ptrs := dbColumns(one)
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return selectSteps(all, q), nil
+}
+
+const pgBBoxWhere = "lat>=$1 AND lat<$2 AND lng>=$3 AND lng<$4"
+
+func pgBBoxArgs(b BBox) []any {
+	return []any{b.MinLat, b.MaxLat, b.MinLng, b.MaxLng}
+}
+
+func (s *pgTrajectoryStore) Cars(ctx context.Context, t RecordTables, q StepQuery, b BBox) ([]*CarV2, error) {
+	return queryPgWithStep[CarV2](ctx, t, recordCars, q, pgBBoxWhere, pgBBoxArgs(b))
+}
+
+func (s *pgTrajectoryStore) People(ctx context.Context, t RecordTables, q StepQuery, b BBox) ([]*Person, error) {
+	return queryPgWithStep[Person](ctx, t, recordPeople, q, pgBBoxWhere, pgBBoxArgs(b))
+}
+
+func (s *pgTrajectoryStore) TrafficLights(ctx context.Context, t RecordTables, ids []int, q StepQuery) ([]*TrafficLight, error) {
+	if len(ids) == 0 {
+		return []*TrafficLight{}, nil
+	}
+	return queryPgWithStep[TrafficLight](ctx, t, recordTrafficLights, q, "id=ANY($1)", []any{ids})
+}
+
+func (s *pgTrajectoryStore) RoadStatus(ctx context.Context, t RecordTables, q StepQuery) ([]*RoadStatus, error) {
+	return queryPgWithStep[RoadStatus](ctx, t, recordRoadStatus, q, "", nil)
+}
+
+func (s *pgTrajectoryStore) RoadStatusOf(ctx context.Context, t RecordTables, ids []int, q StepQuery) ([]*RoadStatus, error) {
+	if len(ids) == 0 {
+		return []*RoadStatus{}, nil
+	}
+	return queryPgWithStep[RoadStatus](ctx, t, recordRoadStatus, q, "id=ANY($1)", []any{ids})
+}
+
+func (s *pgTrajectoryStore) RoadStatusStats(ctx context.Context, t RecordTables, q StepQuery, emit func(RoadStatusStat) error) error {
+	selector := newRoadStatusStatSelector(q, emit)
+	from, err := t.from(recordRoadStatus)
+	if err != nil {
+		return err
+	}
+	rows, err := lens.DefaultPg().Query(ctx, roadStatusStatsSQL(from, "$"), q.Begin-selector.lookBack(), q.End)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if err := scanRoadStatusStats(rows, selector); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return selector.finish()
+}
+
+func (s *pgTrajectoryStore) CarTrajectory(ctx context.Context, t RecordTables, id int, q StepQuery) ([]*CarV2, error) {
+	return queryPgWithStep[CarV2](ctx, t, recordCars, q, "id=$1", []any{id})
+}
+
+func (s *pgTrajectoryStore) PersonTrajectory(ctx context.Context, t RecordTables, id int, q StepQuery) ([]*Person, error) {
+	return queryPgWithStep[Person](ctx, t, recordPeople, q, "id=$1", []any{id})
+}
+
+func (s *pgTrajectoryStore) LaneCarStats(ctx context.Context, t RecordTables, begin, end, bucket int) ([]*LaneCarStat, error) {
+	from, err := t.from(recordCars)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := lens.DefaultPg().Query(ctx, laneCarStatsSQL(from, "$"), begin, end, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := make([]*LaneCarStat, 0)
+	for rows.Next() {
+		one := &LaneCarStat{}
+		if err := rows.Scan(one.scanTargets()...); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *pgTrajectoryStore) TrafficLightChanges(ctx context.Context, t RecordTables, begin, end int) ([]*TrafficLight, error) {
+	from, err := t.from(recordTrafficLights)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := lens.DefaultPg().Query(ctx, trafficLightChangesSQL(from, "$"), begin, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := make([]*TrafficLight, 0)
+	for rows.Next() {
+		one := &TrafficLight{}
+		if err := rows.Scan(&one.Step, &one.Id, &one.State); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+func (s *pgTrajectoryStore) CarStepStats(ctx context.Context, t RecordTables, begin, end int) ([]*CarStepStat, error) {
+	from, err := t.from(recordCars)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := lens.DefaultPg().Query(ctx, carStepStatsSQL(from, "$"), begin, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	all := make([]*CarStepStat, 0)
+	for rows.Next() {
+		one := &CarStepStat{}
+		if err := rows.Scan(&one.Step, &one.Vehicles, &one.MeanV); err != nil {
+			return nil, err
+		}
+		all = append(all, one)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+type mongoMapStore struct{}
+
+func (s *mongoMapStore) collection(mapPath string) (*mongo.Collection, error) {
+	db, col, err := splitMapPath(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	return lens.DefaultMongo().Client().Database(db).Collection(col), nil
+}
+
+// 配置的MongoDB数据库中有header文档的集合 Collections with a header document in the configured MongoDB database
+func (s *mongoMapStore) List(ctx context.Context) ([]string, error) {
+	db := lens.DefaultMongo()
+	names, err := db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	maps := make([]string, 0, len(names))
+	for _, name := range names {
+		n, err := db.Collection(name).CountDocuments(ctx, bson.M{"class": "header"}, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			maps = append(maps, db.Name()+"."+name)
+		}
+	}
+	sort.Strings(maps)
+	return maps, nil
+}
+
+func (s *mongoMapStore) Header(ctx context.Context, mapPath string) (*MapHeader, error) {
+	col, err := s.collection(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	header := col.FindOne(ctx, bson.M{"class": "header"})
+	if errors.Is(header.Err(), mongo.ErrNoDocuments) {
+		return nil, newMapNotFound(mapPath)
+	} else if header.Err() != nil {
+		return nil, header.Err()
+	}
+	h := &MapHeader{}
+	if err := header.Decode(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// 节点的范围与box重叠，作为精确相交判断前可使用索引的预筛选，nodes为节点数组的字段路径。
+// 对数组字段的范围条件可由不同的元素分别满足，即min(x) <= MaxX且max(x) >= MinX，可使用nodes.x与nodes.y的多键索引
+// The bound of the nodes overlaps the box, an indexable prefilter before the exact intersection test in Go, nodes is
+// the field path of the node array. The range conditions on an array field can be met by different elements, i.e.
+// min(x) <= MaxX and max(x) >= MinX, so a multikey index on nodes.x and nodes.y can be used
+func mongoBoundOverlaps(nodes string, box *XYBox) []bson.E {
+	return []bson.E{
+		{Key: nodes + ".x", Value: bson.D{{Key: "$gte", Value: box.MinX}, {Key: "$lte", Value: box.MaxX}}},
+		{Key: nodes + ".y", Value: bson.D{{Key: "$gte", Value: box.MinY}, {Key: "$lte", Value: box.MaxY}}},
+	}
+}
+
+func (s *mongoMapStore) Lanes(ctx context.Context, mapPath string, f LaneFilter) ([]*MapLane, error) {
+	col, err := s.collection(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	var parentIDFilter bson.D
+	switch f.Parent {
+	case AllLane:
+		parentIDFilter = bson.D{{Key: "$exists", Value: true}}
+	case JunctionLane:
+		parentIDFilter = bson.D{{Key: "$gte", Value: 300000000}}
+	case RoadLane:
+		parentIDFilter = bson.D{{Key: "$lt", Value: 300000000}}
+	default:
+		return nil, errors.New("unknown lane type")
+	}
+	match := bson.D{
+		{Key: "class", Value: "lane"},
+		{Key: "data.parent_id", Value: parentIDFilter},
+	}
+	if f.Type != nil {
+		match = append(match, bson.E{Key: "data.type", Value: *f.Type})
+	}
+	if f.MinMaxSpeed != nil {
+		match = append(match, bson.E{Key: "data.max_speed", Value: bson.D{{Key: "$gte", Value: *f.MinMaxSpeed}}})
+	}
+	if f.Box != nil {
+		match = append(match, mongoBoundOverlaps("data.center_line.nodes", f.Box)...)
+	}
+	cur, err := col.Aggregate(ctx, bson.A{
+		bson.D{{Key: "$match", Value: match}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "id", Value: "$data.id"},
+			{Key: "line", Value: "$data.center_line.nodes"},
+			{Key: "type", Value: "$data.type"},
+			{Key: "turn", Value: "$data.turn"},
+			{Key: "parent_id", Value: "$data.parent_id"},
+			{Key: "max_speed", Value: "$data.max_speed"},
+			{Key: "width", Value: "$data.width"},
+			{Key: "predecessors", Value: "$data.predecessors"},
+			{Key: "successors", Value: "$data.successors"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var lanes []*MapLane
+	if err := cur.All(ctx, &lanes); err != nil {
+		return nil, err
+	}
+	if f.Box != nil {
+		lanes = lo.Filter(lanes, func(l *MapLane, _ int) bool { return f.Box.IntersectsLine(l.Line) })
+	}
+	return lanes, nil
+}
+
+func (s *mongoMapStore) Roads(ctx context.Context, mapPath string) ([]*MapRoad, error) {
+	col, err := s.collection(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := col.Aggregate(ctx, bson.A{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "class", Value: "road"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "id", Value: "$data.id"},
+			{Key: "name", Value: "$data.name"},
+			{Key: "lane_ids", Value: "$data.lane_ids"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var roads []*MapRoad
+	if err := cur.All(ctx, &roads); err != nil {
+		return nil, err
+	}
+	return roads, nil
+}
+
+func (s *mongoMapStore) Junctions(ctx context.Context, mapPath string) ([]*MapJunction, error) {
+	col, err := s.collection(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := col.Aggregate(ctx, bson.A{
+		bson.D{{Key: "$match", Value: bson.D{
+			{Key: "class", Value: "junction"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "id", Value: "$data.id"},
+			{Key: "lane_ids", Value: "$data.lane_ids"},
+			{Key: "driving_lane_groups", Value: "$data.driving_lane_groups"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var junctions []*MapJunction
+	if err := cur.All(ctx, &junctions); err != nil {
+		return nil, err
+	}
+	return junctions, nil
+}
+
+func (s *mongoMapStore) Aois(ctx context.Context, mapPath string, box *XYBox) ([]*MapAoi, error) {
+	col, err := s.collection(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	match := bson.D{
+		{Key: "class", Value: "aoi"},
+		{Key: "data.area", Value: bson.D{{Key: "$exists", Value: true}}},
+	}
+	if box != nil {
+		match = append(match, mongoBoundOverlaps("data.positions", box)...)
+	}
+	cur, err := col.Aggregate(ctx, bson.A{
+		bson.D{{Key: "$match", Value: match}},
+		bson.D{{Key: "$project", Value: bson.D{
+			{Key: "id", Value: "$data.id"},
+			{Key: "positions", Value: "$data.positions"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var aois []*MapAoi
+	if err := cur.All(ctx, &aois); err != nil {
+		return nil, err
+	}
+	if box != nil {
+		aois = lo.Filter(aois, func(a *MapAoi, _ int) bool { return box.IntersectsPolygon(a.Positions) })
+	}
+	return aois, nil
+}
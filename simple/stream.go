@@ -0,0 +1,340 @@
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// 每次从数据库预取的step数 Number of steps prefetched from the database at a time
+	streamChunkSteps = 20
+	// 播放倍速上限 Max playback speed
+	streamMaxSpeed = 100.0
+)
+
+var upgrader = websocket.Upgrader{
+	// 与lens中的CORS配置保持一致，允许所有来源
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type streamParam struct {
+	Start *int     `form:"start"` // 起始step，默认为模拟起始step Start step, default to the start of the simulation
+	Speed *float64 `form:"speed"` // 播放倍速，默认为1 Playback speed, default to 1
+	Lng1  *float64 `form:"lng1"`
+	Lng2  *float64 `form:"lng2"`
+	Lat1  *float64 `form:"lat1"`
+	Lat2  *float64 `form:"lat2"`
+}
+
+func (p *streamParam) Check() error {
+	if p.Speed != nil && (*p.Speed <= 0 || *p.Speed > streamMaxSpeed) {
+		return errors.New("speed should be in (0, 100]")
+	}
+	return nil
+}
+
+// 客户端消息 Client message
+type streamCommand struct {
+	Type  string   `json:"type"`  // pause/resume/seek/speed/bbox
+	Step  *int     `json:"step"`  // seek
+	Speed *float64 `json:"speed"` // speed
+	Lng1  *float64 `json:"lng1"`  // bbox
+	Lng2  *float64 `json:"lng2"`
+	Lat1  *float64 `json:"lat1"`
+	Lat2  *float64 `json:"lat2"`
+}
+
+// 服务端消息 Server message
+type streamMessage struct {
+	Type          string          `json:"type"` // frame/state/end/error
+	Step          int             `json:"step"`
+	Speed         float64         `json:"speed,omitempty"`
+	Paused        bool            `json:"paused,omitempty"`
+	Error         string          `json:"error,omitempty"`
+	Cars          []*CarV2        `json:"cars,omitempty"`
+	People        []*Person       `json:"people,omitempty"`
+	TrafficLights []*TrafficLight `json:"trafficLights,omitempty"`
+	RoadStatus    []*RoadStatus   `json:"roadStatus,omitempty"`
+}
+
+type streamFrame struct {
+	cars          []*CarV2
+	people        []*Person
+	trafficLights []*TrafficLight
+	roadStatus    []*RoadStatus
+}
+
+// 回放会话 Playback session
+type streamSession struct {
+	ctx    context.Context
+	meta   *Metadata
+	step   int
+	speed  float64
+	paused bool
+	bbox   BBox
+	// 预取的帧 Prefetched frames, key: step
+	frames map[int]*streamFrame
+}
+
+func (s *streamSession) end() int {
+	return s.meta.Start + s.meta.Steps
+}
+
+// 预取[from, from+streamChunkSteps)范围的帧
+// Prefetch frames in [from, from+streamChunkSteps)
+func (s *streamSession) prefetch(from int) error {
+	to := from + streamChunkSteps
+	if to > s.end() {
+		to = s.end()
+	}
+	cars, err := queryCarsV2(s.ctx, s.meta.tables(), from, to, 1, s.bbox)
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		return err
+	}
+	people, err := queryPeople(s.ctx, s.meta.tables(), from, to, 1, s.bbox)
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		return err
+	}
+	tls, err := queryTrafficLights(s.ctx, s.meta, from, to, 1, s.bbox)
+	if err != nil && !util.CheckIsTableNotFound(err) {
+		return err
+	}
+	var roads []*RoadStatus
+	if s.meta.RoadStatusInterval != nil {
+		roads, err = queryRoadStatus(s.ctx, s.meta.tables(), from, to, *s.meta.RoadStatusInterval, 1)
+		if err != nil && !util.CheckIsTableNotFound(err) {
+			return err
+		}
+	}
+	s.frames = make(map[int]*streamFrame, to-from)
+	for step := from; step < to; step++ {
+		s.frames[step] = &streamFrame{}
+	}
+	for _, one := range cars {
+		s.frames[one.Step].cars = append(s.frames[one.Step].cars, one)
+	}
+	for _, one := range people {
+		s.frames[one.Step].people = append(s.frames[one.Step].people, one)
+	}
+	for _, one := range tls {
+		s.frames[one.Step].trafficLights = append(s.frames[one.Step].trafficLights, one)
+	}
+	for _, one := range roads {
+		s.frames[one.Step].roadStatus = append(s.frames[one.Step].roadStatus, one)
+	}
+	return nil
+}
+
+func (s *streamSession) frame() (*streamMessage, error) {
+	f, ok := s.frames[s.step]
+	if !ok {
+		if err := s.prefetch(s.step); err != nil {
+			return nil, err
+		}
+		f = s.frames[s.step]
+	}
+	return &streamMessage{
+		Type:          "frame",
+		Step:          s.step,
+		Cars:          f.cars,
+		People:        f.people,
+		TrafficLights: f.trafficLights,
+		RoadStatus:    f.roadStatus,
+	}, nil
+}
+
+func (s *streamSession) state() *streamMessage {
+	return &streamMessage{Type: "state", Step: s.step, Speed: s.speed, Paused: s.paused}
+}
+
+// 单步对应的真实时间间隔 Wall-clock duration of one step
+func (s *streamSession) tick() time.Duration {
+	return time.Duration(s.meta.Time / s.speed * float64(time.Second))
+}
+
+// 处理客户端消息，返回错误时不终止连接
+// Apply a client command, errors are reported back without closing the connection
+func (s *streamSession) apply(cmd *streamCommand) error {
+	switch cmd.Type {
+	case "pause":
+		s.paused = true
+	case "resume":
+		s.paused = false
+	case "seek":
+		if cmd.Step == nil {
+			return errors.New("seek requires step")
+		}
+		if *cmd.Step < s.meta.Start || *cmd.Step >= s.end() {
+			return errors.New("step out of range")
+		}
+		s.step = *cmd.Step
+	case "speed":
+		if cmd.Speed == nil || *cmd.Speed <= 0 || *cmd.Speed > streamMaxSpeed {
+			return errors.New("speed should be in (0, 100]")
+		}
+		s.speed = *cmd.Speed
+	case "bbox":
+		if cmd.Lng1 == nil || cmd.Lng2 == nil || cmd.Lat1 == nil || cmd.Lat2 == nil {
+			return errors.New("bbox requires lng1, lng2, lat1 and lat2")
+		}
+		s.bbox = newStreamBBox(*cmd.Lng1, *cmd.Lng2, *cmd.Lat1, *cmd.Lat2)
+		// 视野改变后预取数据失效
+		s.frames = nil
+	default:
+		return errors.New("unknown command type")
+	}
+	return nil
+}
+
+func newStreamBBox(lng1, lng2, lat1, lat2 float64) BBox {
+	if lng1 > lng2 {
+		lng1, lng2 = lng2, lng1
+	}
+	if lat1 > lat2 {
+		lat1, lat2 = lat2, lat1
+	}
+	return BBox{MinLng: lng1, MinLat: lat1, MaxLng: lng2, MaxLat: lat2}
+}
+
+// @Summary Live playback stream (WebSocket)
+// @Description Push per-step frames with vehicles, pedestrians, traffic lights and road status.
+// @Description Client messages (JSON): {"type":"pause"}, {"type":"resume"}, {"type":"seek","step":N}, {"type":"speed","speed":X}, {"type":"bbox","lng1":..,"lng2":..,"lat1":..,"lat2":..}.
+// @Description Server messages (JSON): type=frame/state/end/error.
+// @Param tablename path string true "Simulation Name"
+// @Param start query number false "the start step of the playback (default is the start of the simulation)"
+// @Param speed query number false "playback speed (default is 1, at most 100)"
+// @Param lat1 query number false "min latitude for filtering (default is the microscopic area)"
+// @Param lat2 query number false "max latitude for filtering"
+// @Param lng1 query number false "min longitude for filtering"
+// @Param lng2 query number false "max longitude for filtering"
+// @Success 101
+// @Router /simple/stream/{tablename} [get]
+func StreamByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	p := util.ValidateParam[streamParam](c)
+	if p == nil {
+		return
+	}
+	meta, ok := simMetadata(c, u.Name)
+	if !ok {
+		return
+	}
+	if meta.Time <= 0 {
+		util.AbortWithError(c, fmt.Errorf("bad step time length %v of simulation %s", meta.Time, meta.Name))
+		return
+	}
+	s := &streamSession{
+		ctx:   c.Request.Context(),
+		meta:  meta,
+		step:  meta.Start,
+		speed: 1,
+		bbox:  newStreamBBox(meta.MinLng, meta.MaxLng, meta.MinLat, meta.MaxLat),
+	}
+	if p.Start != nil {
+		if *p.Start < meta.Start || *p.Start >= s.end() {
+			util.AbortWithError(c, util.NewError(util.CodeBadRequest, "start out of range"))
+			return
+		}
+		s.step = *p.Start
+	}
+	if p.Speed != nil {
+		s.speed = *p.Speed
+	}
+	if p.Lng1 != nil && p.Lng2 != nil && p.Lat1 != nil && p.Lat2 != nil {
+		s.bbox = newStreamBBox(*p.Lng1, *p.Lng2, *p.Lat1, *p.Lat2)
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade已经写入了HTTP错误
+		return
+	}
+	defer conn.Close()
+
+	// 读取客户端消息，写循环退出后关闭done，使读取协程不会阻塞在发送上
+	// read client messages, done is closed when the write loop returns so that the reader never blocks on a send
+	commands := make(chan *streamCommand)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(commands)
+		for {
+			cmd := &streamCommand{}
+			if err := conn.ReadJSON(cmd); err != nil {
+				return
+			}
+			select {
+			case commands <- cmd:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	resetTimer := func(d time.Duration) {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d)
+	}
+	if err := conn.WriteJSON(s.state()); err != nil {
+		return
+	}
+	for {
+		select {
+		case cmd, ok := <-commands:
+			if !ok {
+				// 客户端断开 client disconnected
+				return
+			}
+			if err := s.apply(cmd); err != nil {
+				if err := conn.WriteJSON(&streamMessage{Type: "error", Step: s.step, Error: err.Error()}); err != nil {
+					return
+				}
+				continue
+			}
+			if err := conn.WriteJSON(s.state()); err != nil {
+				return
+			}
+			if !s.paused {
+				resetTimer(0)
+			}
+		case <-timer.C:
+			if s.paused {
+				continue
+			}
+			if s.step >= s.end() {
+				s.paused = true
+				if err := conn.WriteJSON(&streamMessage{Type: "end", Step: s.step}); err != nil {
+					return
+				}
+				continue
+			}
+			msg, err := s.frame()
+			if err != nil {
+				conn.WriteJSON(&streamMessage{Type: "error", Step: s.step, Error: err.Error()})
+				return
+			}
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+			s.step++
+			timer.Reset(s.tick())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package simple
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/simplify"
+)
+
+// 矢量瓦片图层名 Vector tile layer names
+const (
+	TileLayerJunctionLanes = "junction_lanes"
+	TileLayerRoadLanes     = "road_lanes"
+	TileLayerRoads         = "roads"
+	TileLayerAois          = "aois"
+)
+
+const (
+	// 各图层的最小缩放级别 Minimum zoom of each layer
+	tileMinZoomRoad = 10
+	tileMinZoomAoi  = 13
+	tileMinZoomLane = 15
+	tileMaxZoom     = 22
+	// 查询地图时瓦片范围的外扩比例，用于包含节点均在瓦片外但穿过瓦片的车道
+	// Query buffer in tiles to include lanes crossing the tile without nodes inside
+	tileQueryBuffer = 0.5
+)
+
+const tileContentType = "application/vnd.mapbox-vector-tile"
+
+var errBadTile = util.NewError(util.CodeBadRequest, "bad tile coordinate")
+
+func parseTile(c *gin.Context) (maptile.Tile, error) {
+	z, err := strconv.ParseUint(c.Param("z"), 10, 32)
+	if err != nil || z > tileMaxZoom {
+		return maptile.Tile{}, errBadTile
+	}
+	x, err := strconv.ParseUint(c.Param("x"), 10, 32)
+	if err != nil {
+		return maptile.Tile{}, errBadTile
+	}
+	y, err := strconv.ParseUint(strings.TrimSuffix(c.Param("y"), ".mvt"), 10, 32)
+	if err != nil {
+		return maptile.Tile{}, errBadTile
+	}
+	t := maptile.New(uint32(x), uint32(y), maptile.Zoom(z))
+	if !t.Valid() {
+		return maptile.Tile{}, errBadTile
+	}
+	return t, nil
+}
+
+// 按缩放级别确定的化简阈值（瓦片坐标），缩放级别越低化简越多
+// Simplification tolerance in tile coordinates, lower zooms are simplified more
+func tileTolerance(z maptile.Zoom) float64 {
+	if z >= 16 {
+		return 1
+	}
+	return math.Min(16, math.Exp2(float64(16-z)))
+}
+
+func tileFeature(id int32, g orb.Geometry, properties geojson.Properties) *geojson.Feature {
+	feature := geojson.NewFeature(g)
+	feature.ID = id
+	feature.Properties = properties
+	return feature
+}
+
+func laneLayer(name string, lanes []*geoLane) *mvt.Layer {
+	fc := geojson.NewFeatureCollection()
+	for _, l := range lanes {
+		fc.Append(tileFeature(l.ID, l.Line.Clone(), geojson.Properties{"id": l.ID, "type": l.Type}))
+	}
+	return mvt.NewLayer(name, fc)
+}
+
+// 瓦片范围（含查询缓冲区）与微观区域的交集，无交集时返回nil
+// Intersection of the tile (with the query buffer) and the microscopic area, nil if they are disjoint
+func tileQueryBound(t maptile.Tile, meta *Metadata) *orb.Bound {
+	bound := t.Bound(tileQueryBuffer)
+	area := metaBound(meta)
+	if !bound.Intersects(area) {
+		return nil
+	}
+	bound = orb.Bound{
+		Min: orb.Point{math.Max(bound.Min[0], area.Min[0]), math.Max(bound.Min[1], area.Min[1])},
+		Max: orb.Point{math.Min(bound.Max[0], area.Max[0]), math.Min(bound.Max[1], area.Max[1])},
+	}
+	return &bound
+}
+
+// 瓦片的各图层，几何为经纬度坐标 Layers of the tile with geometries in longitude/latitude
+func tileLayers(ctx context.Context, meta *Metadata, t maptile.Tile) (mvt.Layers, error) {
+	layers := mvt.Layers{}
+	if t.Z < tileMinZoomRoad {
+		return layers, nil
+	}
+	g, err := mapGeometries.get(ctx, meta.Map)
+	if err != nil {
+		return nil, err
+	}
+
+	// roads，与GetRoadlaneByName一致 roads, the same as GetRoadlaneByName
+	if meta.RoadStatusVMin != nil {
+		fc := geojson.NewFeatureCollection()
+		for _, rl := range g.outermostRoadLanesIn(*meta.RoadStatusVMin, boundRegion(t.Bound(tileQueryBuffer))) {
+			fc.Append(tileFeature(rl.Road.ID, rl.Lane.Line.Clone(), geojson.Properties{"id": rl.Road.ID}))
+		}
+		layers = append(layers, mvt.NewLayer(TileLayerRoads, fc))
+	}
+
+	box := tileQueryBound(t, meta)
+	if box == nil || t.Z < tileMinZoomAoi {
+		return layers, nil
+	}
+	r := boundRegion(*box)
+	// aois
+	fc := geojson.NewFeatureCollection()
+	for _, a := range g.aois(r) {
+		fc.Append(tileFeature(a.ID, a.Polygon.Clone(), geojson.Properties{"id": a.ID}))
+	}
+	layers = append(layers, mvt.NewLayer(TileLayerAois, fc))
+
+	if t.Z < tileMinZoomLane {
+		return layers, nil
+	}
+	// lanes
+	layers = append(layers,
+		laneLayer(TileLayerJunctionLanes, g.lanes(JunctionLane, r)),
+		laneLayer(TileLayerRoadLanes, g.lanes(RoadLane, r)),
+	)
+	return layers, nil
+}
+
+// @Summary Load Mapbox Vector Tile of lanes, roads and AOIs
+// @Description Layers: roads (z>=10, the outermost driving lane of each road as in /simple/roadlane),
+// @Description aois (z>=13), junction_lanes and road_lanes (z>=15, in microscopic area)
+// @Produce application/vnd.mapbox-vector-tile
+// @Param tablename path string true "Simulation Name"
+// @Param z path int true "Zoom"
+// @Param x path int true "Tile X"
+// @Param y path string true "Tile Y with .mvt suffix"
+// @Success 200
+// @Router /simple/tiles/{tablename}/{z}/{x}/{y} [get]
+func GetTileByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	t, err := parseTile(c)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+
+	meta, ok := findSim(c, u.Name)
+	if !ok {
+		return
+	}
+	layers, err := tileLayers(c.Request.Context(), meta, t)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	// 投影到瓦片坐标后裁剪、化简 project into tile coordinates, then clip and simplify
+	layers.ProjectToTile(t)
+	layers.Clip(mvt.MapboxGLDefaultExtentBound)
+	layers.Simplify(simplify.DouglasPeucker(tileTolerance(t.Z)))
+	// 压缩由gzip中间件按Accept-Encoding协商 the gzip middleware negotiates the compression by Accept-Encoding
+	data, err := mvt.Marshal(layers)
+	if err != nil {
+		util.AbortWithError(c, fmt.Errorf("encode tile: %w", err))
+		return
+	}
+	c.Data(200, tileContentType, data)
+}
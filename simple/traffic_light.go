@@ -1,16 +1,23 @@
 package simple
 
 import (
+	"context"
+
 	"git.fiblab.net/sim/backend/util"
 	"git.fiblab.net/utils/lens"
 	"git.fiblab.net/utils/pgxtool"
 	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/samber/lo"
 )
 
 type TrafficLight struct {
 	Step  int `json:"step" db:"step"`
 	Id    int `json:"id" db:"id"`       // 车道ID
 	State int `json:"state" db:"state"` // 信控状态（0无/1红/2绿/3黄）
+	// 车道停车线（中心线起点）的位置，由地图填写 Position of the stop line (the first node of the center line) of the lane, filled from the map
+	Lng float64 `json:"lng"`
+	Lat float64 `json:"lat"`
 }
 
 func (t *TrafficLight) GetStep() int {
@@ -27,7 +34,31 @@ var (
 	tlTool = pgxtool.New(&TrafficLight{})
 )
 
+// 查询中心线与范围相交的车道上的信号灯，并填写停车线位置
+// Query the traffic lights on the lanes whose center line intersects the bbox and fill the stop line positions
+func queryTrafficLights(ctx context.Context, meta *Metadata, begin, end, interval int, b BBox) ([]*TrafficLight, error) {
+	g, err := mapGeometries.get(ctx, meta.Map)
+	if err != nil {
+		return nil, err
+	}
+	box := orb.Bound{Min: orb.Point{b.MinLng, b.MinLat}, Max: orb.Point{b.MaxLng, b.MaxLat}}
+	ids := lo.FilterMap(g.lanes(AllLane, boundRegion(box)), func(l *geoLane, _ int) (int, bool) {
+		return int(l.ID), len(l.Line) > 0
+	})
+	all, err := storage.Trajectory.TrafficLights(ctx, meta.tables(), ids, StepQuery{begin, end, 1, interval})
+	if err != nil {
+		return nil, err
+	}
+	for _, one := range all {
+		stopLine := g.laneByID[int32(one.Id)].Line[0]
+		one.Lng = util.ToFixed(stopLine[0], 8)
+		one.Lat = util.ToFixed(stopLine[1], 8)
+	}
+	return all, nil
+}
+
 // @Summary Get Traffic Lights
+// @Description Traffic lights on the lanes whose center line intersects the bbox, with the stop line position of the lane
 // @Produce application/json
 // @Param tablename path string true "Simulation Name"
 // @Param begin query number true "the start step of the data"
@@ -40,23 +71,25 @@ var (
 // @Success 200 object util.Response{data=[]TrafficLight} "successful operation"
 // @Router /simple/traffic-lights/{tablename} [get]
 func GetTrafficLightByName(c *gin.Context) {
-	u := lens.ValidateUri(c)
+	u := util.ValidateUri(c)
 	if u == nil {
 		return
 	}
-	s := lens.ValidateParam[lens.StepCoordinate](c)
+	s := util.ValidateParam[lens.StepCoordinate](c)
 	if s == nil {
 		return
 	}
 
-	all, err := lens.QueryPgTableWithStep[TrafficLight](
-		tlTool, u.Name+"_s_traffic_light",
-		*s.Begin, *s.End, 1, 0, *s.Interval,
-		"LAT>=$1 AND LAT<$2 AND LNG>=$3 AND LNG<$4",
-		[]any{*s.Lat1, *s.Lat2, *s.Lng1, *s.Lng2},
-	)
+	meta, ok := simMetadata(c, u.Name)
+	if !ok {
+		return
+	}
+	all, err := queryTrafficLights(c.Request.Context(), meta, *s.Begin, *s.End, *s.Interval, bboxOf(s))
+	if util.ResponseEmptyIfTableNotFound(c, all, err) {
+		return
+	}
 	if err != nil {
-		c.JSON(500, util.NewErrorResponse(err))
+		util.AbortWithError(c, err)
 		return
 	}
 
@@ -0,0 +1,171 @@
+package simple
+
+import (
+	"fmt"
+	"sort"
+
+	"git.fiblab.net/sim/backend/util"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+)
+
+// 绿灯状态 Green state of TrafficLight.State
+const trafficLightGreen = 2
+
+// 参数占位符：1为begin，2为end；placeholder为"$"（PostgreSQL）或"?"（SQLite），from为RecordTables.from的表
+// Parameters: 1 for begin, 2 for end; placeholder is "$" (PostgreSQL) or "?" (SQLite),
+// from is the table given by RecordTables.from
+func trafficLightChangesSQL(from string, placeholder string) string {
+	return fmt.Sprintf(
+		"SELECT step, id, state FROM ("+
+			"SELECT step, id, state, LAG(state) OVER (PARTITION BY id ORDER BY step) AS prev "+
+			"FROM %[1]s WHERE step >= %[2]s1 AND step < %[2]s2"+
+			") changes WHERE prev IS NULL OR prev <> state ORDER BY id, step",
+		from, placeholder,
+	)
+}
+
+// 信号灯保持同一状态的时间段 A time span in which the traffic light keeps the same state
+type TrafficLightPhase struct {
+	State    int     `json:"state"`    // 信控状态（0无/1红/2绿/3黄） State (0 none/1 red/2 green/3 yellow)
+	Begin    int     `json:"begin"`    // step>=begin
+	End      int     `json:"end"`      // step<end
+	Duration float64 `json:"duration"` // 时长（秒） Duration (second)
+}
+
+type TrafficLightLanePhases struct {
+	LaneId     int                 `json:"laneId"`     // 车道ID Lane ID
+	GreenSplit float64             `json:"greenSplit"` // 绿灯时长占比 Ratio of the green time
+	Phases     []TrafficLightPhase `json:"phases"`
+}
+
+// 路口的信号配时 Signal plan of a junction
+type JunctionSignalPlan struct {
+	JunctionId int `json:"junctionId"` // 路口ID Junction ID
+	// 信号周期（秒），取各车道相邻两次绿灯开始间隔的中位数，不足一个周期时为null
+	// Cycle length (second), the median of the steps between two successive green onsets of the lanes,
+	// null if no complete cycle is observed
+	CycleLength *float64                  `json:"cycleLength"`
+	Lanes       []*TrafficLightLanePhases `json:"lanes"`
+}
+
+// 将按车道ID与step排列的状态变化点展开为各车道的时间段，最后一段到end结束
+// Expand the state changes ordered by lane ID and step into the phases of each lane, the last phase ends at end
+func trafficLightPhases(changes []*TrafficLight, end int, stepTime float64) []*TrafficLightLanePhases {
+	lanes := make([]*TrafficLightLanePhases, 0)
+	for i, one := range changes {
+		if i == 0 || changes[i-1].Id != one.Id {
+			lanes = append(lanes, &TrafficLightLanePhases{LaneId: one.Id, Phases: make([]TrafficLightPhase, 0)})
+		}
+		phaseEnd := end
+		if i+1 < len(changes) && changes[i+1].Id == one.Id {
+			phaseEnd = changes[i+1].Step
+		}
+		lane := lanes[len(lanes)-1]
+		lane.Phases = append(lane.Phases, TrafficLightPhase{
+			State:    one.State,
+			Begin:    one.Step,
+			End:      phaseEnd,
+			Duration: float64(phaseEnd-one.Step) * stepTime,
+		})
+	}
+	for _, lane := range lanes {
+		total, green := 0, 0
+		for _, p := range lane.Phases {
+			total += p.End - p.Begin
+			if p.State == trafficLightGreen {
+				green += p.End - p.Begin
+			}
+		}
+		if total > 0 {
+			lane.GreenSplit = util.ToFixed(float64(green)/float64(total), 4)
+		}
+	}
+	return lanes
+}
+
+// 各车道相邻两次绿灯开始的间隔的中位数（step），第一个时间段的开始不算作绿灯开始
+// Median steps between two successive green onsets of the lanes, the beginning of the first phase is not an onset
+func signalCycleSteps(lanes []*TrafficLightLanePhases) (int, bool) {
+	cycles := make([]int, 0)
+	for _, lane := range lanes {
+		last := -1
+		for i, p := range lane.Phases {
+			if i == 0 || p.State != trafficLightGreen {
+				continue
+			}
+			if last >= 0 {
+				cycles = append(cycles, p.Begin-last)
+			}
+			last = p.Begin
+		}
+	}
+	if len(cycles) == 0 {
+		return 0, false
+	}
+	sort.Ints(cycles)
+	return cycles[len(cycles)/2], true
+}
+
+// @Summary Get the traffic light phases and signal plans of the junctions
+// @Description The per-step states of each lane are compressed into phases of the same state, grouped by the junction of the lane.
+// @Description Lanes not in the map are omitted. The cycle length is derived from the green onsets of the lanes and
+// @Description greenSplit is the ratio of the green time of a lane in [begin, end).
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Success 200 object util.Response{data=[]JunctionSignalPlan} "successful operation"
+// @Router /simple/traffic-light-phases/{tablename} [get]
+func GetTrafficLightPhasesByName(c *gin.Context) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	r := util.ValidateParam[StepRange](c)
+	if r == nil {
+		return
+	}
+	meta, ok := simMetadata(c, u.Name)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	end := lo.Min([]int{*r.End, meta.Start + meta.Steps})
+	changes, err := storage.Trajectory.TrafficLightChanges(ctx, meta.tables(), *r.Begin, end)
+	if util.ResponseEmptyIfTableNotFound(c, []*JunctionSignalPlan{}, err) {
+		return
+	}
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	g, err := mapGeometries.get(ctx, meta.Map)
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+
+	id2Plan := make(map[int]*JunctionSignalPlan)
+	for _, lane := range trafficLightPhases(changes, end, meta.Time) {
+		l := g.laneByID[int32(lane.LaneId)]
+		if l == nil {
+			continue
+		}
+		plan, ok := id2Plan[int(l.ParentID)]
+		if !ok {
+			plan = &JunctionSignalPlan{JunctionId: int(l.ParentID), Lanes: make([]*TrafficLightLanePhases, 0)}
+			id2Plan[plan.JunctionId] = plan
+		}
+		plan.Lanes = append(plan.Lanes, lane)
+	}
+	plans := lo.Values(id2Plan)
+	for _, plan := range plans {
+		if steps, ok := signalCycleSteps(plan.Lanes); ok {
+			cycle := float64(steps) * meta.Time
+			plan.CycleLength = &cycle
+		}
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].JunctionId < plans[j].JunctionId })
+	c.JSON(200, util.NewResponse(plans))
+}
@@ -0,0 +1,162 @@
+package simple
+
+import (
+	"strconv"
+
+	"git.fiblab.net/sim/backend/util"
+	"git.fiblab.net/utils/lens"
+	"github.com/gin-gonic/gin"
+)
+
+// 轨迹点 A point of the trajectory
+type trackPoint struct {
+	Step      int
+	ParentID  int
+	Direction float64
+	Lng       float64
+	Lat       float64
+	Z         float64
+	V         float64
+}
+
+type trajectoryGeometry struct {
+	Type        string       `json:"type"`
+	Coordinates [][4]float64 `json:"coordinates"`
+}
+
+// 轨迹的GeoJSON Feature，坐标为[lng, lat, z, m]，m为该点对应的模拟时间（秒，step*time）
+// GeoJSON Feature of a trajectory, coordinates are [lng, lat, z, m] where m is the simulation time (second, step*time)
+type TrajectoryFeature struct {
+	Type       string             `json:"type"`
+	ID         int                `json:"id"`
+	Geometry   trajectoryGeometry `json:"geometry"`
+	Properties map[string]any     `json:"properties"`
+}
+
+// parentKey为父对象ID属性名（车辆为laneId，行人为parentId）
+// parentKey is the property name of the parent id (laneId for vehicles and parentId for people)
+func newTrajectoryFeature(id int, meta *Metadata, points []trackPoint, parentKey string) *TrajectoryFeature {
+	f := &TrajectoryFeature{
+		Type:     "Feature",
+		ID:       id,
+		Geometry: trajectoryGeometry{Type: "LineString", Coordinates: make([][4]float64, 0, len(points))},
+	}
+	steps := make([]int, len(points))
+	parents := make([]int, len(points))
+	directions := make([]float64, len(points))
+	vs := make([]float64, len(points))
+	for i, p := range points {
+		f.Geometry.Coordinates = append(f.Geometry.Coordinates, [4]float64{p.Lng, p.Lat, p.Z, float64(p.Step) * meta.Time})
+		steps[i] = p.Step
+		parents[i] = p.ParentID
+		directions[i] = p.Direction
+		vs[i] = p.V
+	}
+	if len(f.Geometry.Coordinates) == 1 {
+		// LineString至少需要2个点，静止在单个step的轨迹重复该点
+		// A LineString needs at least 2 positions, repeat the only one
+		f.Geometry.Coordinates = append(f.Geometry.Coordinates, f.Geometry.Coordinates[0])
+	}
+	f.Properties = map[string]any{
+		"id":        id,
+		"step":      steps,
+		parentKey:   parents,
+		"direction": directions,
+		"v":         vs,
+	}
+	return f
+}
+
+// 解析轨迹请求的参数并查询元数据 Parse the parameters of a trajectory request and query the metadata
+func trajectoryParams(c *gin.Context) (id int, s *lens.Step, meta *Metadata, ok bool) {
+	u := util.ValidateUri(c)
+	if u == nil {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		util.AbortWithError(c, util.NewError(util.CodeBadRequest, "id should be an integer"))
+		return
+	}
+	s = util.ValidateParam[lens.Step](c)
+	if s == nil {
+		return
+	}
+	meta, ok = simMetadata(c, u.Name)
+	return id, s, meta, ok
+}
+
+func responseTrajectory[T any](c *gin.Context, all []T, err error, id int, meta *Metadata, parentKey string, toPoint func(T) trackPoint) {
+	if util.ResponseEmptyIfTableNotFound(c, all, err) {
+		return
+	}
+	if err != nil {
+		util.AbortWithError(c, err)
+		return
+	}
+	if c.Query("format") != "geojson" {
+		c.JSON(200, util.NewResponse(all))
+		return
+	}
+	if len(all) == 0 {
+		util.AbortWithError(c, util.NewError(util.CodeNotFound, "no trajectory in the step range").With("id", id))
+		return
+	}
+	points := make([]trackPoint, len(all))
+	for i, one := range all {
+		points[i] = toPoint(one)
+	}
+	c.JSON(200, util.NewResponse(newTrajectoryFeature(id, meta, points, parentKey)))
+}
+
+// @Summary Get the trajectory of a vehicle
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param id path int true "Vehicle ID"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Param interval query number false "Get the interval of the data (default is 1)"
+// @Param format query string false "Response format: json (default, records ordered by step) or geojson (LineString with [lng, lat, z, time] coordinates)"
+// @Success 200 object util.Response{data=[]CarV2} "successful operation"
+// @Router /simple/cars/{tablename}/{id}/trajectory [get]
+func GetCarTrajectoryByName(c *gin.Context) {
+	id, s, meta, ok := trajectoryParams(c)
+	if !ok {
+		return
+	}
+	all, err := storage.Trajectory.CarTrajectory(c.Request.Context(), meta.tables(), id, StepQuery{*s.Begin, *s.End, 1, *s.Interval})
+	for _, one := range all {
+		one.Direction = util.ToFixed(one.Direction, 2)
+		one.Lng = util.ToFixed(one.Lng, 8)
+		one.Lat = util.ToFixed(one.Lat, 8)
+	}
+	responseTrajectory(c, all, err, id, meta, "laneId", func(one *CarV2) trackPoint {
+		return trackPoint{one.Step, one.LaneId, one.Direction, one.Lng, one.Lat, one.Z, one.V}
+	})
+}
+
+// @Summary Get the trajectory of a pedestrian
+// @Produce application/json
+// @Param tablename path string true "Simulation Name"
+// @Param id path int true "Person ID"
+// @Param begin query number true "the start step of the data"
+// @Param end query number true "Get the end step of the data (not included)"
+// @Param interval query number false "Get the interval of the data (default is 1)"
+// @Param format query string false "Response format: json (default, records ordered by step) or geojson (LineString with [lng, lat, z, time] coordinates)"
+// @Success 200 object util.Response{data=[]Person} "successful operation"
+// @Router /simple/people/{tablename}/{id}/trajectory [get]
+func GetPersonTrajectoryByName(c *gin.Context) {
+	id, s, meta, ok := trajectoryParams(c)
+	if !ok {
+		return
+	}
+	all, err := storage.Trajectory.PersonTrajectory(c.Request.Context(), meta.tables(), id, StepQuery{*s.Begin, *s.End, 1, *s.Interval})
+	for _, one := range all {
+		one.Direction = util.ToFixed(one.Direction, 2)
+		one.Lng = util.ToFixed(one.Lng, 8)
+		one.Lat = util.ToFixed(one.Lat, 8)
+	}
+	responseTrajectory(c, all, err, id, meta, "parentId", func(one *Person) trackPoint {
+		return trackPoint{one.Step, one.ParentId, one.Direction, one.Lng, one.Lat, one.Z, one.V}
+	})
+}
@@ -0,0 +1,139 @@
+-- 端到端测试数据 Fixture data for the end-to-end tests
+-- test: 完整的模拟 a complete simulation
+-- old: 第1版DBRecorder的输出，没有路况信息 output of DBRecorder version 1 without road status information
+-- empty: 没有DBRecorder输出表 no DBRecorder output tables
+-- future: 不支持的版本 unsupported version
+-- novmin: 有路况记录间隔但没有road_status_v_min a road status interval without road_status_v_min
+-- private: 属于alice与lab组的模拟，使用单独的地图，没有DBRecorder输出表
+--   simulation of alice and the lab group on its own map without DBRecorder output tables
+
+CREATE TABLE meta_simple (
+    name TEXT NOT NULL,
+    start INT NOT NULL,
+    steps INT NOT NULL,
+    time FLOAT8 NOT NULL,
+    total_agents INT NOT NULL,
+    map TEXT NOT NULL,
+    min_lng FLOAT8 NOT NULL,
+    min_lat FLOAT8 NOT NULL,
+    max_lng FLOAT8 NOT NULL,
+    max_lat FLOAT8 NOT NULL,
+    road_status_v_min FLOAT8,
+    road_status_interval INT,
+    version INT NOT NULL,
+    owner TEXT,
+    group_name TEXT
+);
+INSERT INTO meta_simple VALUES
+    ('test', 0, 10, 1.0, 3, 'moss.test_map', 116.0, 39.9, 116.1, 40.0, 5.0, 5, 2, NULL, NULL),
+    ('old', 0, 10, 1.0, 3, 'moss.test_map', 116.0, 39.9, 116.1, 40.0, NULL, NULL, 1, NULL, NULL),
+    ('empty', 0, 10, 1.0, 0, 'moss.test_map', 116.0, 39.9, 116.1, 40.0, 5.0, 5, 2, NULL, NULL),
+    ('future', 0, 10, 1.0, 0, 'moss.test_map', 116.0, 39.9, 116.1, 40.0, 5.0, 5, 3, NULL, NULL),
+    ('novmin', 0, 10, 1.0, 0, 'moss.test_map', 116.0, 39.9, 116.1, 40.0, NULL, 5, 2, NULL, NULL),
+    ('private', 0, 10, 1.0, 0, 'moss.private_map', 116.0, 39.9, 116.1, 40.0, 5.0, 5, 2, 'alice', 'lab');
+
+CREATE TABLE test_s_cars (
+    step INT NOT NULL,
+    id INT NOT NULL,
+    parent_id INT NOT NULL,
+    direction FLOAT8 NOT NULL,
+    lng FLOAT8 NOT NULL,
+    lat FLOAT8 NOT NULL,
+    model TEXT NOT NULL,
+    z FLOAT8 NOT NULL,
+    pitch FLOAT8 NOT NULL,
+    v FLOAT8 NOT NULL,
+    num_passengers INT NOT NULL
+);
+-- 车辆1在区域内，车辆2在区域外 vehicle 1 is inside the area, vehicle 2 is outside
+INSERT INTO test_s_cars VALUES
+    (0, 1, 1, 0.123, 116.01, 39.91, 'car', 0, 0, 10.0, 1),
+    (1, 1, 1, 0.123, 116.02, 39.92, 'car', 0, 0, 11.0, 1),
+    (2, 1, 2, 0.123, 116.03, 39.93, 'car', 0, 0, 12.0, 1),
+    (3, 1, 2, 0.123, 116.04, 39.94, 'car', 0, 0, 13.0, 1),
+    (4, 1, 2, 0.123, 116.05, 39.95, 'car', 0, 0, 14.0, 1),
+    (0, 2, 4, 1.5, 117.01, 41.01, 'bus', 0, 0, 8.0, 20),
+    (1, 2, 4, 1.5, 117.02, 41.02, 'bus', 0, 0, 8.0, 20);
+
+CREATE TABLE test_s_people (
+    step INT NOT NULL,
+    id INT NOT NULL,
+    parent_id INT NOT NULL,
+    direction FLOAT8 NOT NULL,
+    lng FLOAT8 NOT NULL,
+    lat FLOAT8 NOT NULL,
+    z FLOAT8 NOT NULL,
+    v FLOAT8 NOT NULL,
+    model TEXT NOT NULL
+);
+INSERT INTO test_s_people VALUES
+    (0, 10, 500000001, 0.5, 116.05, 39.95, 0, 1.2, 'person'),
+    (1, 10, 500000001, 0.5, 116.05, 39.95, 0, 1.2, 'person'),
+    (2, 10, 1, 0.5, 116.06, 39.96, 0, 1.3, 'person');
+
+CREATE TABLE test_s_traffic_light (
+    step INT NOT NULL,
+    id INT NOT NULL,
+    state INT NOT NULL
+);
+INSERT INTO test_s_traffic_light VALUES
+    (0, 3, 1),
+    (1, 3, 1),
+    (2, 3, 2),
+    (3, 3, 2),
+    (4, 3, 3),
+    (5, 3, 1),
+    (6, 3, 1),
+    (7, 3, 2),
+    (8, 3, 2),
+    (9, 3, 3);
+
+-- 每5步记录一次 recorded every 5 steps
+CREATE TABLE test_s_road (
+    step INT NOT NULL,
+    id INT NOT NULL,
+    level INT NOT NULL
+);
+INSERT INTO test_s_road VALUES
+    (0, 1, 1),
+    (0, 2, 3),
+    (5, 1, 4),
+    (5, 2, 5);
+
+CREATE TABLE novmin_s_road (
+    step INT NOT NULL,
+    id INT NOT NULL,
+    level INT NOT NULL
+);
+INSERT INTO novmin_s_road VALUES
+    (0, 1, 2),
+    (5, 1, 3);
+
+-- 第1版的表：车辆所在车道为lane_id，没有模型、高程、俯仰角与乘客数；行人没有高程与模型
+-- Tables of version 1: the lane of a vehicle is lane_id without model, elevation, pitch or passengers;
+-- people have no elevation or model
+CREATE TABLE old_s_cars (
+    step INT NOT NULL,
+    id INT NOT NULL,
+    lane_id INT NOT NULL,
+    direction FLOAT8 NOT NULL,
+    lng FLOAT8 NOT NULL,
+    lat FLOAT8 NOT NULL,
+    v FLOAT8 NOT NULL
+);
+INSERT INTO old_s_cars VALUES
+    (0, 1, 1, 0.123, 116.01, 39.91, 10.0),
+    (1, 1, 1, 0.123, 116.02, 39.92, 11.0),
+    (2, 1, 2, 0.123, 116.03, 39.93, 12.0);
+
+CREATE TABLE old_s_people (
+    step INT NOT NULL,
+    id INT NOT NULL,
+    parent_id INT NOT NULL,
+    direction FLOAT8 NOT NULL,
+    lng FLOAT8 NOT NULL,
+    lat FLOAT8 NOT NULL,
+    v FLOAT8 NOT NULL
+);
+INSERT INTO old_s_people VALUES
+    (0, 10, 500000001, 0.5, 116.05, 39.95, 1.2);
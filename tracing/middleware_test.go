@@ -0,0 +1,61 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware)
+	r.GET("/cars/:name", func(c *gin.Context) {
+		_, span := Tracer().Start(c.Request.Context(), "sqlite Cars", trace.WithSpanKind(trace.SpanKindClient))
+		Finish(span, errors.New("boom"))
+		c.Status(http.StatusInternalServerError)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/cars/test", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("want 2 spans but got %d", len(spans))
+	}
+	client, server := spans[0], spans[1]
+	if server.Name() != "GET /cars/:name" || server.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("unexpected server span %s %v", server.Name(), server.SpanKind())
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+		server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("want the trace of the traceparent header but got %v", server.SpanContext())
+	}
+	if client.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Fatal("want the client span to be a child of the server span")
+	}
+	attrs := attribute.NewSet(server.Attributes()...)
+	if v, _ := attrs.Value("http.route"); v.AsString() != "/cars/:name" {
+		t.Fatalf("unexpected attributes %v", server.Attributes())
+	}
+	if v, _ := attrs.Value("http.response.status_code"); v.AsInt64() != 500 {
+		t.Fatalf("unexpected attributes %v", server.Attributes())
+	}
+	if server.Status().Code != codes.Error || client.Status().Description != "boom" || len(client.Events()) != 1 {
+		t.Fatalf("unexpected status %v %v", server.Status(), client.Status())
+	}
+}
@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.fiblab.net/sim/backend/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 本服务的instrumentation scope Instrumentation scope of this service
+const scope = "git.fiblab.net/sim/backend"
+
+// 全局TracerProvider的tracer，未启用导出时为noop The tracer of the global TracerProvider, noop if the export is disabled
+func Tracer() trace.Tracer {
+	return otel.Tracer(scope)
+}
+
+// 按配置设置全局的OpenTelemetry TracerProvider，返回的shutdown导出剩余的span；exporter为none时不启用
+// Set the global OpenTelemetry TracerProvider by the config, the returned shutdown exports the remaining spans;
+// disabled if the exporter is none
+func Init(c config.Tracing) (shutdown func(ctx context.Context) error, err error) {
+	var exp sdktrace.SpanExporter
+	var file *os.File
+	switch c.Exporter {
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	case "file":
+		if file, err = os.OpenFile(c.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+			return nil, fmt.Errorf("open trace file: %w", err)
+		}
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case "otlp":
+		// 与OTEL_EXPORTER_OTLP_ENDPOINT的约定一致，追加traces的路径 Append the path of traces as OTEL_EXPORTER_OTLP_ENDPOINT does
+		url := strings.TrimSuffix(c.OTLPEndpoint, "/") + "/v1/traces"
+		exp, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(url))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %s", c.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s trace exporter: %w", c.Exporter, err)
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(c.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}, nil
+}
+
+// 结束span，err不为nil时记录错误并将状态设为错误
+// End the span, the error is recorded and the status is set to error if err is not nil
+func Finish(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
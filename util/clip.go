@@ -0,0 +1,263 @@
+package util
+
+import (
+	"math"
+	"sort"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// 以多边形裁剪折线与多边形，orb/clip只支持矩形范围
+// Clip line strings and polygons by polygons, orb/clip supports bounds only
+
+// 线段ab与cd的交点，共线重叠时为落在另一线段上的端点；不相交时返回空
+// Intersection points of the segments ab and cd, the endpoints lying on the other segment for collinear overlaps;
+// empty if they do not intersect
+func segmentIntersections(a, b, c, d orb.Point) []orb.Point {
+	if !SegmentsIntersect(a, b, c, d) {
+		return nil
+	}
+	o1, o2 := orientation(a, b, c), orientation(a, b, d)
+	o3, o4 := orientation(c, d, a), orientation(c, d, b)
+	if o1 != 0 || o2 != 0 || o3 != 0 || o4 != 0 {
+		// 端点在另一线段上时直接使用端点，避免浮点误差 use the endpoint lying on the other segment to avoid rounding
+		switch {
+		case o1 == 0 && onSegment(a, b, c):
+			return []orb.Point{c}
+		case o2 == 0 && onSegment(a, b, d):
+			return []orb.Point{d}
+		case o3 == 0 && onSegment(c, d, a):
+			return []orb.Point{a}
+		case o4 == 0 && onSegment(c, d, b):
+			return []orb.Point{b}
+		}
+		t := o3 / (o3 - o4)
+		return []orb.Point{{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}}
+	}
+	res := make([]orb.Point, 0, 4)
+	for _, p := range []struct{ s, e, p orb.Point }{{a, b, c}, {a, b, d}, {c, d, a}, {c, d, b}} {
+		if onSegment(p.s, p.e, p.p) {
+			res = append(res, p.p)
+		}
+	}
+	return res
+}
+
+// 有向边 Directed edge
+type edge struct {
+	from, to orb.Point
+}
+
+// 在splits的各点处切分线段ab，按到a的距离排序并去重
+// Split the segment ab at the points of splits, sorted by the distance to a without duplicates
+func splitSegment(a, b orb.Point, splits []orb.Point) []edge {
+	points := append([]orb.Point{a, b}, splits...)
+	sort.Slice(points, func(i, j int) bool {
+		return squaredDistance(a, points[i]) < squaredDistance(a, points[j])
+	})
+	edges := make([]edge, 0, len(points)-1)
+	for i := 1; i < len(points); i++ {
+		if points[i] != points[i-1] {
+			edges = append(edges, edge{points[i-1], points[i]})
+		}
+	}
+	return edges
+}
+
+func squaredDistance(a, b orb.Point) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	return dx*dx + dy*dy
+}
+
+func midpoint(e edge) orb.Point {
+	return orb.Point{(e.from[0] + e.to[0]) / 2, (e.from[1] + e.to[1]) / 2}
+}
+
+// 多边形的所有边，外环逆时针、内环顺时针，即内部在边的左侧
+// All edges of the polygons, the outer rings counter-clockwise and the inner rings clockwise, i.e. the interior is
+// on the left of the edges
+func polygonEdges(mp orb.MultiPolygon) []edge {
+	edges := make([]edge, 0)
+	for _, p := range mp {
+		for i, ring := range p {
+			if len(ring) < 3 {
+				continue
+			}
+			ring = closed(ring)
+			if outer := i == 0; outer != (ring.Orientation() == orb.CCW) {
+				ring = ring.Clone()
+				ring.Reverse()
+			}
+			for j := 1; j < len(ring); j++ {
+				if ring[j] != ring[j-1] {
+					edges = append(edges, edge{ring[j-1], ring[j]})
+				}
+			}
+		}
+	}
+	return edges
+}
+
+func closed(r orb.Ring) orb.Ring {
+	if r[0] == r[len(r)-1] {
+		return r
+	}
+	return append(r[:len(r):len(r)], r[0])
+}
+
+// 在两组边的交点处互相切分 Split the two groups of edges at their intersections with each other
+func splitEdges(a, b []edge) (splitA, splitB []edge) {
+	pointsA, pointsB := make([][]orb.Point, len(a)), make([][]orb.Point, len(b))
+	for i, ea := range a {
+		ba := orb.MultiPoint{ea.from, ea.to}.Bound()
+		for j, eb := range b {
+			if !ba.Intersects(orb.MultiPoint{eb.from, eb.to}.Bound()) {
+				continue
+			}
+			// 同一个交点同时加入两条边，保证切分后的端点完全一致
+			// the same point is added to both edges so that the endpoints after splitting are identical
+			for _, p := range segmentIntersections(ea.from, ea.to, eb.from, eb.to) {
+				pointsA[i] = append(pointsA[i], p)
+				pointsB[j] = append(pointsB[j], p)
+			}
+		}
+	}
+	for i, ea := range a {
+		splitA = append(splitA, splitSegment(ea.from, ea.to, pointsA[i])...)
+	}
+	for j, eb := range b {
+		splitB = append(splitB, splitSegment(eb.from, eb.to, pointsB[j])...)
+	}
+	return splitA, splitB
+}
+
+// 在多边形边界处裁剪折线，返回多边形内（含边界上）的各段
+// Cut the line string at the boundary of the polygons and return the pieces inside (or on the boundary of) them
+func ClipLineStringByPolygon(line orb.LineString, mp orb.MultiPolygon) orb.MultiLineString {
+	if len(line) < 2 || !line.Bound().Intersects(mp.Bound()) {
+		return nil
+	}
+	segments := make([]edge, 0, len(line)-1)
+	for i := 1; i < len(line); i++ {
+		segments = append(segments, edge{line[i-1], line[i]})
+	}
+	pieces, _ := splitEdges(segments, polygonEdges(mp))
+	res := orb.MultiLineString{}
+	var current orb.LineString
+	for _, e := range pieces {
+		if !planar.MultiPolygonContains(mp, midpoint(e)) {
+			current = nil
+			continue
+		}
+		if current == nil || current[len(current)-1] != e.from {
+			res = append(res, orb.LineString{e.from})
+			current = res[len(res)-1]
+		}
+		current = append(current, e.to)
+		res[len(res)-1] = current
+	}
+	return res
+}
+
+// 多边形a与b的交集，a与b各自的多边形之间不应重叠；交集为空时返回nil
+// Intersection of the polygons a and b, the polygons of a (or b) should not overlap each other; nil if empty
+func IntersectPolygons(a, b orb.MultiPolygon) orb.MultiPolygon {
+	if len(a) == 0 || len(b) == 0 || !a.Bound().Intersects(b.Bound()) {
+		return nil
+	}
+	edgesA, edgesB := splitEdges(polygonEdges(a), polygonEdges(b))
+	onB := make(map[edge]bool, len(edgesB))
+	for _, e := range edgesB {
+		onB[e] = true
+	}
+	onA := make(map[edge]bool, len(edgesA))
+	for _, e := range edgesA {
+		onA[e] = true
+	}
+	// 保留在另一组多边形内的边；重合的边只在同向时保留一次（内部在同一侧）
+	// Keep the edges inside the other polygons; coincident edges are kept once if they have the same direction
+	// (the interiors are on the same side)
+	kept := make([]edge, 0)
+	for _, e := range edgesA {
+		if onB[e] || !onB[edge{e.to, e.from}] && planar.MultiPolygonContains(b, midpoint(e)) {
+			kept = append(kept, e)
+		}
+	}
+	for _, e := range edgesB {
+		if !onA[e] && !onA[edge{e.to, e.from}] && planar.MultiPolygonContains(a, midpoint(e)) {
+			kept = append(kept, e)
+		}
+	}
+	return assemblePolygons(linkRings(kept))
+}
+
+// 将内部在左侧的有向边连接成环：在每个顶点选择从来向顺时针转过的第一条边，使相接的环保持分离
+// Link the directed edges with the interior on the left into rings: at every vertex the first edge clockwise from
+// the reversed incoming edge is taken, so that rings touching at a vertex stay apart
+func linkRings(edges []edge) []orb.Ring {
+	outgoing := make(map[orb.Point][]int)
+	for i, e := range edges {
+		outgoing[e.from] = append(outgoing[e.from], i)
+	}
+	used := make([]bool, len(edges))
+	rings := make([]orb.Ring, 0)
+	for start := range edges {
+		if used[start] {
+			continue
+		}
+		ring := orb.Ring{edges[start].from}
+		for i := start; i >= 0 && !used[i]; {
+			used[i] = true
+			e := edges[i]
+			ring = append(ring, e.to)
+			back := math.Atan2(e.from[1]-e.to[1], e.from[0]-e.to[0])
+			next, best := -1, math.Inf(1)
+			for _, j := range outgoing[e.to] {
+				if used[j] && j != start {
+					continue
+				}
+				out := math.Atan2(edges[j].to[1]-edges[j].from[1], edges[j].to[0]-edges[j].from[0])
+				turn := math.Mod(back-out+4*math.Pi, 2*math.Pi)
+				if turn == 0 {
+					turn = 2 * math.Pi
+				}
+				if turn < best {
+					next, best = j, turn
+				}
+			}
+			i = next
+		}
+		// 只保留闭合且有面积的环 keep the closed rings with an area only
+		if len(ring) >= 4 && ring[0] == ring[len(ring)-1] && planar.Area(ring) != 0 {
+			rings = append(rings, ring)
+		}
+	}
+	return rings
+}
+
+// 逆时针的环为外环，顺时针的环为所在的最小外环的内环
+// Counter-clockwise rings are outer rings, clockwise rings are the inner rings of the smallest outer ring around them
+func assemblePolygons(rings []orb.Ring) orb.MultiPolygon {
+	var res orb.MultiPolygon
+	holes := make([]orb.Ring, 0)
+	for _, r := range rings {
+		if r.Orientation() == orb.CCW {
+			res = append(res, orb.Polygon{r})
+		} else {
+			holes = append(holes, r)
+		}
+	}
+	for _, h := range holes {
+		owner, area := -1, math.Inf(1)
+		for i, p := range res {
+			if a := planar.Area(p[0]); a < area && p[0].Bound().Contains(h[0]) && planar.RingContains(p[0], h[0]) {
+				owner, area = i, a
+			}
+		}
+		if owner >= 0 {
+			res[owner] = append(res[owner], h)
+		}
+	}
+	return res
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// U形的凹多边形 A concave U-shaped polygon
+var uShape = orb.Polygon{{{0, 0}, {3, 0}, {3, 3}, {2, 3}, {2, 1}, {1, 1}, {1, 3}, {0, 3}, {0, 0}}}
+
+func rect(minX, minY, maxX, maxY float64) orb.Polygon {
+	return orb.Bound{Min: orb.Point{minX, minY}, Max: orb.Point{maxX, maxY}}.ToPolygon()
+}
+
+func TestClipLineStringByPolygon(t *testing.T) {
+	cases := []struct {
+		line orb.LineString
+		mp   orb.MultiPolygon
+		want orb.MultiLineString
+	}{
+		// 穿过凹多边形的两臂 crossing both arms of the concave polygon
+		{orb.LineString{{-1, 2}, {4, 2}}, orb.MultiPolygon{uShape}, orb.MultiLineString{{{0, 2}, {1, 2}}, {{2, 2}, {3, 2}}}},
+		// 节点在内部的部分保留原有节点 the nodes inside are kept
+		{orb.LineString{{-1, 0.5}, {1.5, 0.5}, {1.5, -1}}, orb.MultiPolygon{uShape}, orb.MultiLineString{{{0, 0.5}, {1.5, 0.5}, {1.5, 0}}}},
+		{orb.LineString{{-1, 4}, {4, 4}}, orb.MultiPolygon{uShape}, nil},
+	}
+	for _, c := range cases {
+		got := ClipLineStringByPolygon(c.line, c.mp)
+		if len(got) != len(c.want) || len(got) > 0 && !got.Equal(c.want) {
+			t.Errorf("ClipLineStringByPolygon(%v) = %v, want %v", c.line, got, c.want)
+		}
+	}
+}
+
+func TestIntersectPolygons(t *testing.T) {
+	holed := orb.Polygon{rect(0, 0, 4, 4)[0], rect(1, 1, 3, 3)[0]}
+	clockwise := rect(1, 1, 3, 3).Clone()
+	clockwise[0].Reverse()
+	cases := []struct {
+		name     string
+		a, b     orb.Polygon
+		polygons int
+		area     float64
+	}{
+		{"overlapping squares", rect(0, 0, 2, 2), rect(1, 1, 3, 3), 1, 1},
+		{"clockwise input", rect(0, 0, 2, 2), clockwise, 1, 1},
+		{"concave polygon cut into two", uShape, rect(-1, 1.5, 4, 2.5), 2, 2},
+		{"polygon with a hole", holed, rect(-1, 1.5, 5, 2.5), 2, 2},
+		{"inside", rect(0, 0, 4, 4), rect(1, 1, 2, 2), 1, 1},
+		{"in the hole", holed, rect(1.5, 1.5, 2.5, 2.5), 0, 0},
+		{"touching edges", rect(0, 0, 2, 2), rect(2, 0, 4, 2), 0, 0},
+		{"shared edge", rect(0, 0, 2, 2), rect(0, 1, 2, 3), 1, 2},
+		{"disjoint", rect(0, 0, 1, 1), rect(2, 2, 3, 3), 0, 0},
+	}
+	for _, c := range cases {
+		got := IntersectPolygons(orb.MultiPolygon{c.a}, orb.MultiPolygon{c.b})
+		if len(got) != c.polygons || math.Abs(planar.Area(got)-c.area) > 1e-9 {
+			t.Errorf("%s: got %d polygons with area %v, want %d with area %v: %v",
+				c.name, len(got), planar.Area(got), c.polygons, c.area, got)
+		}
+	}
+	// 结果与参数顺序无关 the result does not depend on the order of the arguments
+	if got := IntersectPolygons(orb.MultiPolygon{rect(-1, 1.5, 5, 2.5)}, orb.MultiPolygon{holed}); len(got) != 2 || planar.Area(got) != 2 {
+		t.Errorf("unexpected intersection %v", got)
+	}
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 列式二进制格式 Binary columnar format
+//
+// 所有数值均为小端序，每一列的数据起点按8字节对齐，前端可以直接用TypedArray读取
+// All numbers are little-endian and every column payload starts at an 8-byte aligned
+// offset, so the frontend can wrap it with a TypedArray without copying.
+//
+//	offset 0   magic "MOSC"
+//	offset 4   uint16 format version (1)
+//	offset 6   uint16 number of columns
+//	offset 8   uint32 number of rows
+//	offset 12  uint32 reserved (0)
+//	offset 16  columns, one after another:
+//	             uint8  column type (see ColumnType)
+//	             uint8  length of the column name
+//	             bytes  column name (UTF-8)
+//	             padding to 8 bytes
+//	             [ColumnString only] uint32 dictionary size, then for each entry
+//	                                 uint16 length + bytes, then padding to 8 bytes
+//	             payload: rows * size of the element type
+//	             padding to 8 bytes
+//
+// ColumnString的payload是int32的字典下标 The payload of ColumnString is int32 indices into its dictionary.
+
+const (
+	ColumnarContentType = "application/vnd.moss.columnar"
+	ColumnarVersion     = 1
+	columnarMagic       = "MOSC"
+)
+
+type ColumnType uint8
+
+const (
+	ColumnInt32   ColumnType = 1
+	ColumnFloat32 ColumnType = 2
+	ColumnFloat64 ColumnType = 3
+	ColumnString  ColumnType = 4
+)
+
+type columnarColumn struct {
+	name    string
+	typ     ColumnType
+	dict    []string
+	payload []byte
+}
+
+// 按列组织的表，列长度均为rows
+// Table organized by columns, every column has exactly rows elements
+type Columnar struct {
+	rows    int
+	columns []columnarColumn
+}
+
+func NewColumnar(rows int) *Columnar {
+	return &Columnar{rows: rows}
+}
+
+func (t *Columnar) AddInt32(name string, get func(i int) int32) {
+	payload := make([]byte, 4*t.rows)
+	for i := 0; i < t.rows; i++ {
+		binary.LittleEndian.PutUint32(payload[4*i:], uint32(get(i)))
+	}
+	t.columns = append(t.columns, columnarColumn{name: name, typ: ColumnInt32, payload: payload})
+}
+
+func (t *Columnar) AddFloat32(name string, get func(i int) float32) {
+	payload := make([]byte, 4*t.rows)
+	for i := 0; i < t.rows; i++ {
+		binary.LittleEndian.PutUint32(payload[4*i:], math.Float32bits(get(i)))
+	}
+	t.columns = append(t.columns, columnarColumn{name: name, typ: ColumnFloat32, payload: payload})
+}
+
+func (t *Columnar) AddFloat64(name string, get func(i int) float64) {
+	payload := make([]byte, 8*t.rows)
+	for i := 0; i < t.rows; i++ {
+		binary.LittleEndian.PutUint64(payload[8*i:], math.Float64bits(get(i)))
+	}
+	t.columns = append(t.columns, columnarColumn{name: name, typ: ColumnFloat64, payload: payload})
+}
+
+// 字符串列采用字典编码 String columns are dictionary encoded
+func (t *Columnar) AddString(name string, get func(i int) string) {
+	dict := make([]string, 0)
+	index := make(map[string]int32)
+	payload := make([]byte, 4*t.rows)
+	for i := 0; i < t.rows; i++ {
+		s := get(i)
+		id, ok := index[s]
+		if !ok {
+			id = int32(len(dict))
+			index[s] = id
+			dict = append(dict, s)
+		}
+		binary.LittleEndian.PutUint32(payload[4*i:], uint32(id))
+	}
+	t.columns = append(t.columns, columnarColumn{name: name, typ: ColumnString, dict: dict, payload: payload})
+}
+
+func (t *Columnar) Bytes() []byte {
+	buf := make([]byte, 16)
+	copy(buf, columnarMagic)
+	binary.LittleEndian.PutUint16(buf[4:], ColumnarVersion)
+	binary.LittleEndian.PutUint16(buf[6:], uint16(len(t.columns)))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(t.rows))
+	pad := func() {
+		for len(buf)%8 != 0 {
+			buf = append(buf, 0)
+		}
+	}
+	for _, col := range t.columns {
+		buf = append(buf, byte(col.typ), byte(len(col.name)))
+		buf = append(buf, col.name...)
+		pad()
+		if col.typ == ColumnString {
+			buf = append(buf, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(buf[len(buf)-4:], uint32(len(col.dict)))
+			for _, s := range col.dict {
+				buf = append(buf, 0, 0)
+				binary.LittleEndian.PutUint16(buf[len(buf)-2:], uint16(len(s)))
+				buf = append(buf, s...)
+			}
+			pad()
+		}
+		buf = append(buf, col.payload...)
+		pad()
+	}
+	return buf
+}
+
+// 判断请求是否要求列式二进制格式（query参数format=columnar或Accept头）
+// Check whether the request asks for the binary columnar format (query format=columnar or Accept header)
+func WantColumnar(c *gin.Context) bool {
+	switch c.Query("format") {
+	case "columnar":
+		return true
+	case "":
+		return strings.Contains(c.GetHeader("Accept"), ColumnarContentType)
+	default:
+		return false
+	}
+}
+
+func ResponseColumnar(c *gin.Context, t *Columnar) {
+	c.Data(200, ColumnarContentType, t.Bytes())
+}
@@ -0,0 +1,144 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 机器可读的错误码 Machine-readable error codes
+type ErrorCode string
+
+const (
+	CodeBadRequest         ErrorCode = "bad_request"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeSimulationNotFound ErrorCode = "simulation_not_found" // details: simulation
+	CodeMapNotFound        ErrorCode = "map_not_found"        // details: map
+	CodeTableNotFound      ErrorCode = "table_not_found"
+	CodeNoRoadStatus       ErrorCode = "no_road_status" // details: simulation
+	CodeConflict           ErrorCode = "conflict"
+	CodeUnsupportedVersion ErrorCode = "unsupported_version" // details: version, supportedVersions
+	CodeTimeout            ErrorCode = "timeout"
+	CodeRateLimited        ErrorCode = "rate_limited" // details: retryAfter
+	CodeInternal           ErrorCode = "internal"
+)
+
+// 错误码对应的HTTP状态码 HTTP status codes of the error codes
+var codeStatus = map[ErrorCode]int{
+	CodeBadRequest:         http.StatusBadRequest,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeSimulationNotFound: http.StatusNotFound,
+	CodeMapNotFound:        http.StatusNotFound,
+	CodeTableNotFound:      http.StatusNotFound,
+	CodeNoRoadStatus:       http.StatusBadRequest,
+	CodeConflict:           http.StatusConflict,
+	CodeUnsupportedVersion: http.StatusUnprocessableEntity,
+	CodeTimeout:            http.StatusRequestTimeout,
+	CodeRateLimited:        http.StatusTooManyRequests,
+	CodeInternal:           http.StatusInternalServerError,
+}
+
+func (c ErrorCode) Status() int {
+	if status, ok := codeStatus[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// 带错误码与详情的错误，由AbortWithError统一返回给客户端
+// Error with a code and details, returned to the client by AbortWithError
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Details map[string]any // 如缺失的模拟或表 e.g. the missing simulation or table
+	cause   error
+}
+
+func NewError(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// 以err的信息创建错误，err可由errors.Unwrap取回 Create an error with the message of err, which can be retrieved by errors.Unwrap
+func WrapError(code ErrorCode, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), cause: err}
+}
+
+// 添加一项详情 Add a detail
+func (e *Error) With(key string, value any) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]any)
+	}
+	e.Details[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+func ErrSimulationNotFound(name string) *Error {
+	return NewError(CodeSimulationNotFound, "simulation %s not found", name).With("simulation", name)
+}
+
+// 转换为带错误码的错误，未知错误为internal Convert to an error with a code, unknown errors are internal
+func AsError(err error) *Error {
+	e := &Error{}
+	if errors.As(err, &e) {
+		return e
+	}
+	if CheckIsTableNotFound(err) {
+		return WrapError(CodeTableNotFound, err)
+	}
+	return WrapError(CodeInternal, err)
+}
+
+// 请求中返回给客户端的最后一个错误，供请求日志使用；timeout中间件复制了gin.Context，因此经由请求的context传递
+// The last error returned to the client in a request, used by the request log; it is passed by the context of the
+// request since the timeout middleware copies gin.Context
+type ErrorSink struct {
+	mu  sync.Mutex
+	err error
+}
+
+type errorSinkKey struct{}
+
+func WithErrorSink(ctx context.Context) (context.Context, *ErrorSink) {
+	s := &ErrorSink{}
+	return context.WithValue(ctx, errorSinkKey{}, s), s
+}
+
+func (s *ErrorSink) Last() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// 记录请求的错误供请求日志使用，ctx中没有ErrorSink时忽略
+// Record the error of the request for the request log, ignored if there is no ErrorSink in ctx
+func RecordError(ctx context.Context, err error) {
+	if s, ok := ctx.Value(errorSinkKey{}).(*ErrorSink); ok {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+	}
+}
+
+// 记录错误（供请求日志使用）并以统一的错误结构中止请求
+// Record the error (for the request log) and abort the request with the common error shape
+func AbortWithError(c *gin.Context, err error) {
+	e := AsError(err)
+	RecordError(c.Request.Context(), err)
+	c.AbortWithStatusJSON(e.Code.Status(), NewErrorResponse(e))
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+var ErrNoPolygon = errors.New("GeoJSON should contain a Polygon or MultiPolygon")
+
+// 解析GeoJSON中的多边形，支持Geometry、Feature与FeatureCollection（合并所有多边形）
+// Parse the polygons in GeoJSON, Geometry, Feature and FeatureCollection (all polygons merged) are supported
+func ParseGeoJSONPolygon(data []byte) (orb.MultiPolygon, error) {
+	var doc struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("bad GeoJSON: %w", err)
+	}
+	var geometries []orb.Geometry
+	switch doc.Type {
+	case "FeatureCollection":
+		fc, err := geojson.UnmarshalFeatureCollection(data)
+		if err != nil {
+			return nil, fmt.Errorf("bad GeoJSON: %w", err)
+		}
+		for _, f := range fc.Features {
+			geometries = append(geometries, f.Geometry)
+		}
+	case "Feature":
+		f, err := geojson.UnmarshalFeature(data)
+		if err != nil {
+			return nil, fmt.Errorf("bad GeoJSON: %w", err)
+		}
+		geometries = append(geometries, f.Geometry)
+	default:
+		g, err := geojson.UnmarshalGeometry(data)
+		if err != nil {
+			return nil, fmt.Errorf("bad GeoJSON: %w", err)
+		}
+		geometries = append(geometries, g.Geometry())
+	}
+	mp := make(orb.MultiPolygon, 0)
+	for _, g := range geometries {
+		switch g := g.(type) {
+		case orb.Polygon:
+			mp = append(mp, g)
+		case orb.MultiPolygon:
+			mp = append(mp, g...)
+		}
+	}
+	if len(mp) == 0 {
+		return nil, ErrNoPolygon
+	}
+	return mp, nil
+}
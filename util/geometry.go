@@ -0,0 +1,190 @@
+package util
+
+import (
+	"sort"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// 判断线段与矩形范围是否相交（Liang-Barsky裁剪）
+// Check whether the segment ab intersects the bound (Liang-Barsky clipping)
+func SegmentIntersectsBound(a, b orb.Point, bound orb.Bound) bool {
+	t0, t1 := 0.0, 1.0
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	for _, pq := range [4][2]float64{
+		{-dx, a[0] - bound.Min[0]},
+		{dx, bound.Max[0] - a[0]},
+		{-dy, a[1] - bound.Min[1]},
+		{dy, bound.Max[1] - a[1]},
+	} {
+		p, q := pq[0], pq[1]
+		if p == 0 {
+			// 与该边界平行 parallel to the edge
+			if q < 0 {
+				return false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			if r > t1 {
+				return false
+			}
+			if r > t0 {
+				t0 = r
+			}
+		} else {
+			if r < t0 {
+				return false
+			}
+			if r < t1 {
+				t1 = r
+			}
+		}
+	}
+	return true
+}
+
+// 判断折线与矩形范围是否相交 Check whether the line string intersects the bound
+func LineIntersectsBound(line orb.LineString, bound orb.Bound) bool {
+	if len(line) == 1 {
+		return bound.Contains(line[0])
+	}
+	for i := 1; i < len(line); i++ {
+		if SegmentIntersectsBound(line[i-1], line[i], bound) {
+			return true
+		}
+	}
+	return false
+}
+
+// 点c相对有向线段ab的方向，>0为左侧，<0为右侧，0为共线
+// Orientation of the point c relative to the directed segment ab, >0 on the left, <0 on the right, 0 if collinear
+func orientation(a, b, c orb.Point) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// 共线的点c是否在线段ab的范围内 Whether the collinear point c is within the segment ab
+func onSegment(a, b, c orb.Point) bool {
+	return orb.MultiPoint{a, b}.Bound().Contains(c)
+}
+
+// 判断线段ab与cd是否相交（含端点与共线重叠） Check whether the segments ab and cd intersect (endpoints and collinear overlaps included)
+func SegmentsIntersect(a, b, c, d orb.Point) bool {
+	o1, o2 := orientation(a, b, c), orientation(a, b, d)
+	o3, o4 := orientation(c, d, a), orientation(c, d, b)
+	if (o1 > 0 && o2 < 0 || o1 < 0 && o2 > 0) && (o3 > 0 && o4 < 0 || o3 < 0 && o4 > 0) {
+		return true
+	}
+	return o1 == 0 && onSegment(a, b, c) || o2 == 0 && onSegment(a, b, d) ||
+		o3 == 0 && onSegment(c, d, a) || o4 == 0 && onSegment(c, d, b)
+}
+
+// 折线是否与多边形的某条边相交 Whether the line string intersects an edge of the polygons
+func lineCrossesRings(line orb.LineString, mp orb.MultiPolygon) bool {
+	for _, p := range mp {
+		for _, ring := range p {
+			for i := 1; i < len(ring); i++ {
+				for j := 1; j < len(line); j++ {
+					if SegmentsIntersect(line[j-1], line[j], ring[i-1], ring[i]) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// 判断折线与多边形是否相交，即有节点在多边形内或与边相交
+// Check whether the line string intersects the polygons, i.e. a node is inside or it crosses an edge
+func LineIntersectsPolygon(line orb.LineString, mp orb.MultiPolygon) bool {
+	if len(line) == 0 || !line.Bound().Intersects(mp.Bound()) {
+		return false
+	}
+	if planar.MultiPolygonContains(mp, line[0]) {
+		return true
+	}
+	return lineCrossesRings(line, mp)
+}
+
+// 判断多边形与矩形范围是否相交，即有边与范围相交或范围在多边形内
+// Check whether the polygon intersects the bound, i.e. an edge intersects the bound or the bound is inside the polygon
+func PolygonIntersectsBound(p orb.Polygon, bound orb.Bound) bool {
+	if len(p) == 0 || !p.Bound().Intersects(bound) {
+		return false
+	}
+	for _, ring := range p {
+		if LineIntersectsBound(orb.LineString(ring), bound) {
+			return true
+		}
+	}
+	return planar.PolygonContains(p, bound.Center())
+}
+
+// 判断两组多边形是否相交，即边相交或其中一个在另一个内
+// Check whether the two groups of polygons intersect, i.e. their edges cross or one is inside the other
+func PolygonsIntersect(a, b orb.MultiPolygon) bool {
+	if len(a) == 0 || len(b) == 0 || !a.Bound().Intersects(b.Bound()) {
+		return false
+	}
+	for _, p := range a {
+		for _, ring := range p {
+			if lineCrossesRings(orb.LineString(ring), b) {
+				return true
+			}
+		}
+	}
+	return containsFirstPoint(b, a) || containsFirstPoint(a, b)
+}
+
+// outer是否包含inner的第一个点 Whether outer contains the first point of inner
+func containsFirstPoint(outer, inner orb.MultiPolygon) bool {
+	for _, p := range inner {
+		for _, ring := range p {
+			if len(ring) > 0 {
+				return planar.MultiPolygonContains(outer, ring[0])
+			}
+		}
+	}
+	return false
+}
+
+// 点集的凸包（Andrew单调链），逆时针且首尾闭合；少于3个不共线的点时返回nil
+// Convex hull of the points (Andrew's monotone chain), counter-clockwise and closed;
+// nil if there are less than 3 non-collinear points
+func ConvexHull(points []orb.Point) orb.Ring {
+	ps := make([]orb.Point, len(points))
+	copy(ps, points)
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i][0] != ps[j][0] {
+			return ps[i][0] < ps[j][0]
+		}
+		return ps[i][1] < ps[j][1]
+	})
+	if len(ps) < 3 {
+		return nil
+	}
+	hull := make(orb.Ring, 0, 2*len(ps))
+	// 下链 lower chain
+	for _, p := range ps {
+		for len(hull) >= 2 && orientation(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	// 上链 upper chain
+	lower := len(hull) + 1
+	for i := len(ps) - 2; i >= 0; i-- {
+		for len(hull) >= lower && orientation(hull[len(hull)-2], hull[len(hull)-1], ps[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, ps[i])
+	}
+	// 首尾闭合，至少3个不同的点 closed, with at least 3 distinct points
+	if len(hull) < 4 {
+		return nil
+	}
+	return hull
+}
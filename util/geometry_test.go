@@ -0,0 +1,78 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+var square = orb.Polygon{{{0, 0}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}}
+
+func TestLineIntersectsBound(t *testing.T) {
+	b := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}
+	cases := []struct {
+		line orb.LineString
+		want bool
+	}{
+		{orb.LineString{{0.5, 0.5}, {3, 3}}, true},
+		// 穿过范围但没有节点在范围内 crossing without a node inside
+		{orb.LineString{{-1, 0.5}, {2, 0.5}}, true},
+		{orb.LineString{{-1, 2}, {2, 2}}, false},
+		{orb.LineString{{0.5, 0.5}}, true},
+	}
+	for _, c := range cases {
+		if got := LineIntersectsBound(c.line, b); got != c.want {
+			t.Errorf("LineIntersectsBound(%v) = %v, want %v", c.line, got, c.want)
+		}
+	}
+}
+
+func TestLineIntersectsPolygon(t *testing.T) {
+	mp := orb.MultiPolygon{square}
+	cases := []struct {
+		line orb.LineString
+		want bool
+	}{
+		{orb.LineString{{1, 1}, {1, 1.5}}, true},
+		{orb.LineString{{-1, 1}, {3, 1}}, true},
+		{orb.LineString{{-1, -1}, {3, -1}}, false},
+		// 外包矩形相交但不相交 overlapping bounds without intersection
+		{orb.LineString{{-1, 1}, {1, 3}}, true},
+		{orb.LineString{{-1, 1.5}, {0.4, 3}}, false},
+	}
+	for _, c := range cases {
+		if got := LineIntersectsPolygon(c.line, mp); got != c.want {
+			t.Errorf("LineIntersectsPolygon(%v) = %v, want %v", c.line, got, c.want)
+		}
+	}
+}
+
+func TestPolygonIntersects(t *testing.T) {
+	inside := orb.Bound{Min: orb.Point{0.5, 0.5}, Max: orb.Point{1, 1}}
+	crossing := orb.Bound{Min: orb.Point{1, -1}, Max: orb.Point{3, 1}}
+	outside := orb.Bound{Min: orb.Point{3, 3}, Max: orb.Point{4, 4}}
+	if !PolygonIntersectsBound(square, inside) || !PolygonIntersectsBound(square, crossing) || PolygonIntersectsBound(square, outside) {
+		t.Errorf("unexpected PolygonIntersectsBound")
+	}
+	mp := orb.MultiPolygon{square}
+	if !PolygonsIntersect(mp, orb.MultiPolygon{inside.ToPolygon()}) ||
+		!PolygonsIntersect(orb.MultiPolygon{inside.ToPolygon()}, mp) ||
+		!PolygonsIntersect(mp, orb.MultiPolygon{crossing.ToPolygon()}) ||
+		PolygonsIntersect(mp, orb.MultiPolygon{outside.ToPolygon()}) {
+		t.Errorf("unexpected PolygonsIntersect")
+	}
+}
+
+func TestConvexHull(t *testing.T) {
+	hull := ConvexHull([]orb.Point{{0, 0}, {2, 0}, {1, 1}, {2, 2}, {0, 2}, {1, 0}})
+	want := orb.Ring{{0, 0}, {2, 0}, {2, 2}, {0, 2}, {0, 0}}
+	if !hull.Equal(want) {
+		t.Errorf("ConvexHull = %v, want %v", hull, want)
+	}
+	if hull.Orientation() != orb.CCW {
+		t.Errorf("hull should be counter-clockwise")
+	}
+	if hull := ConvexHull([]orb.Point{{0, 0}, {1, 1}, {2, 2}}); hull != nil {
+		t.Errorf("collinear points should have no hull, got %v", hull)
+	}
+}
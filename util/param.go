@@ -0,0 +1,46 @@
+package util
+
+import (
+	"regexp"
+
+	"git.fiblab.net/utils/lens"
+	"github.com/gin-gonic/gin"
+)
+
+// 与lens一致的模拟名格式 Simulation name format, the same as lens
+var NameChecker = regexp.MustCompile(`^([[:alpha:]_][[:alnum:]_]*|("[^"]*")+)$`)
+
+// 绑定并检查路径中的模拟名，与lens.ValidateUri相同但以带错误码的400返回，失败时返回nil
+// Bind and check the simulation name in the path, the same as lens.ValidateUri but responds 400 with an error code,
+// returns nil on failure
+func ValidateUri(c *gin.Context) *lens.Uri {
+	u := &lens.Uri{}
+	if err := c.ShouldBindUri(u); err != nil {
+		AbortWithError(c, WrapError(CodeBadRequest, err))
+		return nil
+	}
+	if !NameChecker.MatchString(u.Name) {
+		AbortWithError(c, NewError(CodeBadRequest, "%s is an invalid name", u.Name))
+		return nil
+	}
+	return u
+}
+
+// 绑定并检查请求参数，与lens.ValidateParam相同但以带错误码的400返回，失败时返回nil
+// Bind and check the request parameters, the same as lens.ValidateParam but responds 400 with an error code,
+// returns nil on failure
+func ValidateParam[T any, PT interface {
+	lens.IParam
+	*T
+}](c *gin.Context) PT {
+	var pt PT = new(T)
+	if err := c.ShouldBind(pt); err != nil {
+		AbortWithError(c, WrapError(CodeBadRequest, err))
+		return nil
+	}
+	if err := pt.Check(); err != nil {
+		AbortWithError(c, WrapError(CodeBadRequest, err))
+		return nil
+	}
+	return pt
+}
@@ -1,14 +1,18 @@
 package util
 
 type Response struct {
-	Error string `json:"error,omitempty"`
-	Data  any    `json:"data"`
+	Error   string         `json:"error,omitempty"`
+	Code    ErrorCode      `json:"code,omitempty"`    // 错误码 Error code
+	Details map[string]any `json:"details,omitempty"` // 错误详情 Error details
+	Data    any            `json:"data"`
 }
 
 func NewResponse(data any) *Response {
 	return &Response{Data: data}
 }
 
+// 错误响应，错误码由AsError决定 Error response, the code is decided by AsError
 func NewErrorResponse(err error) *Response {
-	return &Response{Error: err.Error()}
+	e := AsError(err)
+	return &Response{Error: e.Message, Code: e.Code, Details: e.Details}
 }
@@ -13,7 +13,14 @@ func ToFixed(num float64, precision int) float64 {
 	return math.Round(num*output) / output
 }
 
+// 非PostgreSQL存储后端在表不存在时返回的错误，可用errors.Is判断
+// Error returned by non-PostgreSQL storage backends when a table is missing, check it with errors.Is
+var ErrTableNotFound = errors.New("table not found")
+
 func CheckIsTableNotFound(err error) bool {
+	if errors.Is(err, ErrTableNotFound) {
+		return true
+	}
 	pgErr := &pgconn.PgError{}
 	if errors.As(err, &pgErr) {
 		if pgErr.Code == "42P01" { // table not found
@@ -23,6 +30,23 @@ func CheckIsTableNotFound(err error) bool {
 	return false
 }
 
+// 非PostgreSQL存储后端在违反唯一约束时返回的错误，可用errors.Is判断
+// Error returned by non-PostgreSQL storage backends on a unique constraint violation, check it with errors.Is
+var ErrUniqueViolation = errors.New("unique constraint violation")
+
+func CheckIsUniqueViolation(err error) bool {
+	if errors.Is(err, ErrUniqueViolation) {
+		return true
+	}
+	pgErr := &pgconn.PgError{}
+	if errors.As(err, &pgErr) {
+		if pgErr.Code == "23505" { // unique_violation
+			return true
+		}
+	}
+	return false
+}
+
 func ResponseEmptyIfTableNotFound[T any](c *gin.Context, targetHint []T, err error) (shouldReturn bool) {
 	if CheckIsTableNotFound(err) {
 		c.JSON(200, NewResponse(make([]T, 0)))